@@ -18,24 +18,124 @@ package options
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog/v2"
 
 	"github.com/spf13/pflag"
+
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/message"
+	"github.com/openyurtio/kole/pkg/util"
 )
 
 type KoleControllerFlags struct {
 	Mqtt3Flags *Mqtt3Flags
 	Mqtt5Flags *Mqtt5Flags
 	IsMqtt5    bool
+	// the tls of the connections to the mqtt broker, shared by mqtt3 and mqtt5
+	MqttTLS *message.TLSOptions
+	// the credentials connecting the mqtt broker, shared by mqtt3 and mqtt5
+	MqttAuth *message.AuthOptions
 
 	KubeConfig       string
 	NameSpace        string
 	SnapshotInterval int
+	// the number of snapshot generations kept in NameSpace
+	SnapshotHistory int
+	// the snapshot generation to restore from, -1 means the latest complete one
+	RestoreSnapshotIndex int64
+	// the secret in NameSpace holding the snapshot encryption keys, empty means no encryption
+	SnapshotEncryptionSecret string
+	// restore the snapshots saved before the encryption is enabled, which are not authenticated
+	AllowUnencryptedSnapshots bool
+	// the codec to compress snapshots, the codec of every snapshot is recorded so it can be changed at any time
+	SnapshotCodec string
+	// the compression level of the codec, nil means the default level of the codec
+	SnapshotCodecLevel *int
+	// the file of zstd dictionary used by zstd codec, empty means no dictionary
+	SnapshotCodecDictionary string
 	// s
 	HBTimeOut int
+	// the nodes offline longer than it (second) are removed, 0 means the nodes are never removed
+	NodeGCPeriod int
+	// a node is flapping if it changes its state at least FlapThreshold times within FlapWindow (second)
+	FlapWindow    int
+	FlapThreshold int
+
+	// the unique identity of the controller instance, such as the pod name in the statefulset
+	Identity string
+
+	// run as one of the controller shards, every shard owns a consistent hash range of node names
+	EnableSharding bool
+	// the unique name of the shard, it is shared by the leader and the standbys of the shard with leader election
+	ShardName string
+	// the lease duration of a shard (second), a shard is removed if its lease is not renewed in time
+	ShardLeaseDuration int
+
+	// only the leader publishes acks, pods and snapshots, the standbys consume heartbeats to keep their caches warm
+	EnableLeaderElection bool
+	// the name of the leader election lease, the shard name is appended with sharding
+	LeaderElectionID string
+	// leader election durations (second)
+	LeaderElectionLeaseDuration int
+	LeaderElectionRenewDeadline int
+	LeaderElectionRetryPeriod   int
+
+	// the max number of nodes whose heartbeats are waiting to be processed
+	HeartBeatQueueSize int
+	// the number of workers processing heartbeats
+	HeartBeatWorkers int
+	// the time (millisecond) a heartbeat waits for the full queue before it is dropped
+	HeartBeatEnqueueTimeout int
+
+	// the number of workers delivering messages to the nodes
+	DispatcherWorkers int
+	// the max number of retries before a message to a node is dropped
+	DispatcherMaxRetries int
+	// the rate limit of the messages to all the nodes
+	DispatcherQPS   float64
+	DispatcherBurst int
+	// the rate limit of the messages to a node
+	DispatcherNodeQPS   float64
+	DispatcherNodeBurst int
+
+	// the number of workers diffing and pushing the pods of the nodes
+	NodeReconcileWorkers int
+
+	// the rate limit of the registrations, 0 means the registrations are not limited
+	RegistrationQPS   float64
+	RegistrationBurst int
+
+	// the unknown nodes are pending until they are approved manually or by the auto-approval policies
+	EnableRegistrationApproval bool
+	// approve the nodes presenting a valid bootstrap token stored as a secret in NameSpace
+	ApproveBootstrapTokens bool
+	// approve the nodes whose labels match the selector, empty means the labels are not used
+	ApproveLabelSelector string
+	// approve the nodes with an internal address in any of the ranges
+	ApproveCIDRs []string
+
+	// the algorithm signing the acks and pods sent to the nodes and verifying their heartbeats, empty means messages are not signed
+	MessageSignature string
+	// the name of secret holding the master keys of hmac-sha256 or the ed25519 seeds of the controllers
+	MessageSigningSecret string
+	// accept the unsigned heartbeats as well while the signatures are rolled out
+	AllowUnsignedMessages bool
+
+	// the name of kubernetes.io/tls secret holding the CA issuing the client certificates of the nodes, empty means no certificates are issued
+	ClientCASecret string
+	// the lifetime (s) of the client certificates, the nodes renew them before they expire
+	ClientCertificateDuration int
+
+	// let the pods refer to the secrets labeled with lite.openyurt.io/pod-secret=true, which are sealed to the nodes
+	EnablePodSecrets bool
+
+	// the address serving prometheus metrics, empty means no metrics are served
+	MetricsBindAddress string
 }
 
 type Mqtt3Flags struct {
@@ -47,6 +147,10 @@ type Mqtt3Flags struct {
 
 type Mqtt5Flags struct {
 	MqttServer string
+	// the group of the shared heartbeat subscription, heartbeats are subscribed directly if it is empty
+	ShareGroup string
+	// the number of clients consuming the shared heartbeat subscription in every controller instance
+	HeartBeatConsumers int
 }
 
 func NewKoleControllerFlags() *KoleControllerFlags {
@@ -54,12 +158,53 @@ func NewKoleControllerFlags() *KoleControllerFlags {
 	if len(ns) == 0 {
 		ns = "kole"
 	}
+	identity := os.Getenv("POD_NAME")
+	if len(identity) == 0 {
+		identity, _ = os.Hostname()
+	}
 	return &KoleControllerFlags{
-		SnapshotInterval: 60,     // second
-		HBTimeOut:        60 * 5, // second
-		NameSpace:        ns,
-		Mqtt3Flags:       &Mqtt3Flags{},
-		Mqtt5Flags:       &Mqtt5Flags{},
+		SnapshotInterval:     60, // second
+		SnapshotHistory:      3,
+		RestoreSnapshotIndex: -1,
+		SnapshotCodec:        "gzip",
+		HBTimeOut:            60 * 5, // second
+		NodeGCPeriod:         data.OFFLINE_TIMEOUT,
+		FlapWindow:           60 * 10, // second
+		FlapThreshold:        6,
+		NameSpace:            ns,
+		Identity:             identity,
+		ShardLeaseDuration:   15, // second
+
+		LeaderElectionID:            "kole-controller",
+		LeaderElectionLeaseDuration: 15, // second
+		LeaderElectionRenewDeadline: 10, // second
+		LeaderElectionRetryPeriod:   2,  // second
+
+		HeartBeatQueueSize:      100000,
+		HeartBeatWorkers:        16,
+		HeartBeatEnqueueTimeout: 1000, // millisecond
+		DispatcherWorkers:       32,
+		DispatcherMaxRetries:    5,
+		DispatcherQPS:           5000,
+		DispatcherBurst:         5000,
+		DispatcherNodeQPS:       10,
+		DispatcherNodeBurst:     100,
+		NodeReconcileWorkers:    16,
+		RegistrationQPS:         1000,
+		RegistrationBurst:       2000,
+		ApproveBootstrapTokens:  true,
+		MetricsBindAddress:      ":10271",
+		// renewed by the nodes every 4 to 5 hours
+		ClientCertificateDuration: 6 * 3600,
+
+		Mqtt3Flags: &Mqtt3Flags{},
+		Mqtt5Flags: &Mqtt5Flags{
+			HeartBeatConsumers: 1,
+		},
+		MqttTLS: &message.TLSOptions{},
+		MqttAuth: &message.AuthOptions{
+			JWTLifetime: 3600,
+		},
 	}
 }
 
@@ -85,16 +230,78 @@ func (f *KoleControllerFlags) AddFlags(mainfs *pflag.FlagSet) {
 		f.Mqtt5Flags = &Mqtt5Flags{}
 	}
 
+	if f.MqttTLS == nil {
+		f.MqttTLS = &message.TLSOptions{}
+	}
+	if f.MqttAuth == nil {
+		f.MqttAuth = &message.AuthOptions{}
+	}
+
 	fs.StringVar(&f.Mqtt3Flags.MqttBroker, "mqtt3-broker", f.Mqtt3Flags.MqttBroker, "the address of mqtt broker")
 	fs.IntVar(&f.Mqtt3Flags.MqttBrokerPort, "mqtt3-broker-port", f.Mqtt3Flags.MqttBrokerPort, "the port of mqtt broker")
 	fs.StringVar(&f.Mqtt3Flags.MqttGroup, "mqtt3-group", f.Mqtt3Flags.MqttGroup, "the mqtt group")
 	fs.StringVar(&f.Mqtt3Flags.MqttInstance, "mqtt3-instance", f.Mqtt3Flags.MqttInstance, "mqtt instance name")
 
 	fs.StringVar(&f.Mqtt5Flags.MqttServer, "mqtt5-server", f.Mqtt5Flags.MqttServer, "mqtt5 server")
+	fs.StringVar(&f.Mqtt5Flags.ShareGroup, "mqtt5-share-group", f.Mqtt5Flags.ShareGroup, "subscribe heartbeats by $share/<group>/ to spread them across the consumers of all the controller instances, they are forwarded to the instances owning the nodes")
+	fs.IntVar(&f.Mqtt5Flags.HeartBeatConsumers, "mqtt5-heartbeat-consumers", f.Mqtt5Flags.HeartBeatConsumers, "the number of clients consuming the shared heartbeat subscription in every controller instance")
+	fs.StringVar(&f.MqttTLS.CAFile, "mqtt-ca-file", f.MqttTLS.CAFile, "the PEM CA bundle verifying the mqtt broker, the system roots are used if it is empty, it is reloaded once the file changes")
+	fs.StringVar(&f.MqttTLS.CertFile, "mqtt-cert-file", f.MqttTLS.CertFile, "the PEM client certificate presented to the mqtt broker for mTLS, it is reloaded once the file changes")
+	fs.StringVar(&f.MqttTLS.KeyFile, "mqtt-key-file", f.MqttTLS.KeyFile, "the PEM key of mqtt-cert-file")
+	fs.StringVar(&f.MqttTLS.ServerName, "mqtt-server-name", f.MqttTLS.ServerName, "the name verified in the certificate of the mqtt broker, default to the host of the broker")
+	fs.StringVar(&f.MqttTLS.MinVersion, "mqtt-tls-min-version", f.MqttTLS.MinVersion, "the minimum tls version connecting the mqtt broker, 1.0, 1.1, 1.2 or 1.3, default to 1.2 if any mqtt tls flag is set")
+	fs.StringVar(&f.MqttAuth.Provider, "mqtt-auth", f.MqttAuth.Provider, "the credentials connecting the mqtt broker, none, static, aliyun, jwt or client-certificate, default to aliyun with mqtt3 and none with mqtt5")
+	fs.StringVar(&f.MqttAuth.Username, "mqtt-username", f.MqttAuth.Username, "the mqtt username of static, jwt and client-certificate auth, default to the client id with jwt")
+	fs.StringVar(&f.MqttAuth.PasswordFile, "mqtt-password-file", f.MqttAuth.PasswordFile, "the file of the mqtt password of static auth")
+	fs.StringVar(&f.MqttAuth.JWTKeyFile, "mqtt-jwt-key-file", f.MqttAuth.JWTKeyFile, "the PEM ecdsa P-256 or rsa key signing the JWTs of jwt auth")
+	fs.StringVar(&f.MqttAuth.JWTAudience, "mqtt-jwt-audience", f.MqttAuth.JWTAudience, "the aud claim of the JWTs of jwt auth")
+	fs.IntVar(&f.MqttAuth.JWTLifetime, "mqtt-jwt-lifetime", f.MqttAuth.JWTLifetime, "the lifetime (s) of the JWTs of jwt auth, they are refreshed after 80% of the lifetime")
 
 	fs.StringVar(&f.KubeConfig, "kubeconfig", f.KubeConfig, "Path to a kubeconfig file, specifying how to connect to the API server.")
 	fs.IntVar(&f.SnapshotInterval, "snapshot-interval", f.SnapshotInterval, "snapshot interval (second)")
+	fs.IntVar(&f.SnapshotHistory, "snapshot-history", f.SnapshotHistory, "the number of snapshot generations to keep")
+	fs.StringVar(&f.SnapshotEncryptionSecret, "snapshot-encryption-secret", f.SnapshotEncryptionSecret, "the name of secret holding AES keys to encrypt snapshots, snapshots are not encrypted if it is empty")
+	fs.BoolVar(&f.AllowUnencryptedSnapshots, "allow-unencrypted-snapshots", f.AllowUnencryptedSnapshots, "restore the snapshots not encrypted with snapshot-encryption-secret, such as those saved before the encryption is enabled, they are not authenticated")
+	fs.StringVar(&f.SnapshotCodec, "snapshot-codec", f.SnapshotCodec, "the codec to compress snapshots, such as none, gzip, lzw, flate, snappy, lz4 and zstd")
+	fs.Var(util.CodecLevelValue{Level: &f.SnapshotCodecLevel}, "snapshot-codec-level", "the compression level of snapshot codec, the default level of the codec is used if it is not set")
+	fs.StringVar(&f.SnapshotCodecDictionary, "snapshot-codec-dictionary", f.SnapshotCodecDictionary, "the file of zstd dictionary trained with heartbeat json samples, it must be kept as long as the snapshots compressed with it")
+	fs.Int64Var(&f.RestoreSnapshotIndex, "restore-snapshot-index", f.RestoreSnapshotIndex, "restore from the snapshot generation with this index at startup, -1 means the latest complete generation. The index is only restored once, the latest complete generation is restored at the startups after it")
 	fs.IntVar(&f.HBTimeOut, "hb-timeout", f.HBTimeOut, "hb time out(second)")
+	fs.IntVar(&f.NodeGCPeriod, "node-gc-period", f.NodeGCPeriod, "the nodes offline longer than it (second) are removed from the caches and snapshots, 0 means the offline nodes are kept forever")
+	fs.IntVar(&f.FlapWindow, "flap-window", f.FlapWindow, "the window (second) counting the state transitions of a node to find whether it is flapping")
+	fs.IntVar(&f.FlapThreshold, "flap-threshold", f.FlapThreshold, "a node is flapping if it changes its state at least this times within flap-window")
+	fs.StringVar(&f.Identity, "identity", f.Identity, "the unique identity of the controller instance, default to env POD_NAME or the hostname")
+	fs.BoolVar(&f.EnableSharding, "enable-sharding", f.EnableSharding, "run as one of the controller shards, every shard owns a consistent hash range of node names")
+	fs.StringVar(&f.ShardName, "shard-name", f.ShardName, "the unique name of the shard, default to the identity, the leader and the standbys of a shard must have the same shard name")
+	fs.IntVar(&f.ShardLeaseDuration, "shard-lease-duration", f.ShardLeaseDuration, "shard lease duration (second), a shard is removed if its lease is not renewed in time")
+	fs.BoolVar(&f.EnableLeaderElection, "leader-elect", f.EnableLeaderElection, "elect a leader to publish acks, pods and snapshots, the standbys keep consuming heartbeats to take over in seconds")
+	fs.StringVar(&f.LeaderElectionID, "leader-election-id", f.LeaderElectionID, "the name of the leader election lease, the shard name is appended with sharding")
+	fs.IntVar(&f.LeaderElectionLeaseDuration, "leader-election-lease-duration", f.LeaderElectionLeaseDuration, "the duration (second) that standbys wait before taking over an unrenewed leadership")
+	fs.IntVar(&f.LeaderElectionRenewDeadline, "leader-election-renew-deadline", f.LeaderElectionRenewDeadline, "the duration (second) that the leader retries renewing before giving up the leadership")
+	fs.IntVar(&f.LeaderElectionRetryPeriod, "leader-election-retry-period", f.LeaderElectionRetryPeriod, "the duration (second) between tries of leader election actions")
+	fs.IntVar(&f.HeartBeatQueueSize, "heartbeat-queue-size", f.HeartBeatQueueSize, "the max number of nodes whose heartbeats are waiting to be processed, only the newest heartbeat of a node is kept")
+	fs.IntVar(&f.HeartBeatWorkers, "heartbeat-workers", f.HeartBeatWorkers, "the number of workers processing heartbeats")
+	fs.IntVar(&f.HeartBeatEnqueueTimeout, "heartbeat-enqueue-timeout", f.HeartBeatEnqueueTimeout, "the time (millisecond) a heartbeat waits for the full queue before it is dropped")
+	fs.IntVar(&f.DispatcherWorkers, "dispatcher-workers", f.DispatcherWorkers, "the number of workers delivering acks and pods to the nodes")
+	fs.IntVar(&f.DispatcherMaxRetries, "dispatcher-max-retries", f.DispatcherMaxRetries, "the max number of retries with exponential backoff before a message to a node is dropped")
+	fs.Float64Var(&f.DispatcherQPS, "dispatcher-qps", f.DispatcherQPS, "the max number of messages per second delivered to all the nodes")
+	fs.IntVar(&f.DispatcherBurst, "dispatcher-burst", f.DispatcherBurst, "the burst of messages delivered to all the nodes")
+	fs.Float64Var(&f.DispatcherNodeQPS, "dispatcher-node-qps", f.DispatcherNodeQPS, "the max number of messages per second delivered to a node")
+	fs.IntVar(&f.DispatcherNodeBurst, "dispatcher-node-burst", f.DispatcherNodeBurst, "the burst of messages delivered to a node")
+	fs.Float64Var(&f.RegistrationQPS, "registration-qps", f.RegistrationQPS, "the max number of registrations admitted per second, the rejected nodes are told when to retry, 0 means no limit")
+	fs.IntVar(&f.RegistrationBurst, "registration-burst", f.RegistrationBurst, "the burst of registrations admitted")
+	fs.BoolVar(&f.EnableRegistrationApproval, "enable-registration-approval", f.EnableRegistrationApproval, "keep the unknown nodes pending until they are approved by an Approve KoleQuery or by the auto-approval policies")
+	fs.BoolVar(&f.ApproveBootstrapTokens, "approve-bootstrap-tokens", f.ApproveBootstrapTokens, "approve the nodes registering with a valid bootstrap token, which is a secret of type lite.openyurt.io/bootstrap-token")
+	fs.StringVar(&f.ApproveLabelSelector, "approve-label-selector", f.ApproveLabelSelector, "approve the nodes whose labels match the selector, such as region=hangzhou,tier!=test")
+	fs.StringSliceVar(&f.ApproveCIDRs, "approve-cidrs", f.ApproveCIDRs, "approve the nodes reporting an internal address in any of the comma separated CIDRs")
+	fs.StringVar(&f.MessageSignature, "message-signature", f.MessageSignature, "the algorithm signing the messages between the controllers and the nodes, hmac-sha256 or ed25519, messages are not signed if it is empty")
+	fs.StringVar(&f.MessageSigningSecret, "message-signing-secret", f.MessageSigningSecret, "the name of secret holding the hmac-sha256 master keys deriving the node keys, or the ed25519 seeds of the controllers, the active key signs")
+	fs.BoolVar(&f.AllowUnsignedMessages, "allow-unsigned-messages", f.AllowUnsignedMessages, "accept the unsigned heartbeats as well, such as while the signatures are rolled out to the nodes")
+	fs.StringVar(&f.ClientCASecret, "client-ca-secret", f.ClientCASecret, "the name of kubernetes.io/tls secret holding the CA which issues the client certificates requested by the nodes for MQTT mTLS, no certificates are issued if it is empty, it requires message-signature")
+	fs.IntVar(&f.ClientCertificateDuration, "client-certificate-duration", f.ClientCertificateDuration, "the lifetime (s) of the client certificates issued to the nodes, which renew them before they expire")
+	fs.BoolVar(&f.EnablePodSecrets, "enable-pod-secrets", f.EnablePodSecrets, "let the env of KoleDaemonSets refer to the secrets labeled with lite.openyurt.io/pod-secret=true, whose values are encrypted to the nodes, it requires message-signature")
+	fs.IntVar(&f.NodeReconcileWorkers, "node-reconcile-workers", f.NodeReconcileWorkers, "the number of workers diffing the desired pods against the pods reported by the nodes")
+	fs.StringVar(&f.MetricsBindAddress, "metrics-bind-address", f.MetricsBindAddress, "the address serving prometheus metrics at /metrics, metrics are not served if it is empty")
 }
 
 // ValidateKoleControllerFlags validates litekubelet's configuration flags and returns an error if they are invalid.
@@ -104,8 +311,6 @@ func ValidateKoleControllerFlags(f *KoleControllerFlags) error {
 	// mqtt3
 	if len(f.Mqtt5Flags.MqttServer) == 0 {
 		switch {
-		case len(f.Mqtt3Flags.MqttInstance) == 0:
-			return fmt.Errorf("need set mqtt3-instance")
 		case len(f.Mqtt3Flags.MqttBroker) == 0:
 			return fmt.Errorf("need set mqtt3-broker")
 		case f.Mqtt3Flags.MqttBrokerPort == 0:
@@ -116,6 +321,124 @@ func ValidateKoleControllerFlags(f *KoleControllerFlags) error {
 
 	} else {
 		f.IsMqtt5 = true
+		if len(f.Mqtt5Flags.ShareGroup) != 0 && f.Mqtt5Flags.HeartBeatConsumers < 1 {
+			return fmt.Errorf("mqtt5-heartbeat-consumers must be at least 1")
+		}
+		if err := f.MqttTLS.ValidateServerURL(f.Mqtt5Flags.MqttServer); err != nil {
+			return err
+		}
+	}
+	if err := f.MqttTLS.Validate(); err != nil {
+		return fmt.Errorf("invalid mqtt tls: %v", err)
+	}
+	if len(f.MqttAuth.Provider) == 0 {
+		// the Aliyun signatures were the only credentials of mqtt3
+		f.MqttAuth.Provider = message.AuthNone
+		if !f.IsMqtt5 {
+			f.MqttAuth.Provider = message.AuthAliyun
+		}
+	}
+	if f.MqttAuth.Provider == message.AuthAliyun && len(f.Mqtt3Flags.MqttInstance) == 0 {
+		return fmt.Errorf("need set mqtt3-instance")
+	}
+	if err := f.MqttAuth.Validate(f.MqttTLS, false); err != nil {
+		return fmt.Errorf("invalid mqtt auth: %v", err)
+	}
+
+	if f.SnapshotHistory < 1 {
+		return fmt.Errorf("snapshot-history must be at least 1")
+	}
+
+	if len(f.SnapshotCodec) == 0 {
+		return fmt.Errorf("need set snapshot-codec")
+	}
+
+	if (f.EnableSharding || f.EnableLeaderElection) && len(f.Identity) == 0 {
+		return fmt.Errorf("need set identity")
+	}
+
+	if f.EnableSharding {
+		if len(f.ShardName) == 0 {
+			f.ShardName = f.Identity
+		}
+		if f.ShardLeaseDuration < 3 {
+			return fmt.Errorf("shard-lease-duration must be at least 3")
+		}
+	}
+
+	if f.EnableLeaderElection {
+		if len(f.LeaderElectionID) == 0 {
+			return fmt.Errorf("need set leader-election-id")
+		}
+		if f.LeaderElectionRetryPeriod <= 0 || f.LeaderElectionRenewDeadline <= f.LeaderElectionRetryPeriod ||
+			f.LeaderElectionLeaseDuration <= f.LeaderElectionRenewDeadline {
+			return fmt.Errorf("leader election durations must be leader-election-lease-duration > leader-election-renew-deadline > leader-election-retry-period > 0")
+		}
+	}
+
+	if f.NodeGCPeriod < 0 {
+		return fmt.Errorf("node-gc-period must not be negative")
+	}
+	if f.FlapWindow < 1 || f.FlapThreshold < 2 {
+		return fmt.Errorf("flap-window must be at least 1 and flap-threshold must be at least 2")
+	}
+
+	if f.HeartBeatQueueSize < 1 {
+		return fmt.Errorf("heartbeat-queue-size must be at least 1")
+	}
+	if f.HeartBeatWorkers < 1 {
+		return fmt.Errorf("heartbeat-workers must be at least 1")
+	}
+	if f.HeartBeatEnqueueTimeout < 0 {
+		return fmt.Errorf("heartbeat-enqueue-timeout must not be negative")
+	}
+
+	if f.DispatcherWorkers < 1 {
+		return fmt.Errorf("dispatcher-workers must be at least 1")
+	}
+	if f.DispatcherMaxRetries < 0 {
+		return fmt.Errorf("dispatcher-max-retries must not be negative")
+	}
+	if f.DispatcherQPS <= 0 || f.DispatcherBurst < 1 || f.DispatcherNodeQPS <= 0 || f.DispatcherNodeBurst < 1 {
+		return fmt.Errorf("dispatcher qps must be positive and burst must be at least 1")
+	}
+	if f.RegistrationQPS < 0 || (f.RegistrationQPS > 0 && f.RegistrationBurst < 1) {
+		return fmt.Errorf("registration-qps must not be negative and registration-burst must be at least 1")
+	}
+	if f.EnableRegistrationApproval {
+		if _, err := labels.Parse(f.ApproveLabelSelector); err != nil {
+			return fmt.Errorf("invalid approve-label-selector: %v", err)
+		}
+		for _, cidr := range f.ApproveCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid approve-cidrs: %v", err)
+			}
+		}
+	}
+	switch f.MessageSignature {
+	case "":
+	case data.SignatureHMAC, data.SignatureEd25519:
+		if len(f.MessageSigningSecret) == 0 {
+			return fmt.Errorf("message-signing-secret must be set with message-signature %s", f.MessageSignature)
+		}
+	default:
+		return fmt.Errorf("message-signature must be %s or %s", data.SignatureHMAC, data.SignatureEd25519)
+	}
+	if f.EnablePodSecrets && len(f.MessageSignature) == 0 {
+		// the secrets are only sealed to the keys reported by the signed heartbeats
+		return fmt.Errorf("message-signature must be set with enable-pod-secrets")
+	}
+	if len(f.ClientCASecret) != 0 {
+		// the requests of the certificates are authenticated by their signatures
+		if len(f.MessageSignature) == 0 {
+			return fmt.Errorf("message-signature must be set with client-ca-secret")
+		}
+		if f.ClientCertificateDuration < 600 {
+			return fmt.Errorf("client-certificate-duration must be at least 600")
+		}
+	}
+	if f.NodeReconcileWorkers < 1 {
+		return fmt.Errorf("node-reconcile-workers must be at least 1")
 	}
 
 	return nil
@@ -132,10 +455,140 @@ func SetKoleControllerFlagsByEnv(f *KoleControllerFlags) error {
 		}
 	}
 
+	if numStr := os.Getenv("NODE_GC_PERIOD"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.NodeGCPeriod = num
+			klog.Infof("Set --node-gc-period value to %d by env", f.NodeGCPeriod)
+		}
+	}
+
 	if numStr := os.Getenv("MQTT5_SERVER"); len(numStr) != 0 {
 		f.Mqtt5Flags.MqttServer = numStr
 		klog.Infof("Set --mqtt5-server value to %s by env", f.Mqtt5Flags.MqttServer)
 	}
 
+	if group := os.Getenv("MQTT5_SHARE_GROUP"); len(group) != 0 {
+		f.Mqtt5Flags.ShareGroup = group
+		klog.Infof("Set --mqtt5-share-group value to %s by env", f.Mqtt5Flags.ShareGroup)
+	}
+
+	if numStr := os.Getenv("MQTT5_HEARTBEAT_CONSUMERS"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.Mqtt5Flags.HeartBeatConsumers = num
+			klog.Infof("Set --mqtt5-heartbeat-consumers value to %d by env", f.Mqtt5Flags.HeartBeatConsumers)
+		}
+	}
+
+	if numStr := os.Getenv("HEARTBEAT_QUEUE_SIZE"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.HeartBeatQueueSize = num
+			klog.Infof("Set --heartbeat-queue-size value to %d by env", f.HeartBeatQueueSize)
+		}
+	}
+
+	if numStr := os.Getenv("HEARTBEAT_WORKERS"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.HeartBeatWorkers = num
+			klog.Infof("Set --heartbeat-workers value to %d by env", f.HeartBeatWorkers)
+		}
+	}
+
+	if addr, ok := os.LookupEnv("METRICS_BIND_ADDRESS"); ok {
+		f.MetricsBindAddress = addr
+		klog.Infof("Set --metrics-bind-address value to %s by env", f.MetricsBindAddress)
+	}
+
+	if secret := os.Getenv("SNAPSHOT_ENCRYPTION_SECRET"); len(secret) != 0 {
+		f.SnapshotEncryptionSecret = secret
+		klog.Infof("Set --snapshot-encryption-secret value to %s by env", f.SnapshotEncryptionSecret)
+	}
+
+	if codec := os.Getenv("SNAPSHOT_CODEC"); len(codec) != 0 {
+		f.SnapshotCodec = codec
+		klog.Infof("Set --snapshot-codec value to %s by env", f.SnapshotCodec)
+	}
+
+	if numStr := os.Getenv("SNAPSHOT_CODEC_LEVEL"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.SnapshotCodecLevel = &num
+			klog.Infof("Set --snapshot-codec-level value to %d by env", num)
+		}
+	}
+
+	if file := os.Getenv("SNAPSHOT_CODEC_DICTIONARY"); len(file) != 0 {
+		f.SnapshotCodecDictionary = file
+		klog.Infof("Set --snapshot-codec-dictionary value to %s by env", f.SnapshotCodecDictionary)
+	}
+
+	if numStr := os.Getenv("SNAPSHOT_HISTORY"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.SnapshotHistory = num
+			klog.Infof("Set --snapshot-history value to %d by env", f.SnapshotHistory)
+		}
+	}
+
+	if numStr := os.Getenv("RESTORE_SNAPSHOT_INDEX"); len(numStr) != 0 {
+		if num, err := strconv.ParseInt(numStr, 10, 64); err != nil {
+			klog.Errorf("Can not parse %s, error %v", numStr, err)
+			return err
+		} else {
+			f.RestoreSnapshotIndex = num
+			klog.Infof("Set --restore-snapshot-index value to %d by env", f.RestoreSnapshotIndex)
+		}
+	}
+
+	if boolStr := os.Getenv("ENABLE_SHARDING"); len(boolStr) != 0 {
+		if b, err := strconv.ParseBool(boolStr); err != nil {
+			klog.Errorf("Can not parse %s, error %v", boolStr, err)
+			return err
+		} else {
+			f.EnableSharding = b
+			klog.Infof("Set --enable-sharding value to %v by env", f.EnableSharding)
+		}
+	}
+
+	if name := os.Getenv("SHARD_NAME"); len(name) != 0 {
+		f.ShardName = name
+		klog.Infof("Set --shard-name value to %s by env", f.ShardName)
+	}
+
+	if numStr := os.Getenv("SHARD_LEASE_DURATION"); len(numStr) != 0 {
+		if num, err := strconv.Atoi(numStr); err != nil {
+			klog.Errorf("Can not atoi %s, error %v", numStr, err)
+			return err
+		} else {
+			f.ShardLeaseDuration = num
+			klog.Infof("Set --shard-lease-duration value to %d by env", f.ShardLeaseDuration)
+		}
+	}
+
+	if boolStr := os.Getenv("LEADER_ELECT"); len(boolStr) != 0 {
+		if b, err := strconv.ParseBool(boolStr); err != nil {
+			klog.Errorf("Can not parse %s, error %v", boolStr, err)
+			return err
+		} else {
+			f.EnableLeaderElection = b
+			klog.Infof("Set --leader-elect value to %v by env", f.EnableLeaderElection)
+		}
+	}
+
 	return nil
 }
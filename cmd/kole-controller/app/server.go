@@ -160,9 +160,11 @@ func Run(ctx context.Context, config *options.KoleControllerFlags) error {
 
 func RunKoleController(stop chan struct{}, config *options.KoleControllerFlags) error {
 
-	lite, err := controller.NewMainKoleController(stop,
-		config,
-		&controller.Gzip{})
+	if len(config.MetricsBindAddress) != 0 {
+		go controller.ServeMetrics(config.MetricsBindAddress)
+	}
+
+	lite, err := controller.NewMainKoleController(stop, config)
 	if err != nil {
 		klog.Errorf("NewMainKoleController error %v", err)
 		return err
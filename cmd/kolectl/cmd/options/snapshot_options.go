@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openyurtio/kole/pkg/controller"
+)
+
+const (
+	OutputTable = "table"
+	OutputJson  = "json"
+)
+
+type SnapshotFlags struct {
+	*GlobalFlags
+	// the snapshot generation, -1 means the latest complete one when reading, and the next one when importing
+	Index int64
+	// the secret in NameSpace holding the snapshot encryption keys
+	EncryptionSecret string
+	// read the snapshots not encrypted as well with EncryptionSecret
+	AllowUnencrypted bool
+	// the file of zstd dictionary used by the controller
+	CodecDictionary string
+	// the controller shard saving the snapshots, empty means the controller is not sharded
+	Shard string
+
+	// filters of inspect and export
+	Nodes []string
+	State string
+
+	Output string
+	File   string
+
+	// the codec used by import
+	Codec string
+	// the compression level of the codec, nil means the default level of the codec
+	CodecLevel *int
+}
+
+func NewSnapshotFlags(g *GlobalFlags) *SnapshotFlags {
+	return &SnapshotFlags{
+		GlobalFlags: g,
+		Index:       -1,
+		Output:      OutputTable,
+		Codec:       controller.CodecGzip,
+	}
+}
+
+// ValidateSnapshotFlags validates the snapshot flags and returns an error if they are invalid.
+func ValidateSnapshotFlags(f *SnapshotFlags) error {
+	switch f.Output {
+	case OutputTable, OutputJson:
+	default:
+		return fmt.Errorf("output must be %s or %s", OutputTable, OutputJson)
+	}
+	return nil
+}
+
+// AddReadFlags adds the flags to read a snapshot generation
+func (f *SnapshotFlags) AddReadFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&f.Index, "index", f.Index, "the snapshot generation to read, -1 means the latest complete generation")
+	cmd.Flags().StringVar(&f.EncryptionSecret, "encryption-secret", f.EncryptionSecret, "the name of secret holding AES keys of encrypted snapshots")
+	cmd.Flags().BoolVar(&f.AllowUnencrypted, "allow-unencrypted", f.AllowUnencrypted, "read the snapshots not encrypted as well with encryption-secret, such as those saved before the encryption is enabled")
+	cmd.Flags().StringVar(&f.CodecDictionary, "codec-dictionary", f.CodecDictionary, "the file of zstd dictionary used to compress snapshots")
+}
+
+// AddShardFlag adds the flag to select the snapshots of a controller shard
+func (f *SnapshotFlags) AddShardFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.Shard, "shard", f.Shard, "the controller shard saving the snapshots, empty means the controller is not sharded")
+}
+
+// AddFilterFlags adds the flags to filter nodes
+func (f *SnapshotFlags) AddFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.Nodes, "node", f.Nodes, "only the nodes with these names")
+	cmd.Flags().StringVar(&f.State, "state", f.State, "only the nodes in this state, such as Registering, Registerd and Offline")
+}
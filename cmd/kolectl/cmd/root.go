@@ -0,0 +1,51 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openyurtio/kole/cmd/kolectl/cmd/options"
+	"github.com/openyurtio/kole/cmd/kolectl/cmd/snapshot"
+)
+
+var globalOptions options.GlobalFlags
+
+// rootCmd represents the base command when called without any subcommands
+var rootCmd = &cobra.Command{
+	Use:   "kolectl",
+	Short: "kolectl is a tool to inspect and operate the resources of kole.",
+	Long:  `kolectl is a tool to inspect and operate the resources of kole.`,
+}
+
+func init() {
+	globalOptions = *options.NewGlobalFlags()
+	globalOptions.AddFlags(rootCmd)
+
+	snapshot.RegisterCommand(rootCmd, &globalOptions)
+}
+
+// Execute adds all child commands to the root command and sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
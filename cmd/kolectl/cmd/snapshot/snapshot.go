@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package snapshot
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/cmd/kolectl/cmd/options"
+	"github.com/openyurtio/kole/pkg/kolectl"
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+func RegisterCommand(root *cobra.Command, gops *options.GlobalFlags) {
+	// subrootCmd represents the base command of snapshot operations
+	subrootCmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Inspect, export and import the snapshots saved in summaries.",
+		Long:  `Inspect, export and import the snapshots saved in summaries.`,
+	}
+	subrootCmd.AddCommand(NewListCommand(gops))
+	subrootCmd.AddCommand(NewInspectCommand(gops))
+	subrootCmd.AddCommand(NewExportCommand(gops))
+	subrootCmd.AddCommand(NewImportCommand(gops))
+	root.AddCommand(subrootCmd)
+}
+
+func NewListCommand(gops *options.GlobalFlags) *cobra.Command {
+	ops := options.NewSnapshotFlags(gops)
+	c := &cobra.Command{
+		Use:   "list",
+		Short: "List the snapshot generations",
+		Long:  "List the snapshot generations",
+		Run: func(cmd *cobra.Command, args []string) {
+			run(ops, func(s *kolectl.Snapshot) error {
+				return s.List(os.Stdout)
+			})
+		},
+	}
+	ops.AddShardFlag(c)
+	return c
+}
+
+func NewInspectCommand(gops *options.GlobalFlags) *cobra.Command {
+	ops := options.NewSnapshotFlags(gops)
+	c := &cobra.Command{
+		Use:   "inspect",
+		Short: "Print the nodes in a snapshot generation",
+		Long:  "Print the nodes in a snapshot generation as json or table",
+		Run: func(cmd *cobra.Command, args []string) {
+			run(ops, func(s *kolectl.Snapshot) error {
+				return s.Inspect(os.Stdout)
+			})
+		},
+	}
+	ops.AddReadFlags(c)
+	ops.AddShardFlag(c)
+	ops.AddFilterFlags(c)
+	c.Flags().StringVarP(&ops.Output, "output", "o", ops.Output, "output format, table or json")
+	return c
+}
+
+func NewExportCommand(gops *options.GlobalFlags) *cobra.Command {
+	ops := options.NewSnapshotFlags(gops)
+	c := &cobra.Command{
+		Use:   "export",
+		Short: "Export the nodes in a snapshot generation to a local file",
+		Long:  "Export the nodes in a snapshot generation to a local file as uncompressed and unencrypted json",
+		Run: func(cmd *cobra.Command, args []string) {
+			run(ops, func(s *kolectl.Snapshot) error {
+				return s.Export(ops.File)
+			})
+		},
+	}
+	ops.AddReadFlags(c)
+	ops.AddShardFlag(c)
+	ops.AddFilterFlags(c)
+	c.Flags().StringVarP(&ops.File, "file", "f", ops.File, "the file to export to")
+	c.MarkFlagRequired("file")
+	return c
+}
+
+func NewImportCommand(gops *options.GlobalFlags) *cobra.Command {
+	ops := options.NewSnapshotFlags(gops)
+	c := &cobra.Command{
+		Use:   "import",
+		Short: "Import the nodes in a local file as a new snapshot generation",
+		Long: "Import the nodes in a local file written by export as a new snapshot generation, " +
+			"kole-controller restores from it at the next start if it is the latest generation or selected by --restore-snapshot-index",
+		Run: func(cmd *cobra.Command, args []string) {
+			run(ops, func(s *kolectl.Snapshot) error {
+				return s.Import(ops.File)
+			})
+		},
+	}
+	c.Flags().Int64Var(&ops.Index, "index", ops.Index, "the index of the new snapshot generation, -1 means the next index")
+	ops.AddShardFlag(c)
+	c.Flags().StringVar(&ops.EncryptionSecret, "encryption-secret", ops.EncryptionSecret, "the name of secret holding AES keys to encrypt the snapshot")
+	c.Flags().StringVar(&ops.CodecDictionary, "codec-dictionary", ops.CodecDictionary, "the file of zstd dictionary to compress the snapshot")
+	c.Flags().StringVar(&ops.Codec, "codec", ops.Codec, "the codec to compress the snapshot, such as none, gzip, lzw, flate, snappy, lz4 and zstd")
+	c.Flags().Var(util.CodecLevelValue{Level: &ops.CodecLevel}, "codec-level", "the compression level of codec, the default level of the codec is used if it is not set")
+	c.Flags().StringVarP(&ops.File, "file", "f", ops.File, "the file to import from")
+	c.MarkFlagRequired("file")
+	return c
+}
+
+func run(ops *options.SnapshotFlags, f func(s *kolectl.Snapshot) error) {
+	if err := options.ValidateSnapshotFlags(ops); err != nil {
+		klog.Fatal(err)
+	}
+	s, err := kolectl.NewSnapshot(ops)
+	if err != nil {
+		klog.Fatalf("NewSnapshot error %v", err)
+	}
+	if err := f(s); err != nil {
+		klog.Fatal(err)
+	}
+}
@@ -22,12 +22,19 @@ import (
 
 	"github.com/spf13/pflag"
 	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/message"
 )
 
 type LiteKubeletFlags struct {
 	Mqtt5Flags *Mqtt5Flags
 	Mqtt3Flags *Mqtt3Flags
 	IsMqtt5    bool
+	// the tls of the connections to the mqtt broker, shared by mqtt3 and mqtt5
+	MqttTLS *message.TLSOptions
+	// the credentials connecting the mqtt broker, shared by mqtt3 and mqtt5
+	MqttAuth *message.AuthOptions
 
 	NameSpace           string
 	SignalConfigMapName string
@@ -39,6 +46,19 @@ type LiteKubeletFlags struct {
 	CreateClientInterval int
 	PersistentDir        string
 	KubeConfig           string
+	// the bootstrap token <token id>.<token secret> presented to be approved when registering
+	BootstrapToken string
+
+	// the algorithm signing the heartbeats and verifying the acks and pods, empty means messages are not signed
+	MessageSignature string
+	// the file of the hmac-sha256 keys of the node, or the ed25519 seed of the node
+	SigningKeyFile string
+	// the file of the ed25519 public keys of the controllers
+	ControllerPublicKeyFile string
+	// accept the unsigned acks and pods as well while the signatures are rolled out
+	AllowUnsignedMessages bool
+	// request the client certificate for MQTT mTLS from the controllers and renew it before it expires
+	EnableClientCertificate bool
 }
 
 type Mqtt3Flags struct {
@@ -50,6 +70,8 @@ type Mqtt3Flags struct {
 
 type Mqtt5Flags struct {
 	MqttServer string
+	// s
+	WillDelay int
 }
 
 func NewLiteKubeletFlags() *LiteKubeletFlags {
@@ -65,7 +87,9 @@ func NewLiteKubeletFlags() *LiteKubeletFlags {
 		PersistentDir:        "/etc/lite-kubelet/",
 		CreateClientInterval: 500,
 		Mqtt3Flags:           &Mqtt3Flags{},
-		Mqtt5Flags:           &Mqtt5Flags{},
+		Mqtt5Flags:           &Mqtt5Flags{WillDelay: 5},
+		MqttTLS:              &message.TLSOptions{},
+		MqttAuth:             &message.AuthOptions{JWTLifetime: 3600},
 		NameSpace:            ns,
 		SignalConfigMapName:  "lite-kubelet-start-signal",
 	}
@@ -92,6 +116,12 @@ func (f *LiteKubeletFlags) AddFlags(mainfs *pflag.FlagSet) {
 	if f.Mqtt3Flags == nil {
 		f.Mqtt3Flags = &Mqtt3Flags{}
 	}
+	if f.MqttTLS == nil {
+		f.MqttTLS = &message.TLSOptions{}
+	}
+	if f.MqttAuth == nil {
+		f.MqttAuth = &message.AuthOptions{}
+	}
 
 	fs.StringVar(&f.Mqtt3Flags.MqttBroker, "mqtt3-broker", f.Mqtt3Flags.MqttBroker, "the address of mqtt broker")
 	fs.IntVar(&f.Mqtt3Flags.MqttBrokerPort, "mqtt3-broker-port", f.Mqtt3Flags.MqttBrokerPort, "the port of mqtt broker")
@@ -99,12 +129,30 @@ func (f *LiteKubeletFlags) AddFlags(mainfs *pflag.FlagSet) {
 	fs.StringVar(&f.Mqtt3Flags.MqttInstance, "mqtt3-instance", f.Mqtt3Flags.MqttInstance, "mqtt instance name")
 
 	fs.StringVar(&f.Mqtt5Flags.MqttServer, "mqtt5-server", f.Mqtt5Flags.MqttServer, "mqtt5 server name")
+	fs.IntVar(&f.Mqtt5Flags.WillDelay, "mqtt5-will-delay", f.Mqtt5Flags.WillDelay, "the time (s) the broker waits for the node to reconnect before publishing its will")
+	fs.StringVar(&f.MqttTLS.CAFile, "mqtt-ca-file", f.MqttTLS.CAFile, "the PEM CA bundle verifying the mqtt broker, the system roots are used if it is empty, it is reloaded once the file changes")
+	fs.StringVar(&f.MqttTLS.CertFile, "mqtt-cert-file", f.MqttTLS.CertFile, "the PEM client certificate presented to the mqtt broker for mTLS, it is reloaded once the file changes")
+	fs.StringVar(&f.MqttTLS.KeyFile, "mqtt-key-file", f.MqttTLS.KeyFile, "the PEM key of mqtt-cert-file")
+	fs.StringVar(&f.MqttTLS.ServerName, "mqtt-server-name", f.MqttTLS.ServerName, "the name verified in the certificate of the mqtt broker, default to the host of the broker")
+	fs.StringVar(&f.MqttTLS.MinVersion, "mqtt-tls-min-version", f.MqttTLS.MinVersion, "the minimum tls version connecting the mqtt broker, 1.0, 1.1, 1.2 or 1.3, default to 1.2 if any mqtt tls flag is set")
+	fs.StringVar(&f.MqttAuth.Provider, "mqtt-auth", f.MqttAuth.Provider, "the credentials connecting the mqtt broker, none, static, aliyun, jwt or client-certificate, default to aliyun with mqtt3 and none with mqtt5")
+	fs.StringVar(&f.MqttAuth.Username, "mqtt-username", f.MqttAuth.Username, "the mqtt username of static, jwt and client-certificate auth, default to the client id with jwt")
+	fs.StringVar(&f.MqttAuth.PasswordFile, "mqtt-password-file", f.MqttAuth.PasswordFile, "the file of the mqtt password of static auth")
+	fs.StringVar(&f.MqttAuth.JWTKeyFile, "mqtt-jwt-key-file", f.MqttAuth.JWTKeyFile, "the PEM ecdsa P-256 or rsa key signing the JWTs of jwt auth")
+	fs.StringVar(&f.MqttAuth.JWTAudience, "mqtt-jwt-audience", f.MqttAuth.JWTAudience, "the aud claim of the JWTs of jwt auth")
+	fs.IntVar(&f.MqttAuth.JWTLifetime, "mqtt-jwt-lifetime", f.MqttAuth.JWTLifetime, "the lifetime (s) of the JWTs of jwt auth, they are refreshed after 80% of the lifetime")
 
 	fs.IntVar(&f.HeartBeatInterval, "heartbeat-interval", f.HeartBeatInterval, "heartbeat-interval (s)")
 	fs.IntVar(&f.CreateClientInterval, "create-client-interval", f.CreateClientInterval, "create mqtt client interval (ms)")
 	fs.IntVar(&f.SimulationsNums, "simulations-nums", f.SimulationsNums, "the number of simulations")
 	fs.StringVar(&f.PersistentDir, "persistent-dir", f.PersistentDir, "persistent dir")
 	fs.StringVar(&f.KubeConfig, "kubeconfig", f.KubeConfig, "Path to a kubeconfig file, specifying how to connect to the API server.")
+	fs.StringVar(&f.BootstrapToken, "bootstrap-token", f.BootstrapToken, "the bootstrap token presented when registering, the node is approved at once if the token is valid")
+	fs.StringVar(&f.MessageSignature, "message-signature", f.MessageSignature, "the algorithm signing the messages between the node and the controllers, hmac-sha256 or ed25519, messages are not signed if it is empty")
+	fs.StringVar(&f.SigningKeyFile, "signing-key-file", f.SigningKeyFile, "the file of the keys signing the heartbeats, every line is <key id> <base64 key>, the first key signs. The key id of an ed25519 seed is ignored, the key is identified by its public key")
+	fs.StringVar(&f.ControllerPublicKeyFile, "controller-public-key-file", f.ControllerPublicKeyFile, "the file of the ed25519 public keys of the controllers, every line is <key id> <base64 public key>")
+	fs.BoolVar(&f.AllowUnsignedMessages, "allow-unsigned-messages", f.AllowUnsignedMessages, "accept the unsigned acks and pods as well, such as while the signatures are rolled out to the controllers")
+	fs.BoolVar(&f.EnableClientCertificate, "enable-client-certificate", f.EnableClientCertificate, "request the client certificate of the node for MQTT mTLS from the controllers once registered, and renew it before it expires")
 	fs.StringVar(&f.SignalConfigMapName, "signal-cm-name", f.SignalConfigMapName, "the name of configmap name which trigger lite-kubelet start.")
 }
 
@@ -152,6 +200,11 @@ func SetLiteKubeletFlagsByEnv(f *LiteKubeletFlags) error {
 		klog.Infof("Set --signal-cm-name value to %s by env", f.SignalConfigMapName)
 	}
 
+	if token := os.Getenv("BOOTSTRAP_TOKEN"); len(token) != 0 {
+		f.BootstrapToken = token
+		klog.Infof("Set --bootstrap-token value by env")
+	}
+
 	return nil
 }
 
@@ -161,8 +214,6 @@ func ValidateLiteKubeletFlags(f *LiteKubeletFlags) error {
 	// mqtt3
 	if len(f.Mqtt5Flags.MqttServer) == 0 {
 		switch {
-		case len(f.Mqtt3Flags.MqttInstance) == 0:
-			return fmt.Errorf("need set mqtt3-instance")
 		case len(f.Mqtt3Flags.MqttBroker) == 0:
 			return fmt.Errorf("need set mqtt3-broker")
 		case f.Mqtt3Flags.MqttBrokerPort == 0:
@@ -173,6 +224,47 @@ func ValidateLiteKubeletFlags(f *LiteKubeletFlags) error {
 
 	} else {
 		f.IsMqtt5 = true
+		if err := f.MqttTLS.ValidateServerURL(f.Mqtt5Flags.MqttServer); err != nil {
+			return err
+		}
+	}
+	if err := f.MqttTLS.Validate(); err != nil {
+		return fmt.Errorf("invalid mqtt tls: %v", err)
+	}
+	if len(f.MqttAuth.Provider) == 0 {
+		// the Aliyun signatures were the only credentials of mqtt3
+		f.MqttAuth.Provider = message.AuthNone
+		if !f.IsMqtt5 {
+			f.MqttAuth.Provider = message.AuthAliyun
+		}
+	}
+	if f.MqttAuth.Provider == message.AuthAliyun && len(f.Mqtt3Flags.MqttInstance) == 0 {
+		return fmt.Errorf("need set mqtt3-instance")
+	}
+	// the certificate issued to the node is presented by the tls connections
+	hasClientCertificate := f.EnableClientCertificate && (f.MqttTLS.Configured() || !f.IsMqtt5)
+	if err := f.MqttAuth.Validate(f.MqttTLS, hasClientCertificate); err != nil {
+		return fmt.Errorf("invalid mqtt auth: %v", err)
+	}
+	if f.Mqtt5Flags.WillDelay < 0 {
+		return fmt.Errorf("mqtt5-will-delay must not be negative")
+	}
+	switch f.MessageSignature {
+	case "":
+	case data.SignatureHMAC:
+		if len(f.SigningKeyFile) == 0 {
+			return fmt.Errorf("signing-key-file must be set with message-signature %s", f.MessageSignature)
+		}
+		if f.SimulationsNums > 1 {
+			// the hmac keys are derived from the name of every node
+			return fmt.Errorf("message-signature %s can not sign the messages of %d simulations with one key file", f.MessageSignature, f.SimulationsNums)
+		}
+	case data.SignatureEd25519:
+		if len(f.SigningKeyFile) == 0 || len(f.ControllerPublicKeyFile) == 0 {
+			return fmt.Errorf("signing-key-file and controller-public-key-file must be set with message-signature %s", f.MessageSignature)
+		}
+	default:
+		return fmt.Errorf("message-signature must be %s or %s", data.SignatureHMAC, data.SignatureEd25519)
 	}
 	return nil
 }
@@ -280,6 +280,12 @@ func RunLiteKubelet(ctx context.Context, deps *options.LiteKubeletFlags) error {
 	for {
 		select {
 		case <-ctx.Done():
+			// the nodes shutting down cleanly are removed by the controllers at once
+			for _, l := range allLites {
+				if err := l.Unregister(); err != nil {
+					klog.Errorf("Unregister node %s error %v", l.HostnameOverride, err)
+				}
+			}
 			return nil
 		}
 	}
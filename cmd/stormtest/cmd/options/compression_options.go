@@ -31,32 +31,12 @@ type CompressionFlags struct {
 	OnlySaveCrs bool
 }
 
-var supportAlgthms map[string]controller.DataProcesser
-
-func init() {
-	supportAlgthms = make(map[string]controller.DataProcesser)
-	supportAlgthms["gzip"] = &controller.Gzip{}
-	supportAlgthms["lzw"] = &controller.Lzw{}
-	supportAlgthms["flate"] = &controller.Flate{}
-	supportAlgthms["lz4"] = &controller.Lz4{}
-	supportAlgthms["snappy"] = &controller.Snappy{}
-}
-
 func SupprtAlgthms() []string {
-	alg := make([]string, 0, 10)
-	for k, _ := range supportAlgthms {
-		alg = append(alg, k)
-	}
-	return alg
+	return controller.CodecNames()
 }
 
 func AlgthmFactory(algName string) (controller.DataProcesser, error) {
-
-	p, ok := supportAlgthms[algName]
-	if ok {
-		return p, nil
-	}
-	return nil, fmt.Errorf("Do not supprt %s algthm", algName)
+	return controller.NewCodec(algName, controller.CodecOptions{})
 }
 
 func NewCompressionFlags(g *GlobalFlags) *CompressionFlags {
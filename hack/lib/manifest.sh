@@ -71,6 +71,17 @@ spec:
           value: "${MQTT5_SERVER}"
         - name: HB_TIMEOUT
           value: "300"
+        - name: NODE_GC_PERIOD
+          value: "1200"
+        - name: ENABLE_SHARDING
+          value: "false"
+        - name: LEADER_ELECT
+          value: "false"
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              apiVersion: v1
+              fieldPath: metadata.name
         - name: NAME_SPACE
           valueFrom:
             fieldRef:
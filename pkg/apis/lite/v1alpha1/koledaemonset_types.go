@@ -35,15 +35,49 @@ type KoleDaemonSet struct {
 }
 
 type KoleDaemonSetStatus struct {
+	// CurrentNumberScheduled is the number of nodes running the pod of any version.
 	CurrentNumberScheduled int `json:"currentNumberScheduled"`
 	DesiredNumberScheduled int `json:"desiredNumberScheduled"`
-	NumberReady            int `json:"numberReady"`
+	// NumberReady is the number of nodes running the updated pod, and the pod is running.
+	NumberReady int `json:"numberReady"`
+	// UpdatedNumberScheduled is the number of nodes running the pod of the current spec.
+	UpdatedNumberScheduled int `json:"updatedNumberScheduled"`
+	// Shards are the numbers counted by every controller shard, the numbers above are their sums.
+	// It is empty if the controller is not sharded.
+	Shards []KoleDaemonSetShardStatus `json:"shards,omitempty"`
+}
+
+// KoleDaemonSetShardStatus is the status counted by a controller shard with the nodes it owns.
+type KoleDaemonSetShardStatus struct {
+	Name                   string `json:"name"`
+	CurrentNumberScheduled int    `json:"currentNumberScheduled"`
+	DesiredNumberScheduled int    `json:"desiredNumberScheduled"`
+	NumberReady            int    `json:"numberReady"`
+	UpdatedNumberScheduled int    `json:"updatedNumberScheduled"`
 }
 
 type PodSpec struct {
 	Image        string            `json:"image,omitempty"`
 	Command      []string          `json:"command,omitempty"`
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Env are the environment variables of the pod
+	Env []EnvVar `json:"env,omitempty"`
+}
+
+// EnvVar is an environment variable of the pod, whose value is Value or read from a Secret by SecretKeyRef.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	// SecretKeyRef selects the value in a Secret in the namespace of the KoleDaemonSet,
+	// the value is encrypted to the node and never passes through the broker in plaintext
+	SecretKeyRef *SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// SecretKeySelector selects a key of a Secret, the Secret must be labeled with lite.openyurt.io/pod-secret=true
+// to be sent to the nodes.
+type SecretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -41,8 +41,19 @@ type KoleQueryType string
 const (
 	KoleQueryGet   KoleQueryType = "Get"
 	KoleQueryWatch KoleQueryType = "Watch"
+	// KoleQueryDelete removes the node ObjectName from the controllers, unless the node sends heartbeats after the query is created.
+	KoleQueryDelete KoleQueryType = "Delete"
+	// KoleQueryApprove approves the registration of the pending node ObjectName, the node is approved once it registers
+	// if the query is created before, and it is approved again after it is deleted as long as the query is kept.
+	KoleQueryApprove KoleQueryType = "Approve"
 )
 
+// KoleQueryStatusDeleted is the ObjectStatus of a node removed by a KoleQueryDelete query.
+const KoleQueryStatusDeleted = "Deleted"
+
+// KoleQueryStatusApproved is the ObjectStatus of a node approved by a KoleQueryApprove query.
+const KoleQueryStatusApproved = "Approved"
+
 type KoleQueryObjectType string
 
 const (
@@ -70,6 +81,22 @@ type KoleQueryStatus struct {
 	ObjectType       KoleQueryObjectType `json:"objectType"`
 	ObjectStatus     string              `json:"objectStatus"`
 	ObjectName       string              `json:"objectName"`
+	// Flapping is true if the node changes its state too often recently.
+	// +optional
+	Flapping bool `json:"flapping,omitempty"`
+	// Transitions are the latest state transitions of the node, the oldest first.
+	// +optional
+	Transitions []KoleQueryTransition `json:"transitions,omitempty"`
+}
+
+// KoleQueryTransition is a state transition of a node.
+type KoleQueryTransition struct {
+	Time metav1.Time `json:"time"`
+	From string      `json:"from"`
+	To   string      `json:"to"`
+	// Reason is why the state is changed, such as HeartBeat, Restarted, Timeout and Will.
+	// +optional
+	Reason string `json:"reason,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -25,6 +25,27 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvVar) DeepCopyInto(out *EnvVar) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(SecretKeySelector)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvVar.
+func (in *EnvVar) DeepCopy() *EnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KoleDaemonSet) DeepCopyInto(out *KoleDaemonSet) {
 	*out = *in
@@ -38,7 +59,7 @@ func (in *KoleDaemonSet) DeepCopyInto(out *KoleDaemonSet) {
 	if in.Status != nil {
 		in, out := &in.Status, &out.Status
 		*out = new(KoleDaemonSetStatus)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	return
 }
@@ -94,9 +115,30 @@ func (in *KoleDaemonSetList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KoleDaemonSetShardStatus) DeepCopyInto(out *KoleDaemonSetShardStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KoleDaemonSetShardStatus.
+func (in *KoleDaemonSetShardStatus) DeepCopy() *KoleDaemonSetShardStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KoleDaemonSetShardStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KoleDaemonSetStatus) DeepCopyInto(out *KoleDaemonSetStatus) {
 	*out = *in
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = make([]KoleDaemonSetShardStatus, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -212,6 +254,13 @@ func (in *KoleQuerySpec) DeepCopy() *KoleQuerySpec {
 func (in *KoleQueryStatus) DeepCopyInto(out *KoleQueryStatus) {
 	*out = *in
 	in.LastObservedTime.DeepCopyInto(&out.LastObservedTime)
+	if in.Transitions != nil {
+		in, out := &in.Transitions, &out.Transitions
+		*out = make([]KoleQueryTransition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -225,6 +274,23 @@ func (in *KoleQueryStatus) DeepCopy() *KoleQueryStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KoleQueryTransition) DeepCopyInto(out *KoleQueryTransition) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KoleQueryTransition.
+func (in *KoleQueryTransition) DeepCopy() *KoleQueryTransition {
+	if in == nil {
+		return nil
+	}
+	out := new(KoleQueryTransition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodSpec) DeepCopyInto(out *PodSpec) {
 	*out = *in
@@ -240,6 +306,13 @@ func (in *PodSpec) DeepCopyInto(out *PodSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -253,6 +326,22 @@ func (in *PodSpec) DeepCopy() *PodSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeySelector) DeepCopyInto(out *SecretKeySelector) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeySelector.
+func (in *SecretKeySelector) DeepCopy() *SecretKeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Summary) DeepCopyInto(out *Summary) {
 	*out = *in
@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"regexp"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// A bootstrap token is <token id>.<token secret>, it is stored as a Secret of BootstrapTokenSecretType named
+// BootstrapTokenSecretPrefix + <token id> in the namespace of the controller, and the token is presented by
+// the Registering heartbeats of the nodes to be approved.
+const (
+	BootstrapTokenSecretType   corev1.SecretType = "lite.openyurt.io/bootstrap-token"
+	BootstrapTokenSecretPrefix                   = "bootstrap-token-"
+
+	BootstrapTokenIDKey     = "token-id"
+	BootstrapTokenSecretKey = "token-secret"
+	// BootstrapTokenExpirationKey is the optional expiration time of the token in RFC3339
+	BootstrapTokenExpirationKey = "expiration"
+)
+
+var bootstrapTokenRegexp = regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`)
+
+// ParseBootstrapToken returns the token id and the token secret of token.
+func ParseBootstrapToken(token string) (string, string, error) {
+	m := bootstrapTokenRegexp.FindStringSubmatch(token)
+	if m == nil {
+		return "", "", fmt.Errorf("bootstrap token is not in the format [a-z0-9]{6}.[a-z0-9]{16}")
+	}
+	return m[1], m[2], nil
+}
+
+// BootstrapTokens validates the bootstrap tokens with the cached token secrets.
+type BootstrapTokens struct {
+	lister corelisters.SecretNamespaceLister
+}
+
+// NewBootstrapTokens creates BootstrapTokens looking up the token secrets by lister.
+func NewBootstrapTokens(lister corelisters.SecretNamespaceLister) *BootstrapTokens {
+	return &BootstrapTokens{
+		lister: lister,
+	}
+}
+
+// NewBootstrapTokenInformerFactory creates the informer factory caching only the token secrets in namespace.
+func NewBootstrapTokenInformerFactory(client kubernetes.Interface, namespace string) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("type", string(BootstrapTokenSecretType)).String()
+		}))
+}
+
+// Validate returns nil if token is valid at now.
+func (t *BootstrapTokens) Validate(token string, now time.Time) error {
+	id, secret, err := ParseBootstrapToken(token)
+	if err != nil {
+		return err
+	}
+	s, err := t.lister.Get(BootstrapTokenSecretPrefix + id)
+	if errors.IsNotFound(err) {
+		return fmt.Errorf("bootstrap token %s is not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if s.Type != BootstrapTokenSecretType || string(s.Data[BootstrapTokenIDKey]) != id {
+		return fmt.Errorf("secret %s/%s is not the bootstrap token %s", s.Namespace, s.Name, id)
+	}
+	if subtle.ConstantTimeCompare(s.Data[BootstrapTokenSecretKey], []byte(secret)) != 1 {
+		return fmt.Errorf("bootstrap token %s has a wrong secret", id)
+	}
+	if expiration := s.Data[BootstrapTokenExpirationKey]; len(expiration) != 0 {
+		at, err := time.Parse(time.RFC3339, string(expiration))
+		if err != nil {
+			return fmt.Errorf("bootstrap token %s has an invalid expiration %q", id, expiration)
+		}
+		if now.After(at) {
+			return fmt.Errorf("bootstrap token %s expired at %s", id, at)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+const (
+	// certificateClockSkew backdates the certificates for the nodes whose clocks are behind
+	certificateClockSkew = 5 * time.Minute
+	// certificateRetryAfter is the time the node waits before requesting again when its request is rejected
+	certificateRetryAfter = 30 * time.Second
+)
+
+// The results of the certificate requests, which label the certificate requests metric.
+const (
+	CertificateIssued   = "issued"
+	CertificateRejected = "rejected"
+	CertificateFailed   = "failed"
+)
+
+// CertificateIssuer issues the short-lived client certificates of the nodes with the CA in a kubernetes.io/tls Secret.
+type CertificateIssuer struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	// the lifetime of the issued certificates
+	duration time.Duration
+
+	lock  sync.RWMutex
+	ca    *x509.Certificate
+	caKey crypto.Signer
+}
+
+// NewCertificateIssuer loads the CA from the secret namespace/name.
+func NewCertificateIssuer(client kubernetes.Interface, namespace, name string, duration time.Duration) (*CertificateIssuer, error) {
+	i := &CertificateIssuer{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		duration:  duration,
+	}
+	if err := i.Refresh(); err != nil {
+		klog.Errorf("Load client CA from secret %s/%s error %v", namespace, name, err)
+		return nil, err
+	}
+	return i, nil
+}
+
+// Refresh reloads the CA from the secret, the cached CA is kept if the secret can not be loaded.
+func (i *CertificateIssuer) Refresh() error {
+	secret, err := i.client.CoreV1().Secrets(i.namespace).Get(context.Background(), i.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return i.setSecret(secret)
+}
+
+func (i *CertificateIssuer) setSecret(secret *corev1.Secret) error {
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("invalid CA in secret %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !ca.IsCA || (ca.KeyUsage != 0 && ca.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return fmt.Errorf("certificate in secret %s/%s can not sign certificates", secret.Namespace, secret.Name)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported CA key in secret %s/%s", secret.Namespace, secret.Name)
+	}
+
+	i.lock.Lock()
+	i.ca, i.caKey = ca, key
+	i.lock.Unlock()
+	return nil
+}
+
+// Issue issues the PEM encoded client certificate of node for the PEM encoded certificate request, which must name
+// the node as its common name, and be signed by the identity key of the node, the PKIX encoded key pinned to the node.
+func (i *CertificateIssuer) Issue(node string, request, identity []byte, now time.Time) ([]byte, error) {
+	block, _ := pem.Decode(request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("certificate request is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	if csr.Subject.CommonName != node {
+		return nil, fmt.Errorf("certificate request of %q is sent by node %s", csr.Subject.CommonName, node)
+	}
+	if len(identity) == 0 {
+		return nil, fmt.Errorf("node %s has no pinned identity key", node)
+	}
+	key, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(key, identity) {
+		return nil, fmt.Errorf("certificate request of node %s is not signed by its pinned identity key", node)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	i.lock.RLock()
+	ca, caKey := i.ca, i.caKey
+	i.lock.RUnlock()
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		// only the name of the node is taken from the request
+		Subject: pkix.Name{
+			CommonName:   node,
+			Organization: []string{data.CertificateOrganization},
+		},
+		NotBefore:             now.Add(-certificateClockSkew),
+		NotAfter:              now.Add(i.duration),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func (c *KoleController) refreshCertificateIssuer() {
+	if err := c.CertificateIssuer.Refresh(); err != nil {
+		klog.Errorf("Refresh client CA error %v, use the cached CA", err)
+	}
+}
+
+// ConsumeCertificateRequest issues the client certificate requested by payload, and sends it to CTL of the node.
+// The requests are received by every instance, and issued by the leader of the instances owning the node.
+func (c *KoleController) ConsumeCertificateRequest(payload []byte) {
+	var signer string
+	if c.MessageVerifier != nil {
+		m, err := c.MessageVerifier.Open(data.SignedTypeCertificateRequest, payload)
+		if err != nil {
+			countRejectedMessage(data.SignedTypeCertificateRequest, err)
+			return
+		}
+		payload, signer = m.Payload, m.Node
+	}
+	req, err := data.UnmarshalPayloadToCertificateRequest(payload)
+	if err != nil {
+		klog.Errorf("UnmarshalPayloadToCertificateRequest error %v", err)
+		return
+	}
+	// the certificates are only issued to the nodes proving their names by the signatures
+	if len(signer) == 0 {
+		countRejectedMessage(data.SignedTypeCertificateRequest, &data.SignatureError{Reason: data.RejectUnsigned,
+			Err: fmt.Errorf("certificate request of node %s is not signed", req.Name)})
+		return
+	}
+	if signer != req.Name {
+		countRejectedMessage(data.SignedTypeCertificateRequest, &data.SignatureError{Reason: data.RejectInvalid,
+			Err: fmt.Errorf("certificate request of node %s is signed by node %s", req.Name, signer)})
+		return
+	}
+	if c.CertificateIssuer == nil || !c.Shard.Owns(req.Name) || !c.IsLeader() {
+		return
+	}
+
+	ack := &data.HeartBeatACK{
+		Identifier: req.Identifier,
+		NodeName:   req.Name,
+	}
+	// the certificates are only issued to the registered nodes, so they are approved before
+	n := c.Nodes.Get(req.Name)
+	if n == nil || n.HeartBeat.State == data.HeartBeatPending {
+		klog.V(4).Infof("Reject certificate request of node %s, it is not registered", req.Name)
+		certificateRequests.WithLabelValues(CertificateRejected).Inc()
+		ack.RetryAfter = int64(certificateRetryAfter.Seconds())
+		c.Dispatcher.SendAck(ack)
+		return
+	}
+	// the key of the certificates is the identity key reported by the registration, which is pinned to the node
+	ack.Certificate, err = c.CertificateIssuer.Issue(req.Name, req.Request, n.HeartBeat.EncryptionKey, time.Now())
+	if err != nil {
+		klog.Errorf("Issue certificate of node %s error %v", req.Name, err)
+		certificateRequests.WithLabelValues(CertificateFailed).Inc()
+		ack.RetryAfter = int64(certificateRetryAfter.Seconds())
+		c.Dispatcher.SendAck(ack)
+		return
+	}
+	klog.V(4).Infof("Issue certificate of node %s", req.Name)
+	certificateRequests.WithLabelValues(CertificateIssued).Inc()
+	c.Dispatcher.SendAck(ack)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestCertificateIssuer(t *testing.T) {
+	now := time.Now()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kole-client-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA error %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	issuer := &CertificateIssuer{duration: time.Hour}
+	if err := issuer.setSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}); err != nil {
+		t.Fatalf("load CA error %v", err)
+	}
+
+	nodeKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	identity, _ := x509.MarshalPKIXPublicKey(&nodeKey.PublicKey)
+	requestBy := func(name string, key *ecdsa.PrivateKey) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: name, Organization: []string{"system:masters"}},
+		}, key)
+		if err != nil {
+			t.Fatalf("create certificate request error %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	request := func(name string) []byte {
+		return requestBy(name, nodeKey)
+	}
+
+	certPEM, err := issuer.Issue("node", request("node"), identity, now)
+	if err != nil {
+		t.Fatalf("issue certificate error %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse certificate error %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer.ca)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("verify certificate error %v", err)
+	}
+	// only the name is taken from the request
+	if cert.Subject.CommonName != "node" || len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != data.CertificateOrganization {
+		t.Errorf("unexpected subject %v", cert.Subject)
+	}
+	if !cert.NotAfter.Equal(now.Add(time.Hour).Truncate(time.Second)) {
+		t.Errorf("expect certificate expires at %v, get %v", now.Add(time.Hour), cert.NotAfter)
+	}
+
+	// the lifetime is limited by the CA
+	if certPEM, err := issuer.Issue("node", request("node"), identity, now.Add(23*time.Hour+30*time.Minute)); err != nil {
+		t.Errorf("issue certificate error %v", err)
+	} else if block, _ := pem.Decode(certPEM); block == nil {
+		t.Errorf("invalid certificate")
+	} else if cert, _ := x509.ParseCertificate(block.Bytes); !cert.NotAfter.Equal(issuer.ca.NotAfter) {
+		t.Errorf("expect certificate expires with the CA at %v, get %v", issuer.ca.NotAfter, cert.NotAfter)
+	}
+
+	if _, err := issuer.Issue("node", request("other"), identity, now); err == nil {
+		t.Errorf("expect error for the request of another node")
+	}
+	tampered := request("node")
+	tampered[len(tampered)/2] ^= 1
+	if _, err := issuer.Issue("node", tampered, identity, now); err == nil {
+		t.Errorf("expect error for tampered request")
+	}
+	// the certificates are only issued for the pinned identity key
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := issuer.Issue("node", requestBy("node", otherKey), identity, now); err == nil {
+		t.Errorf("expect error for the request signed by another key")
+	}
+	if _, err := issuer.Issue("node", request("node"), nil, now); err == nil {
+		t.Errorf("expect error for the node without identity key")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	CodecNone   = "none"
+	CodecGzip   = "gzip"
+	CodecLzw    = "lzw"
+	CodecFlate  = "flate"
+	CodecSnappy = "snappy"
+	CodecLz4    = "lz4"
+	CodecZstd   = "zstd"
+
+	// CodecLegacy is the codec of the snapshots saved before the codec is recorded, they were always gzipped
+	CodecLegacy = CodecGzip
+)
+
+// Codec is a named compress algorithm, which can process data as a whole buffer or as a stream.
+type Codec interface {
+	DataProcesser
+	StreamDataProcesser
+	Name() string
+}
+
+// CodecOptions are the options to create a codec, the options not supported by the codec are ignored.
+type CodecOptions struct {
+	// Level is the compression level, nil means the default level of the codec
+	Level *int
+	// Dictionary is the zstd dictionary
+	Dictionary []byte
+}
+
+// level returns the level of the options, def if it is not set.
+func (o CodecOptions) level(def int) int {
+	if o.Level == nil {
+		return def
+	}
+	return *o.Level
+}
+
+// CodecFactory creates a codec with options.
+type CodecFactory func(options CodecOptions) (Codec, error)
+
+var codecLock = &sync.RWMutex{}
+var codecFactories = make(map[string]CodecFactory)
+
+func init() {
+	RegisterCodec(CodecNone, func(options CodecOptions) (Codec, error) {
+		return &DataProcessNothing{}, nil
+	})
+	RegisterCodec(CodecGzip, func(options CodecOptions) (Codec, error) {
+		if level := options.level(-1); level < -2 || level > 9 {
+			return nil, fmt.Errorf("invalid gzip level %d, must be in [-2, 9]", level)
+		}
+		return &Gzip{Level: options.Level}, nil
+	})
+	RegisterCodec(CodecLzw, func(options CodecOptions) (Codec, error) {
+		return &Lzw{}, nil
+	})
+	RegisterCodec(CodecFlate, func(options CodecOptions) (Codec, error) {
+		if level := options.level(-1); level < -2 || level > 9 {
+			return nil, fmt.Errorf("invalid flate level %d, must be in [-2, 9]", level)
+		}
+		return &Flate{Level: options.Level}, nil
+	})
+	RegisterCodec(CodecSnappy, func(options CodecOptions) (Codec, error) {
+		return &Snappy{}, nil
+	})
+	RegisterCodec(CodecLz4, func(options CodecOptions) (Codec, error) {
+		level := options.level(0)
+		if level < 0 {
+			return nil, fmt.Errorf("invalid lz4 level %d, must not be negative", level)
+		}
+		return &Lz4{Level: level}, nil
+	})
+	RegisterCodec(CodecZstd, func(options CodecOptions) (Codec, error) {
+		level := options.level(0)
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d, must be in [0, 22]", level)
+		}
+		return &Zstd{Level: level, Dictionary: options.Dictionary}, nil
+	})
+}
+
+// RegisterCodec registers the factory of codec name, the registered one is replaced.
+func RegisterCodec(name string, factory CodecFactory) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecFactories[name] = factory
+}
+
+// NewCodec creates the codec registered as name.
+func NewCodec(name string, options CodecOptions) (Codec, error) {
+	codecLock.RLock()
+	factory, ok := codecFactories[name]
+	codecLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("codec %q is not supported, supported codecs are %v", name, CodecNames())
+	}
+	return factory(options)
+}
+
+// CodecNames returns the names of all the registered codecs.
+func CodecNames() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	names := make([]string, 0, len(codecFactories))
+	for name := range codecFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var _ Codec = &DataProcessNothing{}
+var _ Codec = &Gzip{}
+var _ Codec = &Lzw{}
+var _ Codec = &Flate{}
+var _ Codec = &Snappy{}
+var _ Codec = &Lz4{}
+var _ Codec = &Zstd{}
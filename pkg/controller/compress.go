@@ -22,11 +22,10 @@ import (
 	"compress/lzw"
 	"io"
 	"io/ioutil"
-	"time"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4"
-	"k8s.io/klog/v2"
 )
 
 type DataProcesser interface {
@@ -34,9 +33,74 @@ type DataProcesser interface {
 	UnCompress(data []byte) ([]byte, error)
 }
 
+// StreamDataProcesser processes data as a stream, so the whole data never needs to be in memory.
+type StreamDataProcesser interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// AsStreamProcesser returns p itself if it supports streaming,
+// otherwise the returned processer buffers all the data and calls the whole buffer methods of p.
+func AsStreamProcesser(p DataProcesser) StreamDataProcesser {
+	if p == nil {
+		return &DataProcessNothing{}
+	}
+	if sp, ok := p.(StreamDataProcesser); ok {
+		return sp
+	}
+	return &bufferedProcesser{processer: p}
+}
+
+type bufferedProcesser struct {
+	processer DataProcesser
+}
+
+type bufferedWriter struct {
+	bytes.Buffer
+	processer DataProcesser
+	w         io.Writer
+}
+
+func (b *bufferedWriter) Close() error {
+	data, err := b.processer.Compress(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = b.w.Write(data)
+	return err
+}
+
+func (b *bufferedProcesser) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &bufferedWriter{processer: b.processer, w: w}, nil
+}
+
+func (b *bufferedProcesser) NewReader(r io.Reader) (io.ReadCloser, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = b.processer.UnCompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
 type DataProcessNothing struct {
 }
 
+func (d *DataProcessNothing) Name() string {
+	return CodecNone
+}
+
 func (d *DataProcessNothing) Compress(data []byte) ([]byte, error) {
 	return data, nil
 }
@@ -45,149 +109,303 @@ func (d *DataProcessNothing) UnCompress(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-var _ DataProcesser = &DataProcessNothing{}
+func (d *DataProcessNothing) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
 
-//Gzip Compress/EnCompress
-type Gzip struct {
+func (d *DataProcessNothing) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
 }
 
-func (g *Gzip) Compress(data []byte) ([]byte, error) {
+// ProcessChain runs Compress of every stage in order, and UnCompress in the reverse order.
+type ProcessChain []DataProcesser
+
+func (p ProcessChain) Compress(data []byte) ([]byte, error) {
+	var err error
+	for _, stage := range p {
+		if data, err = stage.Compress(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (p ProcessChain) UnCompress(data []byte) ([]byte, error) {
+	var err error
+	for i := len(p) - 1; i >= 0; i-- {
+		if data, err = p[i].UnCompress(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// chainWriter closes the writers of all the stages from the first to the last one,
+// so the data flushed by a stage can be processed by the next stages.
+type chainWriter struct {
+	io.Writer
+	writers []io.WriteCloser
+}
+
+func (c *chainWriter) Close() error {
+	for _, w := range c.writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p ProcessChain) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	writers := make([]io.WriteCloser, len(p))
+	for i := len(p) - 1; i >= 0; i-- {
+		sw, err := AsStreamProcesser(p[i]).NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		writers[i] = sw
+		w = sw
+	}
+	return &chainWriter{Writer: w, writers: writers}, nil
+}
+
+type chainReader struct {
+	io.Reader
+	readers []io.ReadCloser
+}
+
+func (c *chainReader) Close() error {
+	var err error
+	for _, r := range c.readers {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (p ProcessChain) NewReader(r io.Reader) (io.ReadCloser, error) {
+	readers := make([]io.ReadCloser, 0, len(p))
+	for i := len(p) - 1; i >= 0; i-- {
+		sr, err := AsStreamProcesser(p[i]).NewReader(r)
+		if err != nil {
+			for _, opened := range readers {
+				opened.Close()
+			}
+			return nil, err
+		}
+		readers = append(readers, sr)
+		r = sr
+	}
+	return &chainReader{Reader: r, readers: readers}, nil
+}
+
+var _ DataProcesser = ProcessChain{}
+var _ StreamDataProcesser = ProcessChain{}
+
+// streamCompress processes the whole data with the stream writer of p.
+func streamCompress(p StreamDataProcesser, data []byte) ([]byte, error) {
 	var buffer bytes.Buffer
-	writer := gzip.NewWriter(&buffer)
-	_, err := writer.Write(data)
+	w, err := p.NewWriter(&buffer)
 	if err != nil {
-		//TODO: should we call close before return?
-		klog.Warning("Gzip Compress fail:", err)
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
 		return nil, err
 	}
 	// We should close the writer immediately instead of using defer.
-	if err = writer.Close(); err != nil {
-		klog.Warning("Close the Gzip Object fail:", err)
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
 	return buffer.Bytes(), nil
 }
 
-func (g *Gzip) UnCompress(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
+// streamUnCompress processes the whole data with the stream reader of p.
+func streamUnCompress(p StreamDataProcesser, data []byte) ([]byte, error) {
+	r, err := p.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err = reader.Close(); err != nil {
-			println("Gzip EnCompress fail:", err.Error())
-		}
-	}()
-	return ioutil.ReadAll(reader)
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+//Gzip Compress/EnCompress
+type Gzip struct {
+	// nil means gzip.DefaultCompression
+	Level *int
+}
+
+func (g *Gzip) Name() string {
+	return CodecGzip
+}
+
+func (g *Gzip) Compress(data []byte) ([]byte, error) {
+	return streamCompress(g, data)
+}
+
+func (g *Gzip) UnCompress(data []byte) ([]byte, error) {
+	return streamUnCompress(g, data)
+}
+
+func (g *Gzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := gzip.DefaultCompression
+	if g.Level != nil {
+		level = *g.Level
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (g *Gzip) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
 }
 
 //Lzw Compress/UnCompress
 type Lzw struct {
 }
 
+func (L *Lzw) Name() string {
+	return CodecLzw
+}
+
 func (L *Lzw) Compress(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
-	w := lzw.NewWriter(buf, lzw.LSB, 8)
-	_, err := w.Write(data)
-	if err != nil {
-		klog.Warning("Lzw Compress fail:", err)
-		return nil, err
-	}
-	if err := w.Close(); err != nil {
-		klog.Warning("Close the Lzw Compress Object fail :", err)
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return streamCompress(L, data)
 }
 
 func (L *Lzw) UnCompress(data []byte) ([]byte, error) {
-	now := time.Now()
-	buf := bytes.NewBuffer(data)
-	r := lzw.NewReader(buf, lzw.LSB, 8)
-	defer func() {
-		if err := r.Close(); err != nil {
-			println("Close the Lzw EnCompress Object fail:", err.Error())
-		}
-	}()
-	needTime := time.Now().Sub(now).Milliseconds()
-	klog.Info("implement Lzw Encompress, need time:", needTime)
-	return ioutil.ReadAll(r)
+	return streamUnCompress(L, data)
+}
+
+func (L *Lzw) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return lzw.NewWriter(w, lzw.LSB, 8), nil
+}
+
+func (L *Lzw) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return lzw.NewReader(r, lzw.LSB, 8), nil
 }
 
 //Flate Compress/EnCompress
 type Flate struct {
+	// nil means flate.DefaultCompression
+	Level *int
 }
 
-func (f *Flate) Compress(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(nil)
+func (f *Flate) Name() string {
+	return CodecFlate
+}
 
-	flateWrite, err := flate.NewWriter(buf, flate.BestCompression)
-	if err != nil {
-		klog.Fatalln(err)
-		return nil, err
-	}
-	defer flateWrite.Close()
-	if _, err := flateWrite.Write(data); err != nil {
-		klog.Info("Write data that will be compressed fail ", err)
-		return nil, err
-	}
-	flateWrite.Flush()
-	return buf.Bytes(), nil
+func (f *Flate) Compress(data []byte) ([]byte, error) {
+	return streamCompress(f, data)
 }
 
 func (f *Flate) UnCompress(data []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(data)
-	flateReader := flate.NewReader(buf)
+	return streamUnCompress(f, data)
+}
 
-	defer flateReader.Close()
-	var rb, err = ioutil.ReadAll(flateReader)
-	if err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			klog.Fatalf(
-				"Err %v, read %v", err, rb)
-		}
+func (f *Flate) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := flate.DefaultCompression
+	if f.Level != nil {
+		level = *f.Level
 	}
-	return rb, nil
+	return flate.NewWriter(w, level)
 }
 
-//SnappyCompress realize the compress/uncompresss algorithm
+func (f *Flate) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+//SnappyCompress realize the compress/uncompresss algorithm with the snappy framing format
 type Snappy struct {
 }
 
+func (Snp *Snappy) Name() string {
+	return CodecSnappy
+}
+
 func (Snp *Snappy) Compress(data []byte) ([]byte, error) {
-	cmp := snappy.Encode(nil, data)
-	return cmp, nil
+	return streamCompress(Snp, data)
 }
 
 func (Snp *Snappy) UnCompress(data []byte) ([]byte, error) {
-	uncmp, err := snappy.Decode(nil, data)
-	if err != nil {
-		klog.Errorf("snappy uncompress fail :%v", err)
-		return nil, err
-	}
-	return uncmp, nil
+	return streamUnCompress(Snp, data)
 }
 
-//lz4 compress algorithm
+func (Snp *Snappy) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (Snp *Snappy) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(snappy.NewReader(r)), nil
+}
+
+//lz4 compress algorithm with the lz4 frame format, so the data is streamed and its size is not limited
 type Lz4 struct {
+	// higher is better, 0 means the fastest compression
+	Level int
+}
+
+func (lz *Lz4) Name() string {
+	return CodecLz4
 }
 
 func (lz *Lz4) Compress(data []byte) ([]byte, error) {
-	cmp := make([]byte, len(data))
-	l, err := lz4.CompressBlock(data, cmp, nil)
-	if err != nil {
-		klog.Errorf("Lz4 algorithm compress fail %v", err)
-		return nil, err
-	}
-	return cmp[:l], nil
+	return streamCompress(lz, data)
 }
 
 func (lz *Lz4) UnCompress(data []byte) ([]byte, error) {
-	uncmp := make([]byte, 10*len(data))
-	l, err := lz4.UncompressBlock(data, uncmp)
+	return streamUnCompress(lz, data)
+}
+
+func (lz *Lz4) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	lw := lz4.NewWriter(w)
+	lw.Header.CompressionLevel = lz.Level
+	return lw, nil
+}
+
+func (lz *Lz4) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+//Zstd compress algorithm, Dictionary is optional and must be the same for compress and uncompress
+type Zstd struct {
+	// 1 (fastest) to 22 (best), 0 means the default level
+	Level int
+	// a zstd dictionary, such as one trained by `zstd --train` with heartbeat json samples
+	Dictionary []byte
+}
+
+func (z *Zstd) Name() string {
+	return CodecZstd
+}
+
+func (z *Zstd) Compress(data []byte) ([]byte, error) {
+	return streamCompress(z, data)
+}
+
+func (z *Zstd) UnCompress(data []byte) ([]byte, error) {
+	return streamUnCompress(z, data)
+}
+
+func (z *Zstd) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if z.Level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(z.Level)))
+	}
+	if len(z.Dictionary) != 0 {
+		opts = append(opts, zstd.WithEncoderDict(z.Dictionary))
+	}
+	return zstd.NewWriter(w, opts...)
+}
+
+func (z *Zstd) NewReader(r io.Reader) (io.ReadCloser, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if len(z.Dictionary) != 0 {
+		opts = append(opts, zstd.WithDecoderDicts(z.Dictionary))
+	}
+	d, err := zstd.NewReader(r, opts...)
 	if err != nil {
-		klog.Errorf("Lz4 algorithm uncompress fail %v", err)
 		return nil, err
 	}
-	return uncmp[:l], nil
+	return d.IOReadCloser(), nil
 }
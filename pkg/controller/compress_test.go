@@ -18,7 +18,10 @@ package controller
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"testing"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 func TestGzip_Compress(t *testing.T) {
@@ -94,6 +97,50 @@ func TestLz4Compress_Compress(t *testing.T) {
 	}
 }
 
+func TestLz4_Ratio(t *testing.T) {
+	// the data compressed as much as lz4 can are uncompressed frame by frame, the ratio is never guessed
+	context := make([]byte, 16*1000*1000)
+	cmp, err := (&Lz4{}).Compress(context)
+	if err != nil {
+		t.Fatalf("compress err: %v", err)
+	}
+	if len(cmp)*200 >= len(context) {
+		t.Fatalf("expect the data compressed better than 200x, get %d bytes", len(cmp))
+	}
+	if uncmp, err := (&Lz4{}).UnCompress(cmp); err != nil {
+		t.Errorf("uncompress err: %v", err)
+	} else if bytes.Compare(context, uncmp) != 0 {
+		t.Errorf("uncompressed data are not the same as original")
+	}
+}
+
+func TestCodecLevel(t *testing.T) {
+	context := bytes.Repeat([]byte(`{"name":"node","state":"Registerd"}`), 1000)
+	// level 0 is no compression, and the default level is used if the level is not set
+	level := 0
+	for _, name := range []string{CodecGzip, CodecFlate} {
+		stored, err := NewCodec(name, CodecOptions{Level: &level})
+		if err != nil {
+			t.Fatalf("new codec %s error %v", name, err)
+		}
+		def, err := NewCodec(name, CodecOptions{})
+		if err != nil {
+			t.Fatalf("new codec %s error %v", name, err)
+		}
+		storedCmp, err := stored.Compress(context)
+		if err != nil {
+			t.Fatalf("compress err: %v", err)
+		}
+		defCmp, err := def.Compress(context)
+		if err != nil {
+			t.Fatalf("compress err: %v", err)
+		}
+		if len(storedCmp) <= len(context) || len(defCmp) >= len(context) {
+			t.Errorf("%s: expect %d bytes stored and compressed, get %d and %d", name, len(context), len(storedCmp), len(defCmp))
+		}
+	}
+}
+
 func TestSnappyCompress_Compress(t *testing.T) {
 	context := []byte("List all InfEdgeNode in ns[summarystorm] error The provided continue parameter is too old to display a consistent list result. You can start a new list without the continue parameter, or use the continue token in this response to retrieve the remainder of the results. Continuing with the provided token results in an inconsistent list - objects that were created, modified, or deleted between the time the first chunk was returned and now may show up in the list.")
 	var (
@@ -114,3 +161,215 @@ func TestSnappyCompress_Compress(t *testing.T) {
 		t.Errorf("uncompressed data are not the same as original")
 	}
 }
+
+func TestAesGcm_Compress(t *testing.T) {
+	context := []byte("List all InfEdgeNode in ns[summarystorm] error The provided continue parameter is too old to display a consistent list result.")
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			"old": bytes.Repeat([]byte("o"), 32),
+		},
+	}
+	keyring, err := NewKeyringFromSecret(secret)
+	if err != nil {
+		t.Fatalf("new keyring error %v", err)
+	}
+	chain := ProcessChain{&Gzip{}, &AesGcm{Keyring: keyring}}
+
+	oldCmp, err := chain.Compress(context)
+	if err != nil {
+		t.Fatalf("compress err: %v", err)
+	}
+	if bytes.Contains(oldCmp, []byte("InfEdgeNode")) {
+		t.Errorf("compressed data is not encrypted")
+	}
+
+	// rotate to the new key, the data encrypted by the old key can still be loaded
+	secret.Data["new"] = bytes.Repeat([]byte("n"), 16)
+	secret.Data[SecretActiveKey] = []byte("new")
+	if err := keyring.setSecret(secret); err != nil {
+		t.Fatalf("rotate key error %v", err)
+	}
+	newCmp, err := chain.Compress(context)
+	if err != nil {
+		t.Fatalf("compress err: %v", err)
+	}
+	for _, cmp := range [][]byte{oldCmp, newCmp} {
+		if uncmp, err := chain.UnCompress(cmp); err != nil {
+			t.Errorf("uncompress err: %v", err)
+		} else if bytes.Compare(context, uncmp) != 0 {
+			t.Errorf("uncompressed data are not the same as original")
+		}
+	}
+
+	// the key id is authenticated
+	tampered := append([]byte{}, newCmp...)
+	copy(tampered[len(encryptedMagic)+1:], "old")
+	if _, err := (&AesGcm{Keyring: keyring}).UnCompress(tampered); err == nil {
+		t.Errorf("expect error for tampered data")
+	}
+
+	delete(secret.Data, "old")
+	if err := keyring.setSecret(secret); err != nil {
+		t.Fatalf("remove key error %v", err)
+	}
+	if _, err := chain.UnCompress(oldCmp); err == nil {
+		t.Errorf("expect error for removed key")
+	}
+}
+
+func TestSecretKeyring_Refresh(t *testing.T) {
+	secret := &corev1.Secret{
+		Data: map[string][]byte{
+			"old":           bytes.Repeat([]byte("o"), 32),
+			"new":           bytes.Repeat([]byte("n"), 32),
+			SecretActiveKey: []byte("old"),
+		},
+	}
+	keyring, err := NewKeyringFromSecret(secret)
+	if err != nil {
+		t.Fatalf("new keyring error %v", err)
+	}
+	k := &SecretKeyring{Keyring: keyring}
+	oldCmp, err := (&AesGcm{Keyring: keyring}).Compress([]byte("summary"))
+	if err != nil {
+		t.Fatalf("compress err: %v", err)
+	}
+	if id, ok := EncryptionKeyID(oldCmp); !ok || id != "old" {
+		t.Fatalf("expect data encrypted by key old, get %s", id)
+	}
+
+	// the removal of a key still encrypting the kept data is refused, the cached keys are kept
+	inUse := true
+	delete(secret.Data, "old")
+	secret.Data[SecretActiveKey] = []byte("new")
+	rotated, err := k.update(secret, func(id string) (bool, error) {
+		return id == "old" && inUse, nil
+	})
+	if err == nil || rotated {
+		t.Fatalf("expect the removal of key in use refused, get %v %v", rotated, err)
+	}
+	if id, _ := k.ActiveKey(); id != "old" {
+		t.Errorf("expect active key old, get %s", id)
+	}
+	if _, err := (&AesGcm{Keyring: keyring}).UnCompress(oldCmp); err != nil {
+		t.Errorf("expect the data of the key in use decrypted, get %v", err)
+	}
+
+	// the key is dropped once no kept data is encrypted by it
+	inUse = false
+	rotated, err = k.update(secret, func(id string) (bool, error) {
+		return id == "old" && inUse, nil
+	})
+	if err != nil || !rotated {
+		t.Fatalf("expect key rotated, get %v %v", rotated, err)
+	}
+	if _, ok := k.Key("old"); ok {
+		t.Errorf("expect the removed key dropped")
+	}
+}
+
+func TestAesGcm_Plain(t *testing.T) {
+	keyring, err := NewKeyringFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			"key": bytes.Repeat([]byte("k"), 32),
+		},
+	})
+	if err != nil {
+		t.Fatalf("new keyring error %v", err)
+	}
+	plain := []byte("summary")
+	if _, err := (&AesGcm{Keyring: keyring}).UnCompress(plain); err == nil {
+		t.Errorf("expect plain data rejected")
+	}
+	if d, err := (&AesGcm{Keyring: keyring, AllowPlain: true}).UnCompress(plain); err != nil || !bytes.Equal(d, plain) {
+		t.Errorf("expect plain data allowed, get %s %v", d, err)
+	}
+}
+
+func TestProcessChain_Stream(t *testing.T) {
+	keyring, err := NewKeyringFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			"key": bytes.Repeat([]byte("k"), 32),
+		},
+	})
+	if err != nil {
+		t.Fatalf("new keyring error %v", err)
+	}
+	// the data is larger than a segment of AesGcm, and is processed by processers without streaming support
+	context := bytes.Repeat([]byte("List all InfEdgeNode in ns[summarystorm]"), 10000)
+	chain := ProcessChain{&Gzip{}, &Snappy{}, &AesGcm{Keyring: keyring}}
+
+	var buf bytes.Buffer
+	w, err := chain.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("new writer error %v", err)
+	}
+	for i := 0; i < len(context); i += 1000 {
+		if _, err := w.Write(context[i : i+1000]); err != nil {
+			t.Fatalf("write error %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writer error %v", err)
+	}
+
+	if uncmp, err := chain.UnCompress(buf.Bytes()); err != nil {
+		t.Errorf("uncompress err: %v", err)
+	} else if bytes.Compare(context, uncmp) != 0 {
+		t.Errorf("uncompressed data are not the same as original")
+	}
+
+	// truncate the last segment
+	r, err := chain.NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+	}
+	if err == nil {
+		t.Errorf("expect error for truncated data")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	// highly repetitive data, lz4 used to fail when it is compressed to less than 1/10
+	context := bytes.Repeat([]byte(`{"name":"node","state":"Registerd"}`), 100000)
+	for _, name := range CodecNames() {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewCodec(name, CodecOptions{})
+			if err != nil {
+				t.Fatalf("new codec error %v", err)
+			}
+			if codec.Name() != name {
+				t.Errorf("expect codec %s, get %s", name, codec.Name())
+			}
+			cmp, err := codec.Compress(context)
+			if err != nil {
+				t.Fatalf("compress err: %v", err)
+			}
+			if uncmp, err := codec.UnCompress(cmp); err != nil {
+				t.Errorf("uncompress err: %v", err)
+			} else if bytes.Compare(context, uncmp) != 0 {
+				t.Errorf("uncompressed data are not the same as original")
+			}
+			if name != CodecNone {
+				if _, err := codec.UnCompress(cmp[:len(cmp)/2]); err == nil {
+					t.Errorf("expect error for truncated data")
+				}
+			}
+		})
+	}
+
+	if _, err := NewCodec("unknown", CodecOptions{}); err == nil {
+		t.Errorf("expect error for unknown codec")
+	}
+	invalid, best := 23, 19
+	if _, err := NewCodec(CodecZstd, CodecOptions{Level: &invalid}); err == nil {
+		t.Errorf("expect error for invalid level")
+	}
+	if codec, err := NewCodec(CodecZstd, CodecOptions{Level: &best}); err != nil {
+		t.Errorf("new codec error %v", err)
+	} else if cmp, err := codec.Compress(context); err != nil {
+		t.Errorf("compress err: %v", err)
+	} else if uncmp, err := (&Zstd{}).UnCompress(cmp); err != nil || bytes.Compare(context, uncmp) != 0 {
+		t.Errorf("data compressed with level 19 can not be uncompressed with the default level, %v", err)
+	}
+}
@@ -16,85 +16,126 @@ limitations under the License.
 package controller
 
 import (
-	"context"
-	"path/filepath"
+	"bytes"
+	"sync/atomic"
+	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
 	"github.com/openyurtio/kole/pkg/data"
-	"github.com/openyurtio/kole/pkg/util"
 )
 
 func (c *KoleController) ConsumeHeartBeatDirect(hb *data.HeartBeat) {
+	// every shard receives all the heartbeats, but only the owner of the node consumes it
+	if !c.Shard.Owns(hb.Name) {
+		klog.V(5).Infof("Skip heatbeat Name[%s], it is owned by shard %s", hb.Name, c.Shard.Ring().Owner(hb.Name))
+		return
+	}
 
-	sync_pods := c.ConsumeSingleHeartBeat(hb)
-
-	dataTopic := filepath.Join(util.TopicDataPrefix, hb.Name)
+	c.ConsumeSingleHeartBeat(hb)
+}
 
-	go func(topic string, sends []*data.Pod) {
-		for i, _ := range sends {
-			if err := c.MessageHandler.PublishData(context.Background(), dataTopic, 0, false, sends[i]); err != nil {
-				klog.Errorf("Mqtt5 publish error %v", err)
-				return
-			}
-		}
-	}(dataTopic, sync_pods)
+// ConsumeSingleHeartBeat merges hb into the state of its node, and returns false if hb is dropped as out of order.
+// The pods of the node are diffed and pushed by NodeReconciler.
+func (c *KoleController) ConsumeSingleHeartBeat(hb *data.HeartBeat) bool {
+	return c.consumeHeartBeat(hb, false)
 }
 
-func (c *KoleController) ConsumeSingleHeartBeat(hb *data.HeartBeat) []*data.Pod {
-	c.ReceiveNum++
+// consumeHeartBeat merges hb into the state of its node, hb is loaded from a snapshot if restored is true,
+// and its node is approved before unless hb is pending.
+func (c *KoleController) consumeHeartBeat(hb *data.HeartBeat, restored bool) bool {
+	atomic.AddInt64(&c.ReceiveNum, 1)
 
 	klog.V(5).Infof("Received heatbeat Indentifier[%s] Name[%s] State[%s]", hb.Identifier, hb.Name, hb.State)
 
-	if !c.HeartBeatFilter.SetHeartBeat(hb) {
-		return []*data.Pod{}
-	}
-
-	c.ObserverdPodsCache.SafeSetHeartBeat(hb)
-
-	sync_pods := make([]*data.Pod, 0, 20)
+	var accepted, added, pending, reregister bool
+	var retryAfter time.Duration
 
-	c.DesiredPodsCache.SafeOperate(func() {
-		desiredPods, ok := c.DesiredPodsCache.Cache[hb.Name]
-		if !ok {
-			return
+	hb.LasterTimeStamp = time.Now().Unix()
+	if hb.State == data.HeartBeatUnregistering {
+		return c.unregister(hb)
+	}
+	// the token is only used to approve the node, it is never cached or saved
+	token := hb.BootstrapToken
+	hb.BootstrapToken = ""
+
+	// the heartbeats of a node received by different consumers are merged one by one,
+	// so an older heartbeat never overwrites a newer one which passes the filter later
+	c.Nodes.Update(hb.Name, func(old *NodeState) *NodeState {
+		if old != nil && !old.Accepts(hb) {
+			return old
 		}
-		for _, desiredPod := range desiredPods {
-			find := false
-			needUpdate := false
-			for _, hbPod := range hb.Pods {
-				if desiredPod.Key() == hbPod.Key() {
-					find = true
-					if desiredPod.Hash != hbPod.Hash {
-						needUpdate = true
-					}
-					break
-				}
-			}
-			if !find || needUpdate {
-				sync_pods = append(sync_pods, &data.Pod{
-					Hash:      desiredPod.Hash,
-					Name:      desiredPod.Name,
-					NameSpace: desiredPod.NameSpace,
-					Spec:      desiredPod.Spec,
-				})
+		if old != nil && len(hb.PublicKey) != 0 && len(old.HeartBeat.PublicKey) != 0 && !bytes.Equal(hb.PublicKey, old.HeartBeat.PublicKey) {
+			// the node is pinned by another heartbeat verified at the same time
+			klog.Warningf("Drop heartbeat of node %s signed by a key other than the pinned one", hb.Name)
+			return old
+		}
+		if old != nil && len(hb.EncryptionKey) != 0 && len(old.HeartBeat.EncryptionKey) != 0 && !bytes.Equal(hb.EncryptionKey, old.HeartBeat.EncryptionKey) {
+			// the encryption key is pinned by the first registration, the node must be deleted to register with another key
+			klog.Warningf("Drop heartbeat of node %s reporting an encryption key other than the pinned one", hb.Name)
+			return old
+		}
+		if c.RegistrationApprover != nil && !restored && needsReregistration(old, hb) {
+			reregister = true
+			return old
+		}
+		if c.RegistrationAdmitter != nil && isRegistration(old, hb) {
+			var admitted bool
+			if admitted, retryAfter = c.RegistrationAdmitter.Admit(time.Now()); !admitted {
+				return old
 			}
 		}
-		deleteT := metav1.Now()
-		for _, hbPod := range hb.Pods {
-			if _, ok := desiredPods[hbPod.Key()]; !ok {
-				sync_pods = append(sync_pods, &data.Pod{
-					Hash:            hbPod.Hash,
-					Name:            hbPod.Name,
-					NameSpace:       hbPod.NameSpace,
-					DeleteTimeStamp: &deleteT,
-				})
+		accepted = true
+		cached, reason := hb, ""
+		if c.RegistrationApprover != nil && !restored && needsApproval(old, hb) {
+			if policy := c.RegistrationApprover.Approve(hb, token, time.Now()); len(policy) == 0 {
+				p := *hb
+				p.State = data.HeartBeatPending
+				cached = &p
+			} else {
+				klog.V(4).Infof("Node %s is approved by %s", hb.Name, policy)
+				registrationsApproved.WithLabelValues(policy).Inc()
+				if old != nil {
+					reason = TransitionReasonApproved
+				}
 			}
 		}
+		pending = cached.State == data.HeartBeatPending
+
+		var n *NodeState
+		if old == nil {
+			// a node not cached is adopted from another shard or lost by the snapshot, its desired pods are pushed as well
+			added = true
+			n = NewNodeStateFromHeartBeat(cached)
+		} else {
+			// the approved node is added as well
+			added = old.HeartBeat.State == data.HeartBeatPending
+			n = old.WithHeartBeat(cached)
+		}
+		if len(reason) != 0 {
+			n.Reason = reason
+		}
+		return n
 	})
+	if retryAfter > 0 {
+		c.rejectRegistration(hb, retryAfter)
+	}
+	if reregister {
+		c.requestReregistration(hb)
+		return false
+	}
+	if !accepted {
+		return false
+	}
+	if pending {
+		c.holdRegistration(hb)
+		return true
+	}
 
-	c.HeartBeatCache.ReceiveHeartBeat(hb, c.KoleDaemonSetController)
-
-	return sync_pods
+	if added || hb.State == data.HeartBeatRegistering {
+		c.KoleDaemonSetController.AddHost(hb.Name)
+	}
+	// the pods or the labels reported by the heartbeat may differ from the desired ones
+	c.NodeReconciler.Enqueue(hb.Name)
+	return true
 }
@@ -31,7 +31,6 @@ import (
 	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
 	"github.com/openyurtio/kole/pkg/client/clientset/versioned"
 	"github.com/openyurtio/kole/pkg/client/informers/externalversions"
-	"github.com/openyurtio/kole/pkg/data"
 	"github.com/openyurtio/kole/pkg/util"
 )
 
@@ -56,27 +55,15 @@ func TestConsumeHeartBeat(t *testing.T) {
 	}
 
 	koleInstance := &KoleController{
-		HeartBeatCache: &HeartBeatCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   make(map[string]*data.HeartBeat),
-		},
-		HeartBeatFilter: &HeartBeatFilter{
-			Mutex:  &sync.Mutex{},
-			Filter: make(map[string]*FilterInfo),
-		},
-
-		ObserverdPodsCache: &ObserverdPodsCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   make(map[string]map[string]*data.HeartBeatPod),
-		},
+		Nodes:             NewNodeStore(),
+		PodTemplates:      NewPodTemplates(),
+		DaemonSetCounters: NewDaemonSetCounters(),
 		QueryNodeStatusCache: &QueryNodeStatusCache{
 			RWMutex:      &sync.RWMutex{},
 			NameToStatus: make(map[string]*v1alpha1.KoleQueryStatus),
 		},
-		DesiredPodsCache: &DesiredPodsCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   make(map[string]map[string]*data.Pod)},
 	}
+	koleInstance.NodeReconciler = NewNodeReconciler(koleInstance)
 	factory := externalversions.NewSharedInformerFactory(crdclient, time.Second*70)
 	koleDaemonSetInform := factory.Lite().V1alpha1().KoleDaemonSets()
 	controller, err := NewKoleDaemonSetController(crdclient, koleDaemonSetInform, koleInstance)
@@ -0,0 +1,203 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// DaemonSetCount is the numbers of the pods of a KoleDaemonSet.
+type DaemonSetCount struct {
+	// the nodes desiring the pod
+	Desired int
+	// the nodes running the pod of any version
+	Scheduled int
+	// the nodes running the desired version of the pod
+	Updated int
+	// the nodes running the desired version of the pod, and the pod is ready
+	Ready int
+}
+
+func (c *DaemonSetCount) add(o DaemonSetCount) {
+	c.Desired += o.Desired
+	c.Scheduled += o.Scheduled
+	c.Updated += o.Updated
+	c.Ready += o.Ready
+}
+
+type hashCount struct {
+	scheduled int
+	ready     int
+}
+
+type daemonSetCounter struct {
+	// the nodes with an override of the pod, they are counted with the overrides in overrides
+	overridden int
+	overrides  DaemonSetCount
+	// the pods on the nodes following the template, they are counted by hash since the template can be replaced
+	scheduled int
+	byHash    map[string]*hashCount
+}
+
+func (c *daemonSetCounter) empty() bool {
+	return c.overridden == 0 && c.overrides == (DaemonSetCount{}) && c.scheduled == 0 && len(c.byHash) == 0
+}
+
+type daemonSetCountersShard struct {
+	sync.Mutex
+	// the nodes desiring the templates
+	desiredNodes int
+	// pod key / counter
+	counters map[string]*daemonSetCounter
+}
+
+// DaemonSetCounters counts the pods of all the KoleDaemonSets incrementally with every change of the node states,
+// so the status of a KoleDaemonSet is got without scanning the nodes. The counts of a node depend on its own state only,
+// they are removed and added again when the state is replaced, and a replaced template is counted by its hash.
+// The nodes are counted in the same shards as NodeStore, so the heartbeats merged in parallel are counted in parallel.
+type DaemonSetCounters struct {
+	shards [nodeStoreShards]daemonSetCountersShard
+}
+
+// NewDaemonSetCounters creates counters counting nothing.
+func NewDaemonSetCounters() *DaemonSetCounters {
+	c := &DaemonSetCounters{}
+	for i := range c.shards {
+		c.shards[i].counters = make(map[string]*daemonSetCounter)
+	}
+	return c
+}
+
+func (c *DaemonSetCounters) shard(name string) *daemonSetCountersShard {
+	return &c.shards[nodeShard(name)]
+}
+
+// Update replaces the counts of node state old with those of new, either of them may be nil.
+func (c *DaemonSetCounters) Update(old, new *NodeState) {
+	var name string
+	if old != nil {
+		name = old.Name
+	} else if new != nil {
+		name = new.Name
+	} else {
+		return
+	}
+	shard := c.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+	shard.apply(old, -1)
+	shard.apply(new, 1)
+}
+
+func (c *daemonSetCountersShard) counter(key string) *daemonSetCounter {
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &daemonSetCounter{byHash: make(map[string]*hashCount)}
+		c.counters[key] = counter
+	}
+	return counter
+}
+
+func (c *daemonSetCountersShard) release(key string, counter *daemonSetCounter) {
+	if counter.empty() {
+		delete(c.counters, key)
+	}
+}
+
+func running(pod *data.HeartBeatPod) bool {
+	return pod.Status != nil && pod.Status.Phase == data.HeartBeatPodStatusRunning
+}
+
+func (c *daemonSetCountersShard) apply(s *NodeState, delta int) {
+	if s == nil || !s.Desired {
+		return
+	}
+	c.desiredNodes += delta
+
+	for key, desired := range s.Overrides {
+		counter := c.counter(key)
+		counter.overridden += delta
+		if desired != nil {
+			counter.overrides.Desired += delta
+			if pod, ok := s.ObservedPods[key]; ok {
+				counter.overrides.Scheduled += delta
+				if pod.Hash == desired.Hash {
+					counter.overrides.Updated += delta
+					if running(pod) {
+						counter.overrides.Ready += delta
+					}
+				}
+			}
+		}
+		c.release(key, counter)
+	}
+
+	for key, pod := range s.ObservedPods {
+		if _, ok := s.Overrides[key]; ok {
+			continue
+		}
+		counter := c.counter(key)
+		counter.scheduled += delta
+		hc, ok := counter.byHash[pod.Hash]
+		if !ok {
+			hc = &hashCount{}
+			counter.byHash[pod.Hash] = hc
+		}
+		hc.scheduled += delta
+		if running(pod) {
+			hc.ready += delta
+		}
+		if *hc == (hashCount{}) {
+			delete(counter.byHash, pod.Hash)
+		}
+		c.release(key, counter)
+	}
+}
+
+// Count returns the numbers of the pods of key, which are desired as template on the nodes without overrides.
+// The shards are counted one by one, so the nodes changed meanwhile may be counted with either of their states.
+func (c *DaemonSetCounters) Count(key string, template *data.Pod) DaemonSetCount {
+	var count DaemonSetCount
+	for i := range c.shards {
+		count.add(c.shards[i].count(key, template))
+	}
+	return count
+}
+
+func (c *daemonSetCountersShard) count(key string, template *data.Pod) DaemonSetCount {
+	c.Lock()
+	defer c.Unlock()
+
+	var count DaemonSetCount
+	counter := c.counters[key]
+	if template != nil {
+		count.Desired = c.desiredNodes
+		if counter != nil {
+			count.Desired -= counter.overridden
+			count.Scheduled = counter.scheduled
+			if hc, ok := counter.byHash[template.Hash]; ok {
+				count.Updated = hc.scheduled
+				count.Ready = hc.ready
+			}
+		}
+	}
+	if counter != nil {
+		count.add(counter.overrides)
+	}
+	return count
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// countByScan counts the pods of key by scanning all the nodes.
+func countByScan(nodes []*NodeState, templates map[string]*data.Pod, key string) DaemonSetCount {
+	var count DaemonSetCount
+	for _, node := range nodes {
+		desired := node.DesiredPod(templates, key)
+		if desired == nil {
+			continue
+		}
+		count.Desired++
+		if pod, ok := node.ObservedPods[key]; ok {
+			count.Scheduled++
+			if pod.Hash == desired.Hash {
+				count.Updated++
+				if running(pod) {
+					count.Ready++
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestDaemonSetCounters(t *testing.T) {
+	counters := NewDaemonSetCounters()
+	store := NewNodeStore()
+	store.OnChange = counters.Update
+	templates := NewPodTemplates()
+	// the pod key is the namespace and the name
+	keys := []string{"a", "b"}
+	for _, key := range keys {
+		templates.Set("-"+key, &data.Pod{Name: key, Hash: "1"})
+	}
+
+	r := rand.New(rand.NewSource(1))
+	phases := []string{data.HeartBeatPodStatusRunning, ""}
+	for i := 0; i < 2000; i++ {
+		name := fmt.Sprintf("node-%d", r.Intn(20))
+		key := keys[r.Intn(len(keys))]
+		hash := fmt.Sprintf("%d", r.Intn(3))
+		switch r.Intn(6) {
+		case 0:
+			store.Remove([]string{name})
+		case 1:
+			templates.Set("-"+key, &data.Pod{Name: key, Hash: hash})
+		case 2:
+			store.Update(name, func(old *NodeState) *NodeState {
+				if old == nil {
+					return old
+				}
+				if r.Intn(2) == 0 {
+					return old.WithOverride("-"+key, nil, r.Intn(2) == 0)
+				}
+				return old.WithOverride("-"+key, &data.Pod{Name: key, Hash: hash}, false)
+			})
+		default:
+			hb := &data.HeartBeat{
+				Name:   name,
+				SeqNum: uint64(i),
+				Pods: []*data.HeartBeatPod{{
+					Name:   key,
+					Hash:   hash,
+					Status: &data.HeartBeatPodStatus{Phase: phases[r.Intn(len(phases))]},
+				}},
+			}
+			store.Update(name, func(old *NodeState) *NodeState {
+				if old == nil {
+					n := NewNodeStateFromHeartBeat(hb)
+					n.Desired = r.Intn(4) != 0
+					return n
+				}
+				return old.WithHeartBeat(hb)
+			})
+		}
+
+		for _, key := range keys {
+			podKey := "-" + key
+			expect := countByScan(store.Snapshot(), templates.List(), podKey)
+			if count := counters.Count(podKey, templates.Get(podKey)); count != expect {
+				t.Fatalf("step %d: expect count %+v of %s, get %+v", i, expect, key, count)
+			}
+		}
+	}
+}
+
+func TestDaemonSetCountersDroppedPod(t *testing.T) {
+	counters := NewDaemonSetCounters()
+	store := NewNodeStore()
+	store.OnChange = counters.Update
+	templates := NewPodTemplates()
+	templates.Set("-a", &data.Pod{Name: "a", Hash: "1"})
+	templates.Set("-b", &data.Pod{Name: "b", Hash: "1"})
+
+	running := &data.HeartBeatPodStatus{Phase: data.HeartBeatPodStatusRunning}
+	hb := &data.HeartBeat{
+		Name:   "node",
+		SeqNum: 1,
+		Pods:   []*data.HeartBeatPod{{Name: "a", Hash: "1", Status: running}, {Name: "b", Hash: "1", Status: running}},
+	}
+	store.Update(hb.Name, func(old *NodeState) *NodeState {
+		n := NewNodeStateFromHeartBeat(hb)
+		n.Desired = true
+		return n
+	})
+	for _, key := range []string{"-a", "-b"} {
+		if count := counters.Count(key, templates.Get(key)); count != (DaemonSetCount{1, 1, 1, 1}) {
+			t.Fatalf("unexpected count %+v of %s", count, key)
+		}
+	}
+
+	// the KoleDaemonSet of b is deleted, and the next heartbeat does not report its pod any more
+	templates.Delete("-b")
+	dropped := &data.HeartBeat{
+		Name:      "node",
+		SeqNum:    1,
+		TimeStamp: 1,
+		Pods:      []*data.HeartBeatPod{{Name: "a", Hash: "1"}},
+	}
+	store.Update(dropped.Name, func(old *NodeState) *NodeState {
+		return old.WithHeartBeat(dropped)
+	})
+	if count := counters.Count("-a", templates.Get("-a")); count != (DaemonSetCount{1, 1, 1, 0}) {
+		t.Errorf("expect pod a to be not ready, get count %+v", count)
+	}
+	if count := counters.Count("-b", nil); count != (DaemonSetCount{}) {
+		t.Errorf("expect no pod b, get count %+v", count)
+	}
+	if _, ok := counters.shard("node").counters["-b"]; ok {
+		t.Errorf("expect the counter of the deleted b to be released")
+	}
+}
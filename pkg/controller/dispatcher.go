@@ -0,0 +1,310 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/message"
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+// Priority is the priority of outbound messages, the messages of a higher priority are delivered first.
+type Priority int
+
+const (
+	// PriorityCTL is the priority of the acks published to the CTL topic of nodes
+	PriorityCTL Priority = iota
+	// PriorityDATA is the priority of the pods published to the DATA topic of nodes
+	PriorityDATA
+
+	numPriorities = 2
+)
+
+func (p Priority) String() string {
+	if p == PriorityCTL {
+		return "ctl"
+	}
+	return "data"
+}
+
+type outboundMessage struct {
+	topic  string
+	object interface{}
+}
+
+// outbox holds the pending messages of a node with a priority, which are delivered one by one in order.
+type outbox struct {
+	node     string
+	priority Priority
+	key      string
+	messages []*outboundMessage
+	// the outbox is dropped by Forget
+	removed bool
+}
+
+// DispatcherOptions are the options of Dispatcher.
+type DispatcherOptions struct {
+	Workers int
+	// the max number of retries of a message before it is dropped
+	MaxRetries int
+	// the rate limit of all the messages
+	QPS   float64
+	Burst int
+	// the rate limit of the messages of a node
+	NodeQPS   float64
+	NodeBurst int
+	// Signer signs the messages when they are delivered, nil means the messages are not signed
+	Signer *data.MessageSigner
+}
+
+// Dispatcher delivers the messages published to the nodes.
+// The messages of a node with the same priority are delivered in FIFO order, so a deleted pod never overtakes the added one,
+// and a failed message is retried with exponential backoff, the following messages of the node wait until it is delivered
+// or dropped after MaxRetries. Workers always take the nodes with pending CTL messages before those with DATA messages.
+type Dispatcher struct {
+	options DispatcherOptions
+	handler message.MessageHandler
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	outboxes [numPriorities]map[string]*outbox
+	// the outboxes waiting for a worker
+	ready    [numPriorities][]*outbox
+	limiters map[string]*rate.Limiter
+	stopped  bool
+
+	limiter *rate.Limiter
+	backoff workqueue.RateLimiter
+}
+
+// NewDispatcher creates a dispatcher, the messages sent before Run are queued.
+func NewDispatcher(options DispatcherOptions) *Dispatcher {
+	d := &Dispatcher{
+		options:  options,
+		limiters: make(map[string]*rate.Limiter),
+		limiter:  rate.NewLimiter(rate.Limit(options.QPS), options.Burst),
+		backoff:  workqueue.NewItemExponentialFailureRateLimiter(100*time.Millisecond, 30*time.Second),
+	}
+	d.cond = sync.NewCond(&d.lock)
+	for i := range d.outboxes {
+		d.outboxes[i] = make(map[string]*outbox)
+	}
+	return d
+}
+
+// SendAck queues ack to the CTL topic of its node.
+func (d *Dispatcher) SendAck(ack *data.HeartBeatACK) {
+	d.Send(ack.NodeName, PriorityCTL, filepath.Join(util.TopicCTLPrefix, ack.NodeName), ack)
+}
+
+// SendPods queues pods to the DATA topic of node.
+func (d *Dispatcher) SendPods(node string, pods ...*data.Pod) {
+	topic := filepath.Join(util.TopicDataPrefix, node)
+	for _, p := range pods {
+		d.Send(node, PriorityDATA, topic, p)
+	}
+}
+
+// Send queues object to topic of node with priority.
+func (d *Dispatcher) Send(node string, priority Priority, topic string, object interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	o, ok := d.outboxes[priority][node]
+	if !ok {
+		o = &outbox{
+			node:     node,
+			priority: priority,
+			key:      priority.String() + "/" + node,
+		}
+		d.outboxes[priority][node] = o
+		d.push(o)
+	}
+	o.messages = append(o.messages, &outboundMessage{topic: topic, object: object})
+	outboundPending.WithLabelValues(priority.String()).Inc()
+}
+
+// Forget drops the pending messages of node, which is removed from the controller.
+func (d *Dispatcher) Forget(node string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for priority := range d.outboxes {
+		if o, ok := d.outboxes[priority][node]; ok {
+			outboundPending.WithLabelValues(o.priority.String()).Sub(float64(len(o.messages)))
+			o.removed = true
+			o.messages = nil
+			delete(d.outboxes[priority], node)
+			d.backoff.Forget(o.key)
+		}
+	}
+	delete(d.limiters, node)
+}
+
+// push makes o ready for the workers, the lock must be held.
+func (d *Dispatcher) push(o *outbox) {
+	d.ready[o.priority] = append(d.ready[o.priority], o)
+	d.cond.Signal()
+}
+
+func (d *Dispatcher) pushAfter(o *outbox, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if !o.removed {
+			d.push(o)
+		}
+	})
+}
+
+// sign signs object to the node of o, it is signed every time it is delivered so the sequences follow the delivery order.
+func (d *Dispatcher) sign(o *outbox, object interface{}) (interface{}, error) {
+	if d.options.Signer == nil {
+		return object, nil
+	}
+	typ := data.SignedTypePod
+	if o.priority == PriorityCTL {
+		typ = data.SignedTypeAck
+	}
+	return d.options.Signer.Sign(typ, o.node, object)
+}
+
+// next blocks until an outbox is ready, it returns nil if the dispatcher is stopped.
+func (d *Dispatcher) next() *outbox {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for !d.stopped {
+		for priority := range d.ready {
+			if len(d.ready[priority]) != 0 {
+				o := d.ready[priority][0]
+				d.ready[priority][0] = nil
+				d.ready[priority] = d.ready[priority][1:]
+				return o
+			}
+		}
+		d.cond.Wait()
+	}
+	return nil
+}
+
+// Run starts the workers delivering messages by handler, and blocks until stop.
+func (d *Dispatcher) Run(handler message.MessageHandler, stop <-chan struct{}) {
+	d.handler = handler
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	klog.Infof("Starting dispatcher with %d workers", d.options.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < d.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := d.next(); o != nil; o = d.next() {
+				d.deliver(ctx, o)
+			}
+		}()
+	}
+
+	<-stop
+	klog.Warningf("Stopping dispatcher")
+	cancel()
+	d.lock.Lock()
+	d.stopped = true
+	d.cond.Broadcast()
+	d.lock.Unlock()
+	wg.Wait()
+}
+
+func (d *Dispatcher) nodeLimiter(node string) *rate.Limiter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	l, ok := d.limiters[node]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(d.options.NodeQPS), d.options.NodeBurst)
+		d.limiters[node] = l
+	}
+	return l
+}
+
+// deliver publishes the first message of o.
+func (d *Dispatcher) deliver(ctx context.Context, o *outbox) {
+	r := d.nodeLimiter(o.node).Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		d.pushAfter(o, delay)
+		return
+	}
+	if err := d.limiter.Wait(ctx); err != nil {
+		// the dispatcher is stopped
+		return
+	}
+
+	d.lock.Lock()
+	if len(o.messages) == 0 {
+		d.lock.Unlock()
+		return
+	}
+	m := o.messages[0]
+	d.lock.Unlock()
+
+	object, err := d.sign(o, m.object)
+	if err == nil {
+		if o.priority == PriorityCTL {
+			err = d.handler.PublishAck(ctx, m.topic, 0, false, object)
+		} else {
+			err = d.handler.PublishData(ctx, m.topic, 0, false, object)
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if o.removed {
+		return
+	}
+	if err != nil {
+		if d.backoff.NumRequeues(o.key) < d.options.MaxRetries {
+			delay := d.backoff.When(o.key)
+			outboundRetries.WithLabelValues(o.priority.String()).Inc()
+			klog.V(4).Infof("Publish to %s error %v, retry in %v", m.topic, err, delay)
+			d.pushAfter(o, delay)
+			return
+		}
+		klog.Errorf("Publish to %s error %v, drop the message after %d retries", m.topic, err, d.options.MaxRetries)
+		outboundFailed.WithLabelValues(o.priority.String()).Inc()
+	} else {
+		outboundDelivered.WithLabelValues(o.priority.String()).Inc()
+	}
+	d.backoff.Forget(o.key)
+	o.messages[0] = nil
+	o.messages = o.messages[1:]
+	outboundPending.WithLabelValues(o.priority.String()).Dec()
+
+	if len(o.messages) == 0 {
+		o.removed = true
+		delete(d.outboxes[o.priority], o.node)
+		return
+	}
+	d.push(o)
+}
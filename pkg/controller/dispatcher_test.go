@@ -0,0 +1,92 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+type recordHandler struct {
+	sync.Mutex
+	published []string
+	// the number of failures before the pods are published
+	failures int
+	done     chan struct{}
+}
+
+func (r *recordHandler) publish(object interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+	switch o := object.(type) {
+	case *data.HeartBeatACK:
+		r.published = append(r.published, "ack")
+	case *data.Pod:
+		if r.failures > 0 {
+			r.failures--
+			return fmt.Errorf("publish failed")
+		}
+		r.published = append(r.published, o.Name)
+	}
+	r.done <- struct{}{}
+	return nil
+}
+
+func (r *recordHandler) PublishData(ctx context.Context, topic string, qos byte, retained bool, object interface{}) error {
+	return r.publish(object)
+}
+
+func (r *recordHandler) PublishAck(ctx context.Context, topic string, qos byte, retained bool, object interface{}) error {
+	return r.publish(object)
+}
+
+func TestDispatcher(t *testing.T) {
+	handler := &recordHandler{failures: 1, done: make(chan struct{}, 10)}
+	d := NewDispatcher(DispatcherOptions{
+		Workers:    1,
+		MaxRetries: 3,
+		QPS:        100,
+		Burst:      100,
+		NodeQPS:    100,
+		NodeBurst:  100,
+	})
+	d.SendPods("node", &data.Pod{Name: "add"}, &data.Pod{Name: "delete"})
+	d.SendAck(&data.HeartBeatACK{NodeName: "node"})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(handler, stop)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-handler.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("messages are not delivered, get %v", handler.published)
+		}
+	}
+
+	handler.Lock()
+	defer handler.Unlock()
+	// the ack goes first, and the failed add is retried before the delete
+	expect := []string{"ack", "add", "delete"}
+	if fmt.Sprint(handler.published) != fmt.Sprint(expect) {
+		t.Errorf("expect %v, get %v", expect, handler.published)
+	}
+}
@@ -0,0 +1,435 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// SecretActiveKey is the key of the secret data which names the key used to encrypt,
+// all the other data of the secret are AES keys (16, 24 or 32 bytes) indexed by key id.
+const SecretActiveKey = "active"
+
+// encryptedMagic is the header of every snapshot encrypted by AesGcm.
+var encryptedMagic = []byte("KOLEAES1")
+
+// Keyring holds the AES keys used to encrypt and decrypt snapshots.
+type Keyring struct {
+	*sync.RWMutex
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+// NewKeyringFromSecret parses the keys from secret.
+func NewKeyringFromSecret(secret *corev1.Secret) (*Keyring, error) {
+	k := &Keyring{
+		RWMutex: &sync.RWMutex{},
+	}
+	if err := k.setSecret(secret); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Keyring) setSecret(secret *corev1.Secret) error {
+	keys := make(map[string][]byte)
+	for id, key := range secret.Data {
+		if id == SecretActiveKey {
+			continue
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("key %s of secret %s/%s has invalid length %d", id, secret.Namespace, secret.Name, len(key))
+		}
+		if len(id) > 255 {
+			return fmt.Errorf("key id %s of secret %s/%s is too long", id, secret.Namespace, secret.Name)
+		}
+		keys[id] = key
+	}
+
+	activeKeyID := string(secret.Data[SecretActiveKey])
+	if len(activeKeyID) == 0 && len(keys) == 1 {
+		for id := range keys {
+			activeKeyID = id
+		}
+	}
+	if _, ok := keys[activeKeyID]; !ok {
+		return fmt.Errorf("active key %q is not found in secret %s/%s", activeKeyID, secret.Namespace, secret.Name)
+	}
+
+	k.Lock()
+	k.activeKeyID = activeKeyID
+	k.keys = keys
+	k.Unlock()
+	return nil
+}
+
+// ActiveKey returns the key id and key used to encrypt.
+func (k *Keyring) ActiveKey() (string, []byte) {
+	k.RLock()
+	defer k.RUnlock()
+	return k.activeKeyID, k.keys[k.activeKeyID]
+}
+
+// Key returns the key of id.
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	k.RLock()
+	defer k.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// SecretKeyring is a Keyring backed by a Kubernetes Secret.
+type SecretKeyring struct {
+	*Keyring
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretKeyring loads the keyring from the secret namespace/name.
+func NewSecretKeyring(client kubernetes.Interface, namespace, name string) (*SecretKeyring, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("Get encryption secret %s/%s error %v", namespace, name, err)
+		return nil, err
+	}
+	keyring, err := NewKeyringFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretKeyring{
+		Keyring:   keyring,
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}, nil
+}
+
+// Refresh reloads the keys from the secret, and returns true if the active key is rotated.
+// The cached keys are kept if the secret can not be loaded, or a key removed from the secret is still in use,
+// which inUse returns true for, since the data encrypted by it could not be read after restarting.
+func (k *SecretKeyring) Refresh(inUse func(id string) (bool, error)) (bool, error) {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(context.Background(), k.name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return k.update(secret, inUse)
+}
+
+func (k *SecretKeyring) update(secret *corev1.Secret, inUse func(id string) (bool, error)) (bool, error) {
+	next, err := NewKeyringFromSecret(secret)
+	if err != nil {
+		return false, err
+	}
+
+	k.RLock()
+	var removed []string
+	for id := range k.keys {
+		if _, ok := next.keys[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	oldID, oldKey := k.activeKeyID, k.keys[k.activeKeyID]
+	k.RUnlock()
+
+	if inUse != nil {
+		for _, id := range removed {
+			used, err := inUse(id)
+			if err != nil {
+				return false, err
+			}
+			if used {
+				return false, fmt.Errorf("key %s is removed from secret %s/%s, but it still encrypts the kept data, "+
+					"it must be kept in the secret until the data is trimmed", id, secret.Namespace, secret.Name)
+			}
+		}
+	}
+
+	k.Lock()
+	k.activeKeyID = next.activeKeyID
+	k.keys = next.keys
+	k.Unlock()
+	newID, newKey := k.ActiveKey()
+	return oldID != newID || !bytes.Equal(oldKey, newKey), nil
+}
+
+// EncryptionKeyID returns the id of the key encrypting data by AesGcm, false if data is not encrypted.
+// Only the header of the data is needed.
+func EncryptionKeyID(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, encryptedMagic) || len(data) < len(encryptedMagic)+1 {
+		return "", false
+	}
+	l := int(data[len(encryptedMagic)])
+	if len(data) < len(encryptedMagic)+1+l {
+		return "", false
+	}
+	return string(data[len(encryptedMagic)+1 : len(encryptedMagic)+1+l]), true
+}
+
+// aesGcmSegmentSize is the max size of plain data sealed in one segment.
+const aesGcmSegmentSize = 64 * 1024
+
+// aesGcmNoncePrefixSize is the size of the random nonce prefix, the rest 5 bytes of a nonce
+// are the segment counter and the flag of the last segment.
+const aesGcmNoncePrefixSize = 7
+
+// AesGcm encrypts data with the active key of Keyring.
+// The data is split into segments and each segment is sealed on its own, so it can be processed as a stream:
+// | magic | len(key id) | key id | nonce prefix | sealed segment | ... | sealed last segment |.
+// The header is authenticated with every segment, and the nonce of a segment is made of the nonce prefix,
+// the segment counter and the last segment flag, so segments can not be reordered or truncated.
+type AesGcm struct {
+	Keyring *Keyring
+	// AllowPlain accepts the data not encrypted, such as the snapshots saved before the encryption is enabled.
+	// The plain data is not authenticated, so it is rejected unless it is allowed explicitly.
+	AllowPlain bool
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, aesGcmNoncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = append(nonce, byte(counter>>24), byte(counter>>16), byte(counter>>8), byte(counter))
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type aesGcmWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	sealed  []byte
+	closed  bool
+}
+
+func (a *aesGcmWriter) seal(last bool) error {
+	if a.counter == ^uint32(0) {
+		return fmt.Errorf("too many segments to encrypt")
+	}
+	a.sealed = a.aead.Seal(a.sealed[:0], segmentNonce(a.prefix, a.counter, last), a.buf, a.header)
+	a.counter++
+	a.buf = a.buf[:0]
+	_, err := a.w.Write(a.sealed)
+	return err
+}
+
+func (a *aesGcmWriter) Write(p []byte) (int, error) {
+	if a.closed {
+		return 0, fmt.Errorf("write to closed writer")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// the segment is sealed only if there is more data, the last segment is sealed by Close
+		if len(a.buf) == aesGcmSegmentSize {
+			if err := a.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		l := aesGcmSegmentSize - len(a.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		a.buf = append(a.buf, p[:l]...)
+		p = p[l:]
+	}
+	return n, nil
+}
+
+func (a *aesGcmWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return a.seal(true)
+}
+
+func (a *AesGcm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	id, key := a.Keyring.ActiveKey()
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(encryptedMagic)+1+len(id)+aesGcmNoncePrefixSize)
+	header = append(header, encryptedMagic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	prefix := make([]byte, aesGcmNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &aesGcmWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, aesGcmSegmentSize),
+		sealed: make([]byte, 0, aesGcmSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+type aesGcmReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	sealed  []byte
+	plain   []byte
+	done    bool
+}
+
+func (a *aesGcmReader) open() error {
+	n, err := io.ReadFull(a.r, a.sealed[:cap(a.sealed)])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	last := n < cap(a.sealed)
+	if !last {
+		if _, perr := a.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	a.plain, err = a.aead.Open(a.plain[:0], segmentNonce(a.prefix, a.counter, last), a.sealed[:n], a.header)
+	if err != nil {
+		return fmt.Errorf("decrypt segment %d error %v", a.counter, err)
+	}
+	a.counter++
+	a.done = last
+	return nil
+}
+
+func (a *aesGcmReader) Read(p []byte) (int, error) {
+	for len(a.plain) == 0 {
+		if a.done {
+			return 0, io.EOF
+		}
+		if err := a.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, a.plain)
+	a.plain = a.plain[n:]
+	return n, nil
+}
+
+func (a *aesGcmReader) Close() error {
+	return nil
+}
+
+func (a *AesGcm) NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, aesGcmSegmentSize)
+	magic, err := br.Peek(len(encryptedMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, encryptedMagic) {
+		if !a.AllowPlain {
+			return nil, fmt.Errorf("data is not encrypted")
+		}
+		// snapshots saved before the encryption is enabled
+		klog.Warningf("Snapshot data is not encrypted, load it as plain data")
+		return ioutil.NopCloser(br), nil
+	}
+
+	header := make([]byte, len(encryptedMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	idAndPrefix := make([]byte, int(header[len(encryptedMagic)])+aesGcmNoncePrefixSize)
+	if _, err := io.ReadFull(br, idAndPrefix); err != nil {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	header = append(header, idAndPrefix...)
+	id := string(idAndPrefix[:len(idAndPrefix)-aesGcmNoncePrefixSize])
+
+	key, ok := a.Keyring.Key(id)
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q is not found", id)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGcmReader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		prefix: idAndPrefix[len(idAndPrefix)-aesGcmNoncePrefixSize:],
+		sealed: make([]byte, 0, aesGcmSegmentSize+aead.Overhead()),
+		plain:  make([]byte, 0, aesGcmSegmentSize),
+	}, nil
+}
+
+func (a *AesGcm) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := a.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (a *AesGcm) UnCompress(data []byte) ([]byte, error) {
+	r, err := a.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+var _ DataProcesser = &AesGcm{}
+var _ StreamDataProcesser = &AesGcm{}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
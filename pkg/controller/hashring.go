@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points every member owns on a HashRing,
+// more points make the node names spread more evenly.
+const DefaultVirtualNodes = 128
+
+// HashRing is an immutable consistent hash ring, which maps a node name to one of its members.
+// When a member joins or leaves, only the node names of the neighbouring ranges move.
+type HashRing struct {
+	members []string
+	hashes  []uint32
+	owners  map[uint32]string
+}
+
+// NewHashRing creates a ring of members, every member owns virtualNodes points.
+func NewHashRing(members []string, virtualNodes int) *HashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	r := &HashRing{
+		members: make([]string, 0, len(members)),
+		hashes:  make([]uint32, 0, len(members)*virtualNodes),
+		owners:  make(map[uint32]string, len(members)*virtualNodes),
+	}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.members = append(r.members, m)
+	}
+	sort.Strings(r.members)
+
+	for _, m := range r.members {
+		for i := 0; i < virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(m + "#" + strconv.Itoa(i)))
+			// the smaller member wins a collision, so all the rings of the same members are the same
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = m
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+// Owner returns the member owning name, it is empty if the ring has no member.
+func (r *HashRing) Owner(name string) string {
+	if r == nil || len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(name))
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Members returns the sorted members of the ring.
+func (r *HashRing) Members() []string {
+	if r == nil {
+		return nil
+	}
+	return append([]string{}, r.members...)
+}
+
+// Equal returns true if the two rings have the same members.
+func (r *HashRing) Equal(o *HashRing) bool {
+	a, b := r.Members(), o.Members()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
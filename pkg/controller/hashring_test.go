@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	names := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		names = append(names, fmt.Sprintf("node-%d", i))
+	}
+
+	if o := NewHashRing(nil, 0).Owner("node-0"); o != "" {
+		t.Errorf("expect no owner of empty ring, get %s", o)
+	}
+
+	three := NewHashRing([]string{"c", "a", "b", "a"}, 0)
+	if m := three.Members(); len(m) != 3 || m[0] != "a" {
+		t.Fatalf("unexpected members %v", m)
+	}
+	if !three.Equal(NewHashRing([]string{"b", "c", "a"}, 0)) {
+		t.Errorf("expect rings with the same members are equal")
+	}
+
+	count := make(map[string]int)
+	for _, n := range names {
+		count[three.Owner(n)]++
+	}
+	for _, m := range three.Members() {
+		// every member should own about 1/3 of the names
+		if count[m] < 2000 || count[m] > 4700 {
+			t.Errorf("member %s owns %d of %d names", m, count[m], len(names))
+		}
+	}
+
+	// only the names owned by the leaving member move
+	two := NewHashRing([]string{"a", "c"}, 0)
+	for _, n := range names {
+		before, after := three.Owner(n), two.Owner(n)
+		if before != "b" && before != after {
+			t.Errorf("name %s moves from %s to %s", n, before, after)
+		}
+	}
+}
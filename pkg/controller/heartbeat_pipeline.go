@@ -0,0 +1,144 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// HeartBeatPipeline is a bounded queue between receiving and processing heartbeats.
+// Only the newest pending heartbeat of a node is kept, so the queue holds at most one entry per node,
+// and a node sending heartbeats faster than they are processed never takes more than one queue entry.
+// A heartbeat received while the previous one of the node is processed is queued again.
+// When the queue is full, Enqueue blocks the receiver for a while to apply backpressure to the broker,
+// and then drops the pending heartbeat of the node, including the newer ones coalesced into it,
+// the node will be processed with its next heartbeat.
+type HeartBeatPipeline struct {
+	lock    sync.Mutex
+	pending map[string]*data.HeartBeat
+	queue   chan string
+	// closed when the pipeline is stopped, to release the receivers waiting for the queue
+	stopped chan struct{}
+
+	workers        int
+	enqueueTimeout time.Duration
+	process        func(hb *data.HeartBeat)
+}
+
+// NewHeartBeatPipeline creates a pipeline holding at most size nodes, which are processed by workers with process.
+func NewHeartBeatPipeline(size, workers int, enqueueTimeout time.Duration, process func(hb *data.HeartBeat)) *HeartBeatPipeline {
+	return &HeartBeatPipeline{
+		pending:        make(map[string]*data.HeartBeat, size),
+		queue:          make(chan string, size),
+		stopped:        make(chan struct{}),
+		workers:        workers,
+		enqueueTimeout: enqueueTimeout,
+		process:        process,
+	}
+}
+
+func newerHeartBeat(hb, old *data.HeartBeat) bool {
+	return hb.SeqNum > old.SeqNum || (hb.SeqNum == old.SeqNum && hb.TimeStamp > old.TimeStamp)
+}
+
+// Enqueue adds hb to the pipeline, it returns false if hb is dropped.
+func (p *HeartBeatPipeline) Enqueue(hb *data.HeartBeat) bool {
+	heartBeatReceived.Inc()
+
+	p.lock.Lock()
+	if old, ok := p.pending[hb.Name]; ok {
+		// the node is queued already, only its newest heartbeat is processed
+		if newerHeartBeat(hb, old) {
+			p.pending[hb.Name] = hb
+		}
+		p.lock.Unlock()
+		heartBeatCoalesced.Inc()
+		return true
+	}
+	p.pending[hb.Name] = hb
+	p.lock.Unlock()
+
+	select {
+	case p.queue <- hb.Name:
+		heartBeatQueueDepth.Set(float64(len(p.queue)))
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(p.enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.queue <- hb.Name:
+		heartBeatQueueDepth.Set(float64(len(p.queue)))
+		return true
+	case <-timer.C:
+		klog.Warningf("Heartbeat queue is full, drop heartbeat of %s", hb.Name)
+	case <-p.stopped:
+		klog.Warningf("Heartbeat pipeline is stopped, drop heartbeat of %s", hb.Name)
+	}
+
+	// the newer heartbeats coalesced into this one are dropped with it, a pending entry is never left without a queue entry
+	p.lock.Lock()
+	delete(p.pending, hb.Name)
+	p.lock.Unlock()
+	heartBeatDropped.Inc()
+	return false
+}
+
+// Len returns the number of nodes waiting to be processed.
+func (p *HeartBeatPipeline) Len() int {
+	return len(p.queue)
+}
+
+// Run starts the workers, and blocks until stop.
+func (p *HeartBeatPipeline) Run(stop <-chan struct{}) {
+	klog.Infof("Starting heartbeat pipeline with %d workers, queue size %d", p.workers, cap(p.queue))
+	for i := 0; i < p.workers; i++ {
+		go wait.Until(func() {
+			p.work(stop)
+		}, time.Second, stop)
+	}
+	<-stop
+	close(p.stopped)
+	klog.Warningf("Stopping heartbeat pipeline")
+}
+
+func (p *HeartBeatPipeline) work(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case name := <-p.queue:
+			heartBeatQueueDepth.Set(float64(len(p.queue)))
+			// the node is removed from pending before it is processed, so a newer heartbeat is queued again
+			p.lock.Lock()
+			hb := p.pending[name]
+			delete(p.pending, name)
+			p.lock.Unlock()
+			if hb == nil {
+				continue
+			}
+			p.process(hb)
+			heartBeatProcessed.Inc()
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestHeartBeatPipeline(t *testing.T) {
+	var lock sync.Mutex
+	processed := make(map[string][]uint64)
+	done := make(chan struct{}, 10)
+	p := NewHeartBeatPipeline(2, 1, 0, func(hb *data.HeartBeat) {
+		lock.Lock()
+		processed[hb.Name] = append(processed[hb.Name], hb.SeqNum)
+		lock.Unlock()
+		done <- struct{}{}
+	})
+
+	// the pending heartbeats of a node are coalesced to the newest one
+	for _, seq := range []uint64{1, 3, 2} {
+		if !p.Enqueue(&data.HeartBeat{Name: "a", SeqNum: seq}) {
+			t.Errorf("heartbeat %d of a is dropped", seq)
+		}
+	}
+	if !p.Enqueue(&data.HeartBeat{Name: "b", SeqNum: 1}) {
+		t.Errorf("heartbeat of b is dropped")
+	}
+	if p.Len() != 2 {
+		t.Errorf("expect 2 queued nodes, get %d", p.Len())
+	}
+	// the queue is full
+	if p.Enqueue(&data.HeartBeat{Name: "c", SeqNum: 1}) {
+		t.Errorf("expect heartbeat of c to be dropped")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.Run(stop)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("heartbeats are not processed")
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(processed["a"]) != 1 || processed["a"][0] != 3 {
+		t.Errorf("expect only heartbeat 3 of a to be processed, get %v", processed["a"])
+	}
+	if len(processed["b"]) != 1 {
+		t.Errorf("expect heartbeat of b to be processed, get %v", processed["b"])
+	}
+	if len(processed["c"]) != 0 {
+		t.Errorf("expect heartbeat of c to be dropped, get %v", processed["c"])
+	}
+}
+
+func TestHeartBeatPipelineCoalescedOnTimeout(t *testing.T) {
+	processed := make(chan uint64, 10)
+	p := NewHeartBeatPipeline(1, 1, 50*time.Millisecond, func(hb *data.HeartBeat) {
+		if hb.Name == "b" {
+			processed <- hb.SeqNum
+		}
+	})
+	if !p.Enqueue(&data.HeartBeat{Name: "a", SeqNum: 1}) {
+		t.Fatalf("heartbeat of a is dropped")
+	}
+
+	// the queue is full, the first heartbeat of b waits for the queue
+	enqueued := make(chan bool, 1)
+	go func() {
+		enqueued <- p.Enqueue(&data.HeartBeat{Name: "b", SeqNum: 1})
+	}()
+	for {
+		p.lock.Lock()
+		_, ok := p.pending["b"]
+		p.lock.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the newer heartbeat is coalesced into the waiting one, and dropped with it when the queue stays full
+	if !p.Enqueue(&data.HeartBeat{Name: "b", SeqNum: 2}) {
+		t.Fatalf("heartbeat 2 of b is not coalesced")
+	}
+	select {
+	case ok := <-enqueued:
+		if ok {
+			t.Fatalf("expect the heartbeat of b to be dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("enqueue of b is blocked after the timeout")
+	}
+	p.lock.Lock()
+	_, ok := p.pending["b"]
+	p.lock.Unlock()
+	if ok {
+		t.Fatalf("expect no pending heartbeat of b left without a queue entry")
+	}
+
+	// the next heartbeat of b is processed
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.Run(stop)
+	if !p.Enqueue(&data.HeartBeat{Name: "b", SeqNum: 3}) {
+		t.Fatalf("heartbeat 3 of b is dropped")
+	}
+	select {
+	case seq := <-processed:
+		if seq != 3 {
+			t.Errorf("expect heartbeat 3 of b to be processed, get %d", seq)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("heartbeat of b is not processed")
+	}
+}
+
+func TestHeartBeatPipelineStopped(t *testing.T) {
+	p := NewHeartBeatPipeline(1, 1, time.Hour, func(hb *data.HeartBeat) {})
+	if !p.Enqueue(&data.HeartBeat{Name: "a", SeqNum: 1}) {
+		t.Fatalf("heartbeat of a is dropped")
+	}
+	enqueued := make(chan bool, 1)
+	go func() {
+		enqueued <- p.Enqueue(&data.HeartBeat{Name: "b", SeqNum: 1})
+	}()
+
+	// stopping the pipeline releases the receiver waiting for the full queue
+	stop := make(chan struct{})
+	close(stop)
+	p.Run(stop)
+	select {
+	case ok := <-enqueued:
+		if ok {
+			t.Errorf("expect the heartbeat of b to be dropped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("enqueue of b is blocked after the pipeline is stopped")
+	}
+}
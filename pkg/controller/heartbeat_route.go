@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+// HeartBeatRouteTopic returns the topic forwarding heartbeats to the instances of route.
+func HeartBeatRouteTopic(route string) string {
+	return filepath.Join(util.TopicHeartBeatRoutePrefix, route)
+}
+
+// RouteHeartBeat handles a heartbeat received from the shared subscription, which may land on any consumer of any instance.
+// The heartbeat is consumed at once if the instance is the only one owning the node, otherwise it is forwarded to the route
+// topic subscribed by all the instances owning the node, the shard of the node with sharding, and the leader and standbys
+// with leader election. So the state of a node is only merged by the instances owning it, and the heartbeats out of order
+// are dropped by NodeStore. The payload is forwarded as it is, so a signed heartbeat is verified by the instances consuming it.
+func (c *KoleController) RouteHeartBeat(payload []byte) {
+	name, err := heartBeatName(payload)
+	if err != nil {
+		klog.Errorf("UnmarshalPayloadToHeartBeat error %v", err)
+		return
+	}
+	route := c.HeartBeatRoute
+	if c.Shard != nil {
+		route = c.Shard.Ring().Owner(name)
+	}
+	if len(route) == 0 {
+		klog.Warningf("Drop heartbeat Name[%s], there is no shard", name)
+		return
+	}
+	if route == c.HeartBeatRoute && !c.ForwardAllHeartBeats {
+		hb, err := c.openHeartBeat(payload)
+		if err != nil {
+			klog.Errorf("Open heartbeat error %v", err)
+			return
+		}
+		c.HeartBeatPipeline.Enqueue(hb)
+		return
+	}
+
+	// the heartbeat is forwarded by the consumer, which slows down the delivery of the broker if forwarding falls behind
+	topic := HeartBeatRouteTopic(route)
+	if err := c.MessageHandler.PublishData(context.Background(), topic, 0, false, json.RawMessage(payload)); err != nil {
+		klog.Errorf("Forward heartbeat of %s to %s error %v", name, topic, err)
+	}
+}
@@ -17,12 +17,17 @@ package controller
 
 import (
 	"fmt"
+	"io/ioutil"
 	"sync"
 	"time"
 
+	"github.com/eclipse/paho.golang/autopaho"
 	outmqtt "github.com/eclipse/paho.mqtt.golang"
+	corev1 "k8s.io/api/core/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/flowcontrol"
@@ -36,6 +41,8 @@ import (
 	"github.com/openyurtio/kole/pkg/util"
 )
 
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
 // +kubebuilder:rbac:groups=lite.openyurt.io,resources=koledaemonsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=lite.openyurt.io,resources=koledaemonsets/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=lite.openyurt.io,resources=querynodes,verbs=get;list;watch;create;update;patch;delete
@@ -52,32 +59,94 @@ type SendMessage struct {
 type KoleController struct {
 	MessageHandler message.MessageHandler
 
-	ObserverdPodsCache   *ObserverdPodsCache
-	DesiredPodsCache     *DesiredPodsCache
 	QueryNodeStatusCache *QueryNodeStatusCache
 
 	KoleDaemonSetController *KoleDaemonSetController
 	KoleQueryController     *KoleQueryController
 
-	// key nodename
-	HeartBeatCache *HeartBeatCache
+	// the heartbeats, observed pods and pod overrides of all the nodes
+	Nodes *NodeStore
+	// the desired pods of the KoleDaemonSets shared by all the nodes
+	PodTemplates *PodTemplates
+	// the numbers of the pods of the KoleDaemonSets, updated with every change of Nodes
+	DaemonSetCounters *DaemonSetCounters
 
 	HeartBeatTimeOut int64
 
-	HeartBeatFilter *HeartBeatFilter
-
-	DataProcess       DataProcesser
-	SnapshotInterval  int
-	SummaryNS         string
-	SnapdSummaryNames []string
+	DataProcess DataProcesser
+	// the name of the codec in DataProcess, it is recorded in every snapshot
+	SnapshotCodec string
+	SnapshotStore *SnapshotStore
+	// creates the processer of the snapshots compressed by a codec
+	SnapshotProcesser func(codec string) (DataProcesser, error)
+	// refreshed before every snapshot, nil if the snapshot is not encrypted
+	SnapshotKeyring  *SecretKeyring
+	SnapshotInterval int
+	SummaryNS        string
+	// the number of snapshot generations to keep
+	SnapshotHistory int
+	// summary names of the saved snapshot generations, from the oldest to the latest
+	SnapedGenerations [][]string
 	LiteClient        versioned.Interface
 	LasterSnapIndex   int64
 	LasterSnapTime    int64
 	FirstSnapTime     int64
 	ReceiveNum        int64
+
+	// the shard of the controller, nil if the controller is not sharded
+	Shard *ShardManager
+
+	// the group of the shared heartbeat subscription, heartbeats are subscribed directly if it is empty
+	HeartBeatShareGroup string
+	// the route of the instance, the heartbeats of its nodes are forwarded to HeartBeatRouteTopic(HeartBeatRoute)
+	HeartBeatRoute string
+	// forward the heartbeats of the owned nodes too, since the standbys need them
+	ForwardAllHeartBeats bool
+	// the clients consuming the shared heartbeat subscription
+	HeartBeatConsumers []*autopaho.ConnectionManager
+	// queues the received heartbeats, which are processed by a bounded number of workers
+	HeartBeatPipeline *HeartBeatPipeline
+	// delivers the acks and pods to the nodes
+	Dispatcher *Dispatcher
+	// diffs and pushes the pods of the nodes enqueued by heartbeats and KoleDaemonSet changes
+	NodeReconciler *NodeReconciler
+	// marks the registered nodes offline once their heartbeats time out
+	OfflineDetector *OfflineDetector
+	// removes the nodes offline longer than the gc period, nil if the offline nodes are kept forever
+	NodeGC *OfflineDetector
+	// the latest state transitions of the nodes
+	NodeHistory *NodeHistory
+	// limits the rate of the registrations, nil if they are not limited
+	RegistrationAdmitter *RegistrationAdmitter
+	// approves the registering nodes, nil if all the nodes are approved
+	RegistrationApprover *RegistrationApprover
+	// verifies the heartbeats of the nodes, nil if the messages are not signed
+	MessageVerifier *data.MessageVerifier
+	// the keys signing the messages refreshed periodically, nil if the messages are not signed
+	SigningKeyring *SecretKeyring
+	// issues the client certificates of the nodes, nil if the certificates are not issued
+	CertificateIssuer *CertificateIssuer
+	// resolves the secrets of the pods sealed to the nodes, nil if the pods can not refer to secrets
+	PodSecrets *PodSecrets
+	// receive the state transitions of the nodes
+	transitionHandlers []NodeTransitionHandler
+
+	// 1 if the controller is the leader
+	leading int32
+	// notifies the snapshot loop when the controller becomes the leader
+	tookOver chan struct{}
+}
+
+// NewSnapshotProcesser returns the processer of the snapshots compressed by codec, which are encrypted if keyring is not nil.
+// The snapshots not encrypted are only read if allowPlain is true.
+func NewSnapshotProcesser(codec Codec, keyring *Keyring, allowPlain bool) DataProcesser {
+	if keyring == nil {
+		return codec
+	}
+	return ProcessChain{codec, &AesGcm{Keyring: keyring, AllowPlain: allowPlain}}
 }
 
-func NewMainKoleController(stop chan struct{}, config *options.KoleControllerFlags, processer DataProcesser) (*KoleController, error) {
+func NewMainKoleController(stop chan struct{}, config *options.KoleControllerFlags) (*KoleController, error) {
 
 	c, err := clientcmd.BuildConfigFromFlags("", config.KubeConfig)
 	if err != nil {
@@ -92,7 +161,73 @@ func NewMainKoleController(stop chan struct{}, config *options.KoleControllerFla
 		return nil, err
 	}
 
-	heartBeatCache, heartBeatFilter, snapedName, observerdPods, nodeStatus, err := LoadSnapShot(crdclient, config, processer)
+	kubeclient, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring *SecretKeyring
+	var ring *Keyring
+	if len(config.SnapshotEncryptionSecret) != 0 {
+		keyring, err = NewSecretKeyring(kubeclient, config.NameSpace, config.SnapshotEncryptionSecret)
+		if err != nil {
+			return nil, err
+		}
+		ring = keyring.Keyring
+		klog.Infof("Snapshot is encrypted with keys in secret %s/%s", config.NameSpace, config.SnapshotEncryptionSecret)
+	}
+
+	var dictionary []byte
+	if len(config.SnapshotCodecDictionary) != 0 {
+		if dictionary, err = ioutil.ReadFile(config.SnapshotCodecDictionary); err != nil {
+			klog.Errorf("Read snapshot codec dictionary %s error %v", config.SnapshotCodecDictionary, err)
+			return nil, err
+		}
+	}
+	codec, err := NewCodec(config.SnapshotCodec, CodecOptions{
+		Level:      config.SnapshotCodecLevel,
+		Dictionary: dictionary,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.SnapshotCodecLevel != nil {
+		klog.Infof("Snapshot is compressed by codec %s with level %d", codec.Name(), *config.SnapshotCodecLevel)
+	} else {
+		klog.Infof("Snapshot is compressed by codec %s with the default level", codec.Name())
+	}
+
+	metadataClient, err := metadata.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	snapshotStore := &SnapshotStore{
+		Client:    crdclient,
+		Metadata:  metadataClient,
+		Namespace: config.NameSpace,
+	}
+
+	var shard *ShardManager
+	if config.EnableSharding {
+		shard = NewShardManager(kubeclient, config.NameSpace, config.ShardName, time.Second*time.Duration(config.ShardLeaseDuration))
+		// the standbys of the shard renew the same lease, it is kept for them
+		shard.ReleaseOnStop = !config.EnableLeaderElection
+		if err := shard.Join(); err != nil {
+			return nil, err
+		}
+		snapshotStore.Shard = shard.Name()
+		klog.Infof("Join as shard %s, current shards %v", shard.Name(), shard.Members())
+	}
+
+	newProcesser := func(name string) (DataProcesser, error) {
+		// the level is only used to compress
+		codec, err := NewCodec(name, CodecOptions{Dictionary: dictionary})
+		if err != nil {
+			return nil, err
+		}
+		return NewSnapshotProcesser(codec, ring, config.AllowUnencryptedSnapshots), nil
+	}
+	nodes, snapedGenerations, nextSnapIndex, nodeStatus, err := LoadSnapShot(snapshotStore, config, newProcesser)
 	if err != nil {
 		return nil, err
 	}
@@ -101,30 +236,123 @@ func NewMainKoleController(stop chan struct{}, config *options.KoleControllerFla
 		SummaryNS:         config.NameSpace,
 		HeartBeatTimeOut:  int64(config.HBTimeOut),
 		LiteClient:        crdclient,
-		DataProcess:       processer,
+		DataProcess:       NewSnapshotProcesser(codec, ring, false),
+		SnapshotCodec:     codec.Name(),
+		SnapshotStore:     snapshotStore,
+		SnapshotProcesser: newProcesser,
+		SnapshotKeyring:   keyring,
 		SnapshotInterval:  config.SnapshotInterval,
-		SnapdSummaryNames: snapedName,
+		SnapshotHistory:   config.SnapshotHistory,
+		SnapedGenerations: snapedGenerations,
+		LasterSnapIndex:   nextSnapIndex,
+		Shard:             shard,
+		tookOver:          make(chan struct{}, 1),
 
-		HeartBeatCache: &HeartBeatCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   heartBeatCache,
-		},
-		HeartBeatFilter: &HeartBeatFilter{
-			Mutex:  &sync.Mutex{},
-			Filter: heartBeatFilter,
-		},
+		HeartBeatShareGroup:  config.Mqtt5Flags.ShareGroup,
+		HeartBeatRoute:       config.LeaderElectionID,
+		ForwardAllHeartBeats: config.EnableLeaderElection,
+
+		Nodes:             nodes,
+		PodTemplates:      NewPodTemplates(),
+		DaemonSetCounters: NewDaemonSetCounters(),
 
-		ObserverdPodsCache: &ObserverdPodsCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   observerdPods,
-		},
 		QueryNodeStatusCache: &QueryNodeStatusCache{
 			RWMutex:      &sync.RWMutex{},
 			NameToStatus: nodeStatus,
 		},
-		DesiredPodsCache: &DesiredPodsCache{
-			RWMutex: &sync.RWMutex{},
-			Cache:   make(map[string]map[string]*data.Pod)},
+	}
+
+	var signer *data.MessageSigner
+	if len(config.MessageSignature) != 0 {
+		koleInstance.SigningKeyring, err = NewSecretKeyring(kubeclient, config.NameSpace, config.MessageSigningSecret)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := koleInstance.NewMessageKeys(config.MessageSignature, koleInstance.SigningKeyring.Keyring)
+		if err != nil {
+			return nil, err
+		}
+		signer = NewControllerMessageSigner(keys)
+		koleInstance.MessageVerifier = data.NewMessageVerifier(keys, config.AllowUnsignedMessages)
+		klog.Infof("Messages are signed by %s with keys in secret %s/%s, unsigned heartbeats are allowed %v",
+			config.MessageSignature, config.NameSpace, config.MessageSigningSecret, config.AllowUnsignedMessages)
+	}
+
+	if len(config.ClientCASecret) != 0 {
+		koleInstance.CertificateIssuer, err = NewCertificateIssuer(kubeclient, config.NameSpace, config.ClientCASecret,
+			time.Duration(config.ClientCertificateDuration)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		klog.Infof("Client certificates of the nodes are issued by CA in secret %s/%s for %d s", config.NameSpace, config.ClientCASecret,
+			config.ClientCertificateDuration)
+	}
+
+	koleInstance.Dispatcher = NewDispatcher(DispatcherOptions{
+		Workers:    config.DispatcherWorkers,
+		MaxRetries: config.DispatcherMaxRetries,
+		QPS:        config.DispatcherQPS,
+		Burst:      config.DispatcherBurst,
+		NodeQPS:    config.DispatcherNodeQPS,
+		NodeBurst:  config.DispatcherNodeBurst,
+		Signer:     signer,
+	})
+	koleInstance.NodeReconciler = NewNodeReconciler(koleInstance)
+	koleInstance.HeartBeatPipeline = NewHeartBeatPipeline(config.HeartBeatQueueSize, config.HeartBeatWorkers,
+		time.Duration(config.HeartBeatEnqueueTimeout)*time.Millisecond, koleInstance.ConsumeHeartBeatDirect)
+
+	koleInstance.OfflineDetector = NewOfflineDetector(time.Duration(config.HBTimeOut)*time.Second, koleInstance.markOffline)
+	if config.NodeGCPeriod > 0 {
+		koleInstance.NodeGC = NewOfflineDetector(time.Duration(config.NodeGCPeriod)*time.Second, koleInstance.collectNode)
+	}
+	if config.RegistrationQPS > 0 {
+		koleInstance.RegistrationAdmitter = NewRegistrationAdmitter(config.RegistrationQPS, config.RegistrationBurst)
+	}
+	if config.EnableRegistrationApproval {
+		var tokens *BootstrapTokens
+		if config.ApproveBootstrapTokens {
+			tokenFactory := NewBootstrapTokenInformerFactory(kubeclient, config.NameSpace)
+			secretInformer := tokenFactory.Core().V1().Secrets()
+			tokens = NewBootstrapTokens(secretInformer.Lister().Secrets(config.NameSpace))
+			go tokenFactory.Start(stop)
+			if !cache.WaitForCacheSync(stop, secretInformer.Informer().HasSynced) {
+				return nil, fmt.Errorf("timed out waiting for bootstrap token caches to sync")
+			}
+		}
+		koleInstance.RegistrationApprover, err = NewRegistrationApprover(tokens, config.ApproveLabelSelector, config.ApproveCIDRs)
+		if err != nil {
+			return nil, err
+		}
+		klog.Infof("Registrations are approved by bootstrap tokens %v, label selector %q and address ranges %v",
+			config.ApproveBootstrapTokens, config.ApproveLabelSelector, config.ApproveCIDRs)
+	}
+	koleInstance.NodeHistory = NewNodeHistory(time.Duration(config.FlapWindow)*time.Second, config.FlapThreshold)
+	koleInstance.AddTransitionHandler(countTransition)
+	koleInstance.AddTransitionHandler(koleInstance.NodeHistory.Record)
+	koleInstance.AddTransitionHandler(koleInstance.queryStatusTransition)
+
+	for _, node := range nodes.Snapshot() {
+		koleInstance.onNodeChange(nil, node)
+	}
+	nodes.OnChange = koleInstance.onNodeChange
+
+	if shard != nil {
+		// the instances of a shard share the route of the shard
+		koleInstance.HeartBeatRoute = shard.Name()
+	}
+
+	var podSecretInformer cache.SharedIndexInformer
+	if config.EnablePodSecrets {
+		secretFactory := NewPodSecretInformerFactory(kubeclient)
+		secretInformer := secretFactory.Core().V1().Secrets()
+		podSecretInformer = secretInformer.Informer()
+		koleInstance.PodSecrets = NewPodSecrets(secretInformer.Lister())
+		go secretFactory.Start(stop)
+		// the hashes of the pod templates cover their secrets
+		if !cache.WaitForCacheSync(stop, podSecretInformer.HasSynced) {
+			return nil, fmt.Errorf("timed out waiting for pod secret caches to sync")
+		}
+		klog.Infof("Pods can refer to the secrets labeled with %s=true", PodSecretLabel)
 	}
 
 	factory := externalversions.NewSharedInformerFactory(crdclient, time.Second*70)
@@ -150,30 +378,112 @@ func NewMainKoleController(stop chan struct{}, config *options.KoleControllerFla
 	koleInstance.KoleDaemonSetController = koleDScontroller
 	koleInstance.KoleQueryController = koleQueryController
 
-	for nodeName, _ := range heartBeatCache {
-		koleDScontroller.AddHost(nodeName)
+	if err := koleDScontroller.SyncTemplates(); err != nil {
+		return nil, err
+	}
+	if podSecretInformer != nil {
+		podSecretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				koleDScontroller.SecretChanged(obj.(*corev1.Secret))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				koleDScontroller.SecretChanged(newObj.(*corev1.Secret))
+			},
+			DeleteFunc: func(obj interface{}) {
+				if secret, ok := obj.(*corev1.Secret); ok {
+					koleDScontroller.SecretChanged(secret)
+				} else if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					if secret, ok := tombstone.Obj.(*corev1.Secret); ok {
+						koleDScontroller.SecretChanged(secret)
+					}
+				}
+			},
+		})
+	}
+	for _, node := range nodes.Snapshot() {
+		if node.HeartBeat.State != data.HeartBeatPending {
+			koleDScontroller.AddHost(node.Name)
+		}
+	}
+	// every instance connects with its own client id
+	mqtt3ClientName, mqtt5ClientName := "kole-controller", "controller-mqtt-v5"
+	if config.EnableSharding || config.EnableLeaderElection {
+		mqtt3ClientName += "-" + config.Identity
+		mqtt5ClientName += "-" + config.Identity
+	}
+	auth, err := message.NewAuthProvider(config.MqttAuth, config.Mqtt3Flags.MqttInstance)
+	if err != nil {
+		klog.Errorf("New mqtt auth %s error %v", config.MqttAuth.Provider, err)
+		return nil, err
 	}
 	if !config.IsMqtt5 {
-		h, err := message.NewMqtt3Handler(config.Mqtt3Flags.MqttBroker, config.Mqtt3Flags.MqttBrokerPort, config.Mqtt3Flags.MqttInstance, config.Mqtt3Flags.MqttGroup,
-			"kole-controller",
+		h, err := message.NewMqtt3Handler(config.Mqtt3Flags.MqttBroker, config.Mqtt3Flags.MqttBrokerPort, config.Mqtt3Flags.MqttGroup,
+			mqtt3ClientName,
 			map[string]outmqtt.MessageHandler{
 				util.TopicHeartBeat: koleInstance.Mqtt3SubEdgeHeartBeat,
-			})
+				util.TopicWill:      koleInstance.Mqtt3SubNodeWill,
+
+				util.TopicCertificateRequest: koleInstance.Mqtt3SubCertificateRequest,
+			}, nil, config.MqttTLS, auth)
 		if err != nil {
 			return nil, err
 		}
 		koleInstance.MessageHandler = h
 	} else {
 		// mqtt 5
-		h, err := message.NewMqtt5Handler(config.Mqtt5Flags.MqttServer, koleInstance.Mqtt5CreateSubscribes(), "controller-mqtt-v5", false)
+		h, err := message.NewMqtt5Handler(config.Mqtt5Flags.MqttServer, koleInstance.Mqtt5CreateSubscribes(), mqtt5ClientName, false, nil, config.MqttTLS, auth)
 		if err != nil {
 			return nil, err
 		}
 		koleInstance.MessageHandler = h
+
+		if len(koleInstance.HeartBeatShareGroup) != 0 {
+			consumers, err := message.NewMqtt5Consumers(config.Mqtt5Flags.MqttServer, koleInstance.Mqtt5CreateSharedSubscribes(), mqtt5ClientName,
+				config.Mqtt5Flags.HeartBeatConsumers, config.MqttTLS, auth)
+			if err != nil {
+				return nil, err
+			}
+			koleInstance.HeartBeatConsumers = consumers
+			klog.Infof("Consume heartbeats by %d clients in share group %s, route %s", len(consumers), koleInstance.HeartBeatShareGroup,
+				koleInstance.HeartBeatRoute)
+		}
 	}
 
 	klog.V(4).Infof("Create kole cloud mqtt client successfully")
 
+	// the heartbeats received and the messages sent before are queued, and processed once the message handler is ready
+	go koleInstance.HeartBeatPipeline.Run(stop)
+	go koleInstance.Dispatcher.Run(koleInstance.MessageHandler, stop)
+	go koleInstance.NodeReconciler.Run(config.NodeReconcileWorkers, stop)
+	go koleInstance.OfflineDetector.Run(stop)
+	go koleInstance.NodeHistory.Run(stop)
+	if koleInstance.NodeGC != nil {
+		go koleInstance.NodeGC.Run(stop)
+	}
+	if koleInstance.SigningKeyring != nil {
+		go wait.Until(koleInstance.refreshSigningKeys, signingKeysRefreshPeriod, stop)
+	}
+	if koleInstance.CertificateIssuer != nil {
+		go wait.Until(koleInstance.refreshCertificateIssuer, signingKeysRefreshPeriod, stop)
+	}
+
+	if shard != nil {
+		// the first rebalance is triggered by the shards loaded by Join
+		shard.AddHandler(koleInstance.Rebalance)
+		go shard.Run(stop)
+	}
+
+	if config.EnableLeaderElection {
+		elector, err := koleInstance.newLeaderElector(kubeclient, config)
+		if err != nil {
+			return nil, err
+		}
+		klog.Infof("Start as a standby of %s", config.Identity)
+		go koleInstance.runLeaderElection(stop, elector)
+	} else {
+		koleInstance.leading = 1
+	}
+
 	return koleInstance, nil
 }
 
@@ -1,54 +0,0 @@
-/*
-Copyright 2022 The OpenYurt Authors.
-
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    http://www.apache.org/licenses/LICENSE-2.0
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-package controller
-
-import (
-	"sync"
-	"time"
-
-	"github.com/openyurtio/kole/pkg/data"
-)
-
-type HeartBeatCache struct {
-	*sync.RWMutex
-	Cache map[string]*data.HeartBeat
-}
-
-func (c *HeartBeatCache) SafeReadOperate(f func()) {
-	c.RLock()
-	f()
-	c.RUnlock()
-}
-
-func (c *HeartBeatCache) ReceiveHeartBeat(hb *data.HeartBeat, daemonSetCtl *KoleDaemonSetController) *data.HeartBeatACK {
-	n := time.Now().Unix()
-	var ack *data.HeartBeatACK
-
-	c.Lock()
-
-	if hb.State == data.HeartBeatRegistering {
-		//hb.State = data.HeartBeatRegisterd
-		daemonSetCtl.AddHost(hb.Name)
-	}
-
-	hb.LasterTimeStamp = n
-	c.Cache[hb.Name] = hb
-	// Unlock
-	c.Unlock()
-
-	return ack
-}
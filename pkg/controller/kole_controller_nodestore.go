@@ -0,0 +1,299 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// nodeStoreShards is the number of lock shards of NodeStore.
+const nodeStoreShards = 256
+
+type FilterInfo struct {
+	SeqNum    uint64
+	TimeStamp int64
+}
+
+// NodeState is all the cached state of a node.
+// It is copy on write, a state in the store is never modified, an update replaces it with a modified copy,
+// so a state can be read without any lock once it is got from the store.
+type NodeState struct {
+	Name string
+	// the latest accepted heartbeat
+	HeartBeat *data.HeartBeat
+	// why the state of the heartbeat is set, one of the TransitionReasons
+	Reason string
+	// the seq and timestamp of the latest accepted heartbeat, the older heartbeats are dropped
+	Filter FilterInfo
+	// pod key / the pod reported by heartbeats
+	ObservedPods map[string]*data.HeartBeatPod
+	// the pod templates are desired on the node, false if the node is not added to the KoleDaemonSets yet
+	Desired bool
+	// pod key / the pod replacing the template on the node, or nil to exclude the template from the node.
+	// It is nil for most of the nodes, which share the templates as they are.
+	Overrides map[string]*data.Pod
+}
+
+// Copy returns a shallow copy of s, the maps must be copied before they are modified.
+func (s *NodeState) Copy() *NodeState {
+	n := *s
+	return &n
+}
+
+// DesiredPod returns the pod of key desired on the node, nil if it is not desired.
+func (s *NodeState) DesiredPod(templates map[string]*data.Pod, key string) *data.Pod {
+	if !s.Desired {
+		return nil
+	}
+	if p, ok := s.Overrides[key]; ok {
+		return p
+	}
+	return templates[key]
+}
+
+// DesiredPods returns the pods desired on the node indexed by pod key, nil if the node is not added yet.
+// templates is returned as it is if there is no override, the returned map must not be modified.
+func (s *NodeState) DesiredPods(templates map[string]*data.Pod) map[string]*data.Pod {
+	if !s.Desired {
+		return nil
+	}
+	if len(s.Overrides) == 0 {
+		return templates
+	}
+	pods := make(map[string]*data.Pod, len(templates)+len(s.Overrides))
+	for key, p := range templates {
+		pods[key] = p
+	}
+	for key, p := range s.Overrides {
+		if p == nil {
+			delete(pods, key)
+		} else {
+			pods[key] = p
+		}
+	}
+	return pods
+}
+
+// WithOverride returns a copy of s with the override of key, it is removed if remove is true.
+func (s *NodeState) WithOverride(key string, pod *data.Pod, remove bool) *NodeState {
+	n := s.Copy()
+	n.Overrides = make(map[string]*data.Pod, len(s.Overrides)+1)
+	for k, p := range s.Overrides {
+		n.Overrides[k] = p
+	}
+	if remove {
+		delete(n.Overrides, key)
+	} else {
+		n.Overrides[key] = pod
+	}
+	if len(n.Overrides) == 0 {
+		n.Overrides = nil
+	}
+	return n
+}
+
+// Accepts returns true if hb is newer than the accepted heartbeats.
+func (s *NodeState) Accepts(hb *data.HeartBeat) bool {
+	if hb.SeqNum < s.Filter.SeqNum {
+		klog.Warningf("Received heartbeat from %s, seq %v is less then cached seq %v, skip", hb.Name, hb.SeqNum, s.Filter.SeqNum)
+		return false
+	}
+	if hb.SeqNum == s.Filter.SeqNum && hb.TimeStamp <= s.Filter.TimeStamp {
+		klog.V(4).Infof("Received heatbeat from %s, seq %v is equal to the cached seq, but the timestamp[%v] older than the cached value [%v], skip", hb.Name, hb.SeqNum,
+			hb.TimeStamp, s.Filter.TimeStamp)
+		return false
+	}
+	return true
+}
+
+// WithState returns a copy of s with the state of its heartbeat replaced for reason, the heartbeat is copied as well.
+func (s *NodeState) WithState(state, reason string) *NodeState {
+	hb := *s.HeartBeat
+	hb.State = state
+	n := s.Copy()
+	n.HeartBeat = &hb
+	n.Reason = reason
+	return n
+}
+
+// NewNodeStateFromHeartBeat creates the state of the node sending hb.
+func NewNodeStateFromHeartBeat(hb *data.HeartBeat) *NodeState {
+	s := &NodeState{
+		Name:   hb.Name,
+		Reason: TransitionReasonHeartBeat,
+	}
+	s.setHeartBeat(hb)
+	return s
+}
+
+// WithHeartBeat returns a copy of s with hb accepted.
+func (s *NodeState) WithHeartBeat(hb *data.HeartBeat) *NodeState {
+	n := s.Copy()
+	n.Reason = TransitionReasonHeartBeat
+	if hb.SeqNum > s.Filter.SeqNum {
+		// the SeqNum is increased every time the node restarts
+		n.Reason = TransitionReasonRestarted
+	}
+	n.setHeartBeat(hb)
+	return n
+}
+
+func (s *NodeState) setHeartBeat(hb *data.HeartBeat) {
+	if s.HeartBeat != nil {
+		// the unsigned heartbeats keep the pinned key
+		if len(hb.PublicKey) == 0 {
+			hb.PublicKey = s.HeartBeat.PublicKey
+		}
+		// the encryption key is the identity key of the node, it is pinned by the first registration
+		if len(hb.EncryptionKey) == 0 || len(s.HeartBeat.EncryptionKey) != 0 {
+			hb.EncryptionKey = s.HeartBeat.EncryptionKey
+		}
+	}
+	s.HeartBeat = hb
+	s.Filter = FilterInfo{
+		SeqNum:    hb.SeqNum,
+		TimeStamp: hb.TimeStamp,
+	}
+	// a heartbeat reports all the pods on the node, the pods missing from it are gone
+	s.ObservedPods = make(map[string]*data.HeartBeatPod, len(hb.Pods))
+	for _, hbp := range hb.Pods {
+		s.ObservedPods[hbp.Key()] = &data.HeartBeatPod{
+			Hash:      hbp.Hash,
+			Name:      hbp.Name,
+			NameSpace: hbp.NameSpace,
+			Status:    hbp.Status,
+		}
+	}
+}
+
+type nodeStoreShard struct {
+	sync.RWMutex
+	nodes map[string]*NodeState
+}
+
+// NodeStore holds the states of all the nodes. The nodes are spread across shards with their own locks,
+// so the heartbeats of different nodes are merged in parallel, and the updates of a node are serialized.
+type NodeStore struct {
+	shards [nodeStoreShards]nodeStoreShard
+	// OnChange is called with the lock of the node held when the state of a node is replaced,
+	// old is nil for a new node and new is nil for a removed node. It must be set before the store is used.
+	OnChange func(old, new *NodeState)
+}
+
+// NewNodeStore creates an empty store.
+func NewNodeStore() *NodeStore {
+	s := &NodeStore{}
+	for i := range s.shards {
+		s.shards[i].nodes = make(map[string]*NodeState)
+	}
+	return s
+}
+
+// nodeShard returns the shard index of node name.
+func nodeShard(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32() % nodeStoreShards
+}
+
+func (s *NodeStore) shard(name string) *nodeStoreShard {
+	return &s.shards[nodeShard(name)]
+}
+
+// Get returns the state of node name, nil if it is not found.
+func (s *NodeStore) Get(name string) *NodeState {
+	shard := s.shard(name)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.nodes[name]
+}
+
+// Has returns true if node name is in the store.
+func (s *NodeStore) Has(name string) bool {
+	return s.Get(name) != nil
+}
+
+func (s *NodeStore) set(shard *nodeStoreShard, name string, old, n *NodeState) {
+	if n == old {
+		return
+	}
+	if n != nil {
+		shard.nodes[name] = n
+	} else {
+		delete(shard.nodes, name)
+	}
+	if s.OnChange != nil {
+		s.OnChange(old, n)
+	}
+}
+
+// Update replaces the state of node name with the one returned by f, which is called with the lock of the node held.
+// The old state is nil if the node is not found, and the node is removed if f returns nil.
+// f must not modify the old state, and must not call the methods of the store.
+func (s *NodeStore) Update(name string, f func(old *NodeState) *NodeState) {
+	shard := s.shard(name)
+	shard.Lock()
+	defer shard.Unlock()
+	old := shard.nodes[name]
+	s.set(shard, name, old, f(old))
+}
+
+// Remove drops the states of names.
+func (s *NodeStore) Remove(names []string) {
+	for _, name := range names {
+		shard := s.shard(name)
+		shard.Lock()
+		if old, ok := shard.nodes[name]; ok {
+			s.set(shard, name, old, nil)
+		}
+		shard.Unlock()
+	}
+}
+
+// Len returns the number of nodes.
+func (s *NodeStore) Len() int {
+	var l int
+	for i := range s.shards {
+		s.shards[i].RLock()
+		l += len(s.shards[i].nodes)
+		s.shards[i].RUnlock()
+	}
+	return l
+}
+
+// Snapshot returns the states of all the nodes at a point in time.
+// All the shards are locked only while the pointers of the states are copied,
+// and the states are never modified, so they can be processed as long as needed without blocking ingestion.
+func (s *NodeStore) Snapshot() []*NodeState {
+	var l int
+	for i := range s.shards {
+		s.shards[i].RLock()
+		l += len(s.shards[i].nodes)
+	}
+	states := make([]*NodeState, 0, l)
+	for i := range s.shards {
+		for _, n := range s.shards[i].nodes {
+			states = append(states, n)
+		}
+		s.shards[i].RUnlock()
+	}
+	return states
+}
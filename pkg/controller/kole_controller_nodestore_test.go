@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestNodeStore(t *testing.T) {
+	s := NewNodeStore()
+	hb := &data.HeartBeat{
+		Name:      "node",
+		SeqNum:    2,
+		TimeStamp: 10,
+		Pods:      []*data.HeartBeatPod{{Name: "a", NameSpace: "ns", Hash: "1"}},
+	}
+	s.Update(hb.Name, func(old *NodeState) *NodeState {
+		return NewNodeStateFromHeartBeat(hb)
+	})
+	view := s.Snapshot()
+	if len(view) != 1 || s.Len() != 1 || !s.Has("node") {
+		t.Fatalf("expect 1 node, get %d", len(view))
+	}
+
+	for _, c := range []struct {
+		seq    uint64
+		ts     int64
+		accept bool
+	}{
+		{1, 20, false},
+		{2, 10, false},
+		{2, 11, true},
+		{3, 1, true},
+	} {
+		if accept := view[0].Accepts(&data.HeartBeat{Name: "node", SeqNum: c.seq, TimeStamp: c.ts}); accept != c.accept {
+			t.Errorf("heartbeat seq %d timestamp %d, expect accept %v, get %v", c.seq, c.ts, c.accept, accept)
+		}
+	}
+
+	newer := &data.HeartBeat{
+		Name:      "node",
+		SeqNum:    3,
+		TimeStamp: 1,
+		Pods:      []*data.HeartBeatPod{{Name: "b", NameSpace: "ns", Hash: "2"}},
+	}
+	s.Update(newer.Name, func(old *NodeState) *NodeState {
+		return old.WithHeartBeat(newer)
+	})
+
+	// the states got before are never modified
+	if view[0].HeartBeat != hb || len(view[0].ObservedPods) != 1 {
+		t.Errorf("the state in the snapshot is modified")
+	}
+	n := s.Get("node")
+	if n.HeartBeat != newer || n.Filter.SeqNum != 3 {
+		t.Errorf("unexpected state %+v", n)
+	}
+	// the pods are observed by the latest heartbeat only
+	if _, ok := n.ObservedPods["ns-b"]; !ok || len(n.ObservedPods) != 1 {
+		t.Errorf("expect only pod ns-b to be observed, get %v", n.ObservedPods)
+	}
+
+	s.Remove([]string{"node"})
+	if s.Has("node") || s.Len() != 0 {
+		t.Errorf("node is not removed")
+	}
+}
+
+func TestNodeState_DesiredPods(t *testing.T) {
+	templates := NewPodTemplates()
+	a, b := &data.Pod{Name: "a", Hash: "1"}, &data.Pod{Name: "b", Hash: "1"}
+	templates.Set("a", a)
+	templates.Set("b", b)
+	list := templates.List()
+
+	node := NewNodeStateFromHeartBeat(&data.HeartBeat{Name: "node"})
+	if node.DesiredPods(list) != nil {
+		t.Errorf("expect no desired pods before the node is added")
+	}
+	node.Desired = true
+	if pods := node.DesiredPods(list); len(pods) != 2 || pods["a"] != a {
+		t.Errorf("expect the shared templates, get %v", pods)
+	}
+
+	// replace a and exclude b on the node
+	a2 := &data.Pod{Name: "a", Hash: "2"}
+	overridden := node.WithOverride("a", a2, false).WithOverride("b", nil, false)
+	if pods := overridden.DesiredPods(list); len(pods) != 1 || pods["a"] != a2 {
+		t.Errorf("expect the overridden pods, get %v", pods)
+	}
+	if overridden.DesiredPod(list, "b") != nil || node.DesiredPod(list, "b") != b {
+		t.Errorf("the override is not applied to the copy only")
+	}
+	if restored := overridden.WithOverride("a", nil, true).WithOverride("b", nil, true); restored.Overrides != nil {
+		t.Errorf("expect no overrides, get %v", restored.Overrides)
+	}
+
+	// the templates listed before are never modified
+	templates.Set("a", a2)
+	templates.Delete("b")
+	if list["a"] != a || list["b"] != b || len(templates.List()) != 1 {
+		t.Errorf("the listed templates are modified")
+	}
+}
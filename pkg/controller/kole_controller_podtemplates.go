@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// PodTemplates holds the desired pods of all the KoleDaemonSets, which are shared by all the nodes.
+// The templates are never modified, a changed KoleDaemonSet replaces its template, and the map of the templates
+// is copied on write, so a map returned by List can be read without any lock.
+type PodTemplates struct {
+	sync.RWMutex
+	// pod key / pod
+	templates map[string]*data.Pod
+}
+
+// NewPodTemplates creates an empty PodTemplates.
+func NewPodTemplates() *PodTemplates {
+	return &PodTemplates{
+		templates: make(map[string]*data.Pod),
+	}
+}
+
+// NewPodTemplate creates the template of the pods of ds, the hash covers the Secrets of the pods if secrets is not nil.
+func NewPodTemplate(ds *v1alpha1.KoleDaemonSet, secrets *PodSecrets) (*data.Pod, error) {
+	hash, err := secrets.Hash(ds.Namespace, ds.Spec)
+	if err != nil {
+		return nil, err
+	}
+	return &data.Pod{
+		Hash:      hash,
+		Name:      generateKoleDaemonSetPodName(ds),
+		NameSpace: ds.Namespace,
+		Spec:      ds.Spec,
+	}, nil
+}
+
+// List returns the templates indexed by pod key, the map must not be modified.
+func (t *PodTemplates) List() map[string]*data.Pod {
+	t.RLock()
+	defer t.RUnlock()
+	return t.templates
+}
+
+// Get returns the template of key, nil if it is not found.
+func (t *PodTemplates) Get(key string) *data.Pod {
+	return t.List()[key]
+}
+
+// Set replaces the template of key with pod.
+func (t *PodTemplates) Set(key string, pod *data.Pod) {
+	t.Lock()
+	defer t.Unlock()
+	templates := make(map[string]*data.Pod, len(t.templates)+1)
+	for k, p := range t.templates {
+		templates[k] = p
+	}
+	templates[key] = pod
+	t.templates = templates
+}
+
+// Delete removes the template of key, and returns the removed template.
+func (t *PodTemplates) Delete(key string) *data.Pod {
+	t.Lock()
+	defer t.Unlock()
+	old, ok := t.templates[key]
+	if !ok {
+		return nil
+	}
+	templates := make(map[string]*data.Pod, len(t.templates))
+	for k, p := range t.templates {
+		if k != key {
+			templates[k] = p
+		}
+	}
+	t.templates = templates
+	return old
+}
@@ -39,3 +39,19 @@ func (c *QueryNodeStatusCache) GetNodeStatus(nodeName string) *v1alpha1.KoleQuer
 	c.RUnlock()
 	return s
 }
+
+// Set replaces the status of nodeName.
+func (c *QueryNodeStatusCache) Set(nodeName string, status *v1alpha1.KoleQueryStatus) {
+	c.Lock()
+	c.NameToStatus[nodeName] = status
+	c.Unlock()
+}
+
+// Remove drops the status of nodeNames.
+func (c *QueryNodeStatusCache) Remove(nodeNames []string) {
+	c.Lock()
+	for _, name := range nodeNames {
+		delete(c.NameToStatus, name)
+	}
+	c.Unlock()
+}
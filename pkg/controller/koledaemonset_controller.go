@@ -21,9 +21,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"path/filepath"
+	"reflect"
+	"sort"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -38,7 +40,6 @@ import (
 	externalV1alpha1 "github.com/openyurtio/kole/pkg/client/informers/externalversions/lite/v1alpha1"
 	listV1alpha1 "github.com/openyurtio/kole/pkg/client/listers/lite/v1alpha1"
 	"github.com/openyurtio/kole/pkg/data"
-	"github.com/openyurtio/kole/pkg/util"
 )
 
 const KoleDaemonSetHashKey = "openyurt.io.koledaemonset/podspec.hash"
@@ -90,53 +91,75 @@ func NewKoleDaemonSetController(client versioned.Interface, informer externalV1a
 	return dsc, nil
 }
 
+// SyncTemplates sets the pod templates of all the KoleDaemonSets in the lister,
+// so the templates are ready before the informer handlers are called.
+func (c *KoleDaemonSetController) SyncTemplates() error {
+	dss, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, ds := range dss {
+		template, err := NewPodTemplate(ds, c.koleCtl.PodSecrets)
+		if err != nil {
+			klog.Errorf("Generage pod spec hash error %v", err)
+			continue
+		}
+		c.koleCtl.PodTemplates.Set(generateKoleDaemonSetPodKey(ds), template)
+	}
+	return nil
+}
+
 func (c *KoleDaemonSetController) AddHost(hostName string) {
 	klog.V(4).Infof("Adding Host %s", hostName)
-	// topic/ pod
-	needPublish := make([]*data.Pod, 0, 10240)
 
-	ids, err := c.lister.List(labels.Everything())
+	var added *NodeState
+	c.koleCtl.Nodes.Update(hostName, func(old *NodeState) *NodeState {
+		if old == nil || old.Desired {
+			return old
+		}
+		added = old.Copy()
+		added.Desired = true
+		return added
+	})
+
+	if added != nil && added.HeartBeat != nil {
+		c.enqueueSelecting(added.HeartBeat.Labels)
+	}
+	c.koleCtl.NodeReconciler.Enqueue(hostName)
+}
+
+// enqueueSelecting enqueues the KoleDaemonSets selecting the node with nodeLabels, whose status counts the node.
+// The status of the others is caught up by the resync of the informer.
+func (c *KoleDaemonSetController) enqueueSelecting(nodeLabels map[string]string) {
+	dss, err := c.lister.List(labels.Everything())
 	if err != nil {
+		klog.Errorf("List KoleDaemonSets error %v", err)
 		return
 	}
+	for _, ds := range dss {
+		if ds.Spec == nil || labels.SelectorFromSet(ds.Spec.NodeSelector).Matches(labels.Set(nodeLabels)) {
+			c.enqueue(ds)
+		}
+	}
+}
 
-	c.koleCtl.DesiredPodsCache.SafeOperate(func() {
-		if _, ok := c.koleCtl.DesiredPodsCache.Cache[hostName]; !ok {
-			desiredPods := make(map[string]*data.Pod)
-			for _, ds := range ids {
-				// Todo add node selector
-				podKey := generateKoleDaemonSetPodKey(ds)
-				hash, err := Md5PodSpec(ds.Spec)
-				if err != nil {
-					klog.Errorf("Generage pod spec hash error %v", err)
-					continue
-				}
-				np := &data.Pod{
-					Hash:      hash,
-					Name:      generateKoleDaemonSetPodName(ds),
-					NameSpace: ds.Namespace,
-					Spec:      ds.Spec,
-				}
-				desiredPods[podKey] = np
-				needPublish = append(needPublish, np)
-			}
-			c.koleCtl.DesiredPodsCache.Cache[hostName] = desiredPods
+// SetPodOverride replaces the pod of key on node hostName with pod, or excludes the pod from the node if pod is nil.
+// The override is removed if remove is true, and the node desires the template again.
+func (c *KoleDaemonSetController) SetPodOverride(hostName, key string, pod *data.Pod, remove bool) {
+	var found bool
+	c.koleCtl.Nodes.Update(hostName, func(old *NodeState) *NodeState {
+		if old == nil {
+			return old
 		}
+		found = true
+		return old.WithOverride(key, pod, remove)
 	})
-
-	for _, ds := range ids {
-		c.enqueue(ds)
+	if !found {
+		return
 	}
 
-	go func() {
-		topic := filepath.Join(util.TopicDataPrefix, hostName)
-		for _, p := range needPublish {
-			if err := c.koleCtl.MessageHandler.PublishData(context.Background(), topic, 0, false, p); err != nil {
-				klog.Errorf("Mqtt5 publish error %v", err)
-				return
-			}
-		}
-	}()
+	c.EnqueueAll()
+	c.koleCtl.NodeReconciler.Enqueue(hostName)
 }
 
 func generateKoleDaemonSetPodKey(ds *v1alpha1.KoleDaemonSet) string {
@@ -148,45 +171,17 @@ func generateKoleDaemonSetPodName(ds *v1alpha1.KoleDaemonSet) string {
 }
 
 func (c *KoleDaemonSetController) addUpdateKoleDaemonSet(ds *v1alpha1.KoleDaemonSet) {
-
-	needPublish := make(map[string][]*data.Pod)
-
-	podKey := generateKoleDaemonSetPodKey(ds)
-	hash, err := Md5PodSpec(ds.Spec)
+	template, err := NewPodTemplate(ds, c.koleCtl.PodSecrets)
 	if err != nil {
 		klog.Errorf("Generage pod spec hash error %v", err)
 		return
 	}
 
-	c.koleCtl.DesiredPodsCache.WriteRange(func(nodeName string, desiredPodsMap map[string]*data.Pod) {
-		// Todo add node selector
-		newP := &data.Pod{
-			Hash:      hash,
-			Name:      generateKoleDaemonSetPodName(ds),
-			NameSpace: ds.Namespace,
-			Spec:      ds.Spec,
-		}
-		desiredPodsMap[podKey] = newP
-		topic := filepath.Join(util.TopicDataPrefix, nodeName)
-		if _, ok := needPublish[topic]; !ok {
-			needPublish[topic] = make([]*data.Pod, 0, 10)
-		}
-		needPublish[topic] = append(needPublish[topic], newP)
-	})
-
-	go func() {
-
-		for topic, podList := range needPublish {
-			for i, _ := range podList {
-				if err := c.koleCtl.MessageHandler.PublishData(context.Background(), topic, 0, false, podList[i]); err != nil {
-					klog.Errorf("Mqtt5 publish error %v", err)
-					return
-				}
-			}
-		}
-
-	}()
+	// the template is shared by all the nodes, which are reconciled by NodeReconciler
+	c.koleCtl.PodTemplates.Set(generateKoleDaemonSetPodKey(ds), template)
+	c.koleCtl.NodeReconciler.EnqueueDesired()
 }
+
 func (c *KoleDaemonSetController) addKoleDaemonSet(obj interface{}) {
 	ds := obj.(*v1alpha1.KoleDaemonSet)
 	klog.Infof("Adding KoleDaemonSet %s time %d", ds.Name, time.Now().Unix())
@@ -217,35 +212,41 @@ func (c *KoleDaemonSetController) updateKoleDaemonSet(oldObj, newObj interface{}
 	c.enqueue(ds)
 }
 
+// SecretChanged updates the templates of the KoleDaemonSets referring to secret, so their pods are pushed again.
+func (c *KoleDaemonSetController) SecretChanged(secret *corev1.Secret) {
+	dss, err := c.lister.KoleDaemonSets(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List KoleDaemonSets in %s error %v", secret.Namespace, err)
+		return
+	}
+	for _, ds := range dss {
+		if refersTo(ds.Spec, secret.Name) {
+			klog.V(4).Infof("Secret %s/%s of KoleDaemonSet %s is changed", secret.Namespace, secret.Name, ds.Name)
+			c.addUpdateKoleDaemonSet(ds)
+		}
+	}
+}
+
 func (c *KoleDaemonSetController) deleteKoleDaemonSet(obj interface{}) {
 	ds := obj.(*v1alpha1.KoleDaemonSet)
 	klog.V(4).Infof("Delete KoleDaemonSet %s", ds.Name)
 
-	c.koleCtl.DesiredPodsCache.WriteRange(func(nodeName string, desiredPodsMap map[string]*data.Pod) {
-		// Todo add node selector
-		podKey := generateKoleDaemonSetPodKey(ds)
-		klog.V(4).Infof("Delete KoleDaemonSet pod from node %s , pod key %s", nodeName, podKey)
-		oldP := desiredPodsMap[podKey]
-		deleteT := metav1.Now()
-
-		deletePod := &data.Pod{
-			Hash:            oldP.Hash,
-			Name:            oldP.Name,
-			NameSpace:       oldP.NameSpace,
-			DeleteTimeStamp: &deleteT,
+	podKey := generateKoleDaemonSetPodKey(ds)
+	c.koleCtl.PodTemplates.Delete(podKey)
+
+	for _, node := range c.koleCtl.Nodes.Snapshot() {
+		if _, ok := node.Overrides[podKey]; ok {
+			// the override is dropped with the KoleDaemonSet
+			c.koleCtl.Nodes.Update(node.Name, func(old *NodeState) *NodeState {
+				if old == nil {
+					return old
+				}
+				return old.WithOverride(podKey, nil, true)
+			})
 		}
-
-		dataTopic := filepath.Join(util.TopicDataPrefix, nodeName)
-
-		go func() {
-			if err := c.koleCtl.MessageHandler.PublishData(context.Background(), dataTopic, 0, false, deletePod); err != nil {
-				klog.Errorf("Mqtt5 publish error %v", err)
-				return
-			}
-		}()
-
-		delete(desiredPodsMap, podKey)
-	})
+	}
+	// the pods reported by the nodes but not desired any more are deleted by NodeReconciler
+	c.koleCtl.NodeReconciler.EnqueueDesired()
 
 	c.enqueue(ds)
 }
@@ -261,6 +262,18 @@ func (dsc *KoleDaemonSetController) enqueue(ds *v1alpha1.KoleDaemonSet) {
 	dsc.queue.Add(key)
 }
 
+// EnqueueAll enqueues all the KoleDaemonSets to sync their status.
+func (c *KoleDaemonSetController) EnqueueAll() {
+	dss, err := c.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List KoleDaemonSets error %v", err)
+		return
+	}
+	for _, ds := range dss {
+		c.enqueue(ds)
+	}
+}
+
 func (c *KoleDaemonSetController) Run(threadiness int, stopCh chan struct{}) {
 	defer utilruntime.HandleCrash()
 
@@ -323,6 +336,11 @@ func (c *KoleDaemonSetController) handleErr(err error, key interface{}) {
 
 func (c *KoleDaemonSetController) syncProcess(key string) error {
 
+	// the status is synced again when the controller takes over
+	if !c.koleCtl.IsLeader() {
+		return nil
+	}
+
 	startTime := time.Now()
 	defer func() {
 		klog.V(4).Infof("Finished syncing infdaemon set %q (%v)", key, time.Since(startTime))
@@ -343,25 +361,17 @@ func (c *KoleDaemonSetController) syncProcess(key string) error {
 	}
 
 	podKey := generateKoleDaemonSetPodKey(ds)
-	hash, err := Md5PodSpec(ds.Spec)
-	if err != nil {
-		klog.Errorf("Generage pod spec hash error %v", err)
-		return err
+	count := c.koleCtl.DaemonSetCounters.Count(podKey, c.koleCtl.PodTemplates.Get(podKey))
+
+	if c.koleCtl.Shard != nil {
+		return c.updateShardStatus(ds, v1alpha1.KoleDaemonSetShardStatus{
+			Name:                   c.koleCtl.Shard.Name(),
+			CurrentNumberScheduled: count.Scheduled,
+			DesiredNumberScheduled: count.Desired,
+			NumberReady:            count.Ready,
+			UpdatedNumberScheduled: count.Updated,
+		})
 	}
-	var currentNumberScheduled, podready, desirednum int
-
-	desirednum = c.koleCtl.DesiredPodsCache.Len()
-
-	c.koleCtl.ObserverdPodsCache.ReadRange(func(nodeName string, observerdPods map[string]*data.HeartBeatPod) {
-		for key, pod := range observerdPods {
-			if podKey == key && hash == pod.Hash {
-				currentNumberScheduled++
-				if pod.Status.Phase == data.HeartBeatPodStatusRunning {
-					podready++
-				}
-			}
-		}
-	})
 
 	needUpdate := false
 	if ds.Status == nil {
@@ -369,13 +379,15 @@ func (c *KoleDaemonSetController) syncProcess(key string) error {
 		needUpdate = true
 	}
 
-	if ds.Status.CurrentNumberScheduled != currentNumberScheduled ||
-		ds.Status.NumberReady != podready ||
-		ds.Status.DesiredNumberScheduled != desirednum {
+	if ds.Status.CurrentNumberScheduled != count.Scheduled ||
+		ds.Status.NumberReady != count.Ready ||
+		ds.Status.DesiredNumberScheduled != count.Desired ||
+		ds.Status.UpdatedNumberScheduled != count.Updated {
 
-		ds.Status.CurrentNumberScheduled = currentNumberScheduled
-		ds.Status.NumberReady = podready
-		ds.Status.DesiredNumberScheduled = desirednum
+		ds.Status.CurrentNumberScheduled = count.Scheduled
+		ds.Status.NumberReady = count.Ready
+		ds.Status.DesiredNumberScheduled = count.Desired
+		ds.Status.UpdatedNumberScheduled = count.Updated
 		needUpdate = true
 	}
 
@@ -388,3 +400,57 @@ func (c *KoleDaemonSetController) syncProcess(key string) error {
 	}
 	return nil
 }
+
+// updateShardStatus updates the status counted by the shard. Every shard updates the status of the same KoleDaemonSet,
+// so the latest one is got again on conflict.
+func (c *KoleDaemonSetController) updateShardStatus(ds *v1alpha1.KoleDaemonSet, own v1alpha1.KoleDaemonSetShardStatus) error {
+	for i := 0; ; i++ {
+		status := MergeShardStatus(ds.Status, own, c.koleCtl.Shard.Members())
+		if reflect.DeepEqual(ds.Status, status) {
+			return nil
+		}
+		ds = ds.DeepCopy()
+		ds.Status = status
+		_, err := c.kubeclient.LiteV1alpha1().KoleDaemonSets(ds.Namespace).UpdateStatus(context.Background(), ds, metav1.UpdateOptions{})
+		if err == nil {
+			return nil
+		}
+		if !errors.IsConflict(err) || i >= 3 {
+			klog.Errorf("Update KoleDaemonSet error %v", err)
+			return err
+		}
+		if ds, err = c.kubeclient.LiteV1alpha1().KoleDaemonSets(ds.Namespace).Get(context.Background(), ds.Name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+}
+
+// MergeShardStatus replaces the status of shard own.Name in old, and drops the status of the shards not in members.
+// The numbers of the returned status are the sums of all the shards.
+func MergeShardStatus(old *v1alpha1.KoleDaemonSetStatus, own v1alpha1.KoleDaemonSetShardStatus, members []string) *v1alpha1.KoleDaemonSetStatus {
+	alive := make(map[string]bool, len(members))
+	for _, m := range members {
+		alive[m] = true
+	}
+	status := &v1alpha1.KoleDaemonSetStatus{
+		Shards: make([]v1alpha1.KoleDaemonSetShardStatus, 0, len(members)+1),
+	}
+	if old != nil {
+		for _, s := range old.Shards {
+			if s.Name != own.Name && alive[s.Name] {
+				status.Shards = append(status.Shards, s)
+			}
+		}
+	}
+	status.Shards = append(status.Shards, own)
+	sort.Slice(status.Shards, func(i, j int) bool {
+		return status.Shards[i].Name < status.Shards[j].Name
+	})
+	for _, s := range status.Shards {
+		status.CurrentNumberScheduled += s.CurrentNumberScheduled
+		status.DesiredNumberScheduled += s.DesiredNumberScheduled
+		status.UpdatedNumberScheduled += s.UpdatedNumberScheduled
+		status.NumberReady += s.NumberReady
+	}
+	return status
+}
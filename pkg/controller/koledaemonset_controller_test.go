@@ -0,0 +1,73 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/client/clientset/versioned/fake"
+	"github.com/openyurtio/kole/pkg/client/informers/externalversions"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestAddHostEnqueuesSelecting(t *testing.T) {
+	c := &KoleController{
+		Nodes:             NewNodeStore(),
+		DaemonSetCounters: NewDaemonSetCounters(),
+		PodTemplates:      NewPodTemplates(),
+	}
+	c.NodeReconciler = NewNodeReconciler(c)
+	crdclient := fake.NewSimpleClientset()
+	factory := externalversions.NewSharedInformerFactory(crdclient, 0)
+	informer := factory.Lite().V1alpha1().KoleDaemonSets()
+	c.KoleDaemonSetController, _ = NewKoleDaemonSetController(crdclient, informer, c)
+	for name, selector := range map[string]map[string]string{
+		"all":      nil,
+		"hangzhou": {"region": "hangzhou"},
+		"beijing":  {"region": "beijing"},
+	} {
+		informer.Informer().GetIndexer().Add(&v1alpha1.KoleDaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kole", Name: name},
+			Spec:       &v1alpha1.PodSpec{NodeSelector: selector},
+		})
+	}
+
+	hb := &data.HeartBeat{Name: "node", SeqNum: 1, Labels: map[string]string{"region": "hangzhou"}}
+	c.Nodes.Update(hb.Name, func(old *NodeState) *NodeState {
+		return NewNodeStateFromHeartBeat(hb)
+	})
+	queue := c.KoleDaemonSetController.queue
+	c.KoleDaemonSetController.AddHost(hb.Name)
+	if queue.Len() != 2 {
+		t.Fatalf("expect the 2 KoleDaemonSets selecting the node enqueued, get %d", queue.Len())
+	}
+	for i := 0; i < 2; i++ {
+		key, _ := queue.Get()
+		if key != "kole/all" && key != "kole/hangzhou" {
+			t.Errorf("unexpected KoleDaemonSet %v enqueued", key)
+		}
+		queue.Done(key)
+	}
+
+	// the node added already counts in the status of the KoleDaemonSets
+	c.KoleDaemonSetController.AddHost(hb.Name)
+	if queue.Len() != 0 {
+		t.Errorf("expect no KoleDaemonSet enqueued for the node added already, get %d", queue.Len())
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
@@ -33,6 +34,7 @@ import (
 	"github.com/openyurtio/kole/pkg/client/clientset/versioned"
 	externalV1alpha1 "github.com/openyurtio/kole/pkg/client/informers/externalversions/lite/v1alpha1"
 	listV1alpha1 "github.com/openyurtio/kole/pkg/client/listers/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
 )
 
 type KoleQueryController struct {
@@ -95,6 +97,18 @@ func (c *KoleQueryController) enqueue(ds *v1alpha1.KoleQuery) {
 	c.queue.Add(key)
 }
 
+// EnqueueAll enqueues all the KoleQueries to sync their status.
+func (c *KoleQueryController) EnqueueAll() {
+	kqs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("List KoleQueries error %v", err)
+		return
+	}
+	for _, kq := range kqs {
+		c.enqueue(kq)
+	}
+}
+
 func (c *KoleQueryController) Run(threadiness int, stopCh chan struct{}) {
 	defer utilruntime.HandleCrash()
 
@@ -176,9 +190,25 @@ func (c *KoleQueryController) syncProcess(key string) error {
 		return fmt.Errorf("unable to retrieve ds %v from store: %v", key, err)
 	}
 
-	if kq.Spec.ObjectType == v1alpha1.KoleObjectNode && kq.Spec.ObjectName != "" {
+	switch kq.Spec.QueryType {
+	case v1alpha1.KoleQueryDelete:
+		return c.syncDelete(kq)
+	case v1alpha1.KoleQueryApprove:
+		return c.syncApprove(kq)
+	}
+
+	// the status is synced again when the controller takes over
+	if !c.koleCtl.IsLeader() {
+		return nil
+	}
+
+	// only the shard owning the node updates the status
+	if kq.Spec.ObjectType == v1alpha1.KoleObjectNode && kq.Spec.ObjectName != "" && c.koleCtl.Shard.Owns(kq.Spec.ObjectName) {
 		s := c.koleCtl.QueryNodeStatusCache.GetNodeStatus(kq.Spec.ObjectName)
 		if s != nil {
+			// the cached status is shared, it is filled with the transitions of the node
+			s = s.DeepCopy()
+			c.koleCtl.NodeHistory.QueryStatus(kq.Spec.ObjectName, s)
 			if kq.Status == nil {
 				kq.Status = make([]*v1alpha1.KoleQueryStatus, 0, 0)
 			}
@@ -200,3 +230,62 @@ func (c *KoleQueryController) syncProcess(key string) error {
 	}
 	return nil
 }
+
+// syncDelete removes the node of a KoleQueryDelete query. All the instances owning the node remove it,
+// since the standbys keep the node states too, and the leader reports the node is deleted.
+func (c *KoleQueryController) syncDelete(kq *v1alpha1.KoleQuery) error {
+	if kq.Spec.ObjectType != v1alpha1.KoleObjectNode || kq.Spec.ObjectName == "" || !c.koleCtl.Shard.Owns(kq.Spec.ObjectName) {
+		return nil
+	}
+	// the query is synced again and again, the node registered again after the query is kept
+	c.koleCtl.DeleteNode(kq.Spec.ObjectName, kq.CreationTimestamp.Time)
+
+	if !c.koleCtl.IsLeader() || (len(kq.Status) != 0 && kq.Status[0].ObjectStatus == v1alpha1.KoleQueryStatusDeleted) {
+		return nil
+	}
+	kq = kq.DeepCopy()
+	kq.Status = []*v1alpha1.KoleQueryStatus{
+		{
+			LastObservedTime: metav1.Now(),
+			ObjectType:       v1alpha1.KoleObjectNode,
+			ObjectStatus:     v1alpha1.KoleQueryStatusDeleted,
+			ObjectName:       kq.Spec.ObjectName,
+		},
+	}
+	if _, err := c.kubeclient.LiteV1alpha1().KoleQueries(kq.Namespace).UpdateStatus(context.Background(), kq, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Update KoleQuery error %v", err)
+		return err
+	}
+	return nil
+}
+
+// syncApprove approves the pending node of a KoleQueryApprove query. All the instances owning the node approve it,
+// and the leader reports the node is approved once it is registered. The query is synced again and again,
+// so a node registering after the query is created is approved as well.
+func (c *KoleQueryController) syncApprove(kq *v1alpha1.KoleQuery) error {
+	if kq.Spec.ObjectType != v1alpha1.KoleObjectNode || kq.Spec.ObjectName == "" || !c.koleCtl.Shard.Owns(kq.Spec.ObjectName) {
+		return nil
+	}
+	c.koleCtl.ApproveNode(kq.Spec.ObjectName)
+
+	if !c.koleCtl.IsLeader() || (len(kq.Status) != 0 && kq.Status[0].ObjectStatus == v1alpha1.KoleQueryStatusApproved) {
+		return nil
+	}
+	if node := c.koleCtl.Nodes.Get(kq.Spec.ObjectName); node == nil || node.HeartBeat.State == data.HeartBeatPending {
+		return nil
+	}
+	kq = kq.DeepCopy()
+	kq.Status = []*v1alpha1.KoleQueryStatus{
+		{
+			LastObservedTime: metav1.Now(),
+			ObjectType:       v1alpha1.KoleObjectNode,
+			ObjectStatus:     v1alpha1.KoleQueryStatusApproved,
+			ObjectName:       kq.Spec.ObjectName,
+		},
+	}
+	if _, err := c.kubeclient.LiteV1alpha1().KoleQueries(kq.Namespace).UpdateStatus(context.Background(), kq, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Update KoleQuery error %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/cmd/kole-controller/app/options"
+)
+
+// IsLeader returns true if the controller publishes acks and pods, saves snapshots and updates status,
+// it is always true without leader election.
+func (c *KoleController) IsLeader() bool {
+	return atomic.LoadInt32(&c.leading) == 1
+}
+
+// newLeaderElector creates the elector of the controller instances, the instances of a shard elect their own leader.
+func (c *KoleController) newLeaderElector(client kubernetes.Interface, config *options.KoleControllerFlags) (*leaderelection.LeaderElector, error) {
+	name := config.LeaderElectionID
+	if c.Shard != nil {
+		name += "-" + c.Shard.Name()
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: config.NameSpace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            name,
+		LeaseDuration:   time.Second * time.Duration(config.LeaderElectionLeaseDuration),
+		RenewDeadline:   time.Second * time.Duration(config.LeaderElectionRenewDeadline),
+		RetryPeriod:     time.Second * time.Duration(config.LeaderElectionRetryPeriod),
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				c.takeOver()
+			},
+			OnStoppedLeading: func() {
+				if atomic.CompareAndSwapInt32(&c.leading, 1, 0) {
+					klog.Warningf("Lost the leadership of %s, step back to standby", name)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				klog.Infof("The leader of %s is %s", name, identity)
+			},
+		},
+	})
+}
+
+// runLeaderElection campaigns for the leadership until stop. The controller is a standby consuming heartbeats
+// until it becomes the leader, and it campaigns again as a standby if the leadership is lost.
+func (c *KoleController) runLeaderElection(stop <-chan struct{}, elector *leaderelection.LeaderElector) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	wait.Until(func() {
+		elector.Run(ctx)
+	}, time.Second, stop)
+}
+
+// takeOver makes the controller the leader. Its caches are kept warm as a standby, so it only has to load
+// the snapshot generations saved by the previous leader, save a snapshot at once to ack the registering nodes,
+// and sync the status of all the KoleDaemonSets and KoleQueries. The desired pods are pushed by the next heartbeats.
+func (c *KoleController) takeOver() {
+	klog.Infof("Take over as the leader")
+	atomic.StoreInt32(&c.leading, 1)
+	select {
+	case c.tookOver <- struct{}{}:
+	default:
+	}
+	c.KoleDaemonSetController.EnqueueAll()
+	c.KoleQueryController.EnqueueAll()
+	// the pods are pushed only by the leader, the nodes may be out of date since the last leader stepped down
+	c.NodeReconciler.EnqueueDesired()
+}
+
+// syncGenerations loads the snapshot generations saved by the previous leader, so the next snapshot follows the latest one.
+func (c *KoleController) syncGenerations() {
+	generations, err := c.SnapshotStore.ListGenerations()
+	if err != nil {
+		klog.Errorf("Load snapshot generations error %v, keep the cached generations", err)
+		return
+	}
+	snapedGenerations := make([][]string, 0, len(generations))
+	for _, g := range generations {
+		snapedGenerations = append(snapedGenerations, g.Names())
+	}
+	c.SnapedGenerations = snapedGenerations
+	if len(generations) != 0 && generations[len(generations)-1].Index >= c.LasterSnapIndex {
+		c.LasterSnapIndex = generations[len(generations)-1].Index + 1
+	}
+}
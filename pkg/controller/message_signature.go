@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// NewMessageKeys returns the keys of alg signing the messages to the nodes and verifying the messages of the nodes.
+// With hmac-sha256, the keys of keyring are the master keys of the node keys. With ed25519, the active key of keyring
+// is the seed of the private key of the controllers, and a node is pinned to the public key of its first verified
+// heartbeat, which is kept in the snapshots, so the node must be deleted to register with another key.
+func (c *KoleController) NewMessageKeys(alg string, keyring *Keyring) (data.MessageKeys, error) {
+	switch alg {
+	case data.SignatureHMAC:
+		return &data.HMACKeys{Source: keyring, Derive: true}, nil
+	case data.SignatureEd25519:
+		id, seed := keyring.ActiveKey()
+		key, err := data.Ed25519SeedKey(seed)
+		if err != nil {
+			return nil, fmt.Errorf("active key %s is not an ed25519 seed: %v", id, err)
+		}
+		klog.Infof("Messages are signed by ed25519 key %s, public key %s", id, data.Ed25519KeyID(key.Public().(ed25519.PublicKey)))
+		return &data.Ed25519Keys{
+			PrivateKey: func() (string, ed25519.PrivateKey, error) {
+				id, seed := keyring.ActiveKey()
+				key, err := data.Ed25519SeedKey(seed)
+				return id, key, err
+			},
+			PublicKey: c.nodePublicKey,
+		}, nil
+	}
+	return nil, fmt.Errorf("message signature %q is not supported", alg)
+}
+
+// signingKeysRefreshPeriod is the period the signing keys are reloaded from their secret.
+const signingKeysRefreshPeriod = time.Minute
+
+func (c *KoleController) refreshSigningKeys() {
+	if rotated, err := c.SigningKeyring.Refresh(nil); err != nil {
+		klog.Errorf("Refresh signing keys error %v, use the cached keys", err)
+	} else if rotated {
+		id, _ := c.SigningKeyring.ActiveKey()
+		klog.Infof("Signing key is rotated to %s", id)
+	}
+}
+
+// NewControllerMessageSigner creates the signer of the messages sent by the controllers. The sequences are based
+// on the time, so they keep increasing across the restarts and the leaders.
+func NewControllerMessageSigner(keys data.MessageKeys) *data.MessageSigner {
+	return data.NewMessageSigner(keys, func() uint64 {
+		return uint64(time.Now().UnixNano())
+	})
+}
+
+// nodePublicKey returns the public key of kid verifying the messages of node, which must be the pinned one.
+func (c *KoleController) nodePublicKey(node, kid string) (ed25519.PublicKey, error) {
+	key, err := data.ParseEd25519KeyID(kid)
+	if err != nil {
+		return nil, err
+	}
+	if n := c.Nodes.Get(node); n != nil && len(n.HeartBeat.PublicKey) != 0 && !bytes.Equal(n.HeartBeat.PublicKey, key) {
+		return nil, fmt.Errorf("node %s is pinned to key %s", node, data.Ed25519KeyID(n.HeartBeat.PublicKey))
+	}
+	return key, nil
+}
+
+// openHeartBeat verifies and decodes the heartbeat in payload, the rejected heartbeats are counted.
+func (c *KoleController) openHeartBeat(payload []byte) (*data.HeartBeat, error) {
+	var m *data.SignedMessage
+	if c.MessageVerifier != nil {
+		var err error
+		if m, err = c.MessageVerifier.Open(data.SignedTypeHeartBeat, payload); err != nil {
+			countRejectedMessage(data.SignedTypeHeartBeat, err)
+			return nil, err
+		}
+		payload = m.Payload
+	}
+	hb, err := data.UnmarshalPayloadToHeartBeat(payload)
+	if err != nil {
+		return nil, err
+	}
+	// the public key is only set by the verified signature
+	hb.PublicKey = nil
+	hb.Signed = false
+	if m == nil || len(m.Alg) == 0 {
+		// the secrets are never sealed to a key reported by an unsigned heartbeat
+		hb.EncryptionKey = nil
+		return hb, nil
+	}
+	if hb.Name != m.Node {
+		err := &data.SignatureError{Reason: data.RejectInvalid, Err: fmt.Errorf("heartbeat of node %s is signed by node %s", hb.Name, m.Node)}
+		countRejectedMessage(data.SignedTypeHeartBeat, err)
+		return nil, err
+	}
+	if m.Alg == data.SignatureEd25519 {
+		hb.PublicKey, _ = data.ParseEd25519KeyID(m.KeyID)
+	}
+	hb.Signed = true
+	return hb, nil
+}
+
+// openWill verifies and decodes the will in payload, the rejected wills are counted.
+func (c *KoleController) openWill(payload []byte) (*data.NodeWill, error) {
+	var m *data.SignedMessage
+	if c.MessageVerifier != nil {
+		var err error
+		if m, err = c.MessageVerifier.Open(data.SignedTypeWill, payload); err != nil {
+			countRejectedMessage(data.SignedTypeWill, err)
+			return nil, err
+		}
+		payload = m.Payload
+	}
+	will, err := data.UnmarshalPayloadToNodeWill(payload)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && len(m.Alg) != 0 && will.Name != m.Node {
+		err := &data.SignatureError{Reason: data.RejectInvalid, Err: fmt.Errorf("will of node %s is signed by node %s", will.Name, m.Node)}
+		countRejectedMessage(data.SignedTypeWill, err)
+		return nil, err
+	}
+	return will, nil
+}
+
+func countRejectedMessage(typ string, err error) {
+	reason := data.RejectInvalid
+	if serr, ok := err.(*data.SignatureError); ok {
+		reason = serr.Reason
+	}
+	klog.V(4).Infof("Reject %s message: %v", typ, err)
+	messagesRejected.WithLabelValues(typ, reason).Inc()
+}
+
+// heartBeatName returns the name of the node sending the heartbeat in payload without verifying it.
+func heartBeatName(payload []byte) (string, error) {
+	var m struct {
+		// the node of a signed message
+		Node string `json:"node"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return "", err
+	}
+	if len(m.Node) != 0 {
+		return m.Node, nil
+	}
+	return m.Name, nil
+}
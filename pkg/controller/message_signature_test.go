@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestOpenHeartBeat(t *testing.T) {
+	keyring, err := NewKeyringFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			"controller": bytes.Repeat([]byte("c"), 32),
+		},
+	})
+	if err != nil {
+		t.Fatalf("new keyring error %v", err)
+	}
+	c := &KoleController{Nodes: NewNodeStore()}
+	keys, err := c.NewMessageKeys(data.SignatureEd25519, keyring)
+	if err != nil {
+		t.Fatalf("new message keys error %v", err)
+	}
+	c.MessageVerifier = data.NewMessageVerifier(keys, false)
+
+	sign := func(seed byte, hb *data.HeartBeat) []byte {
+		key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, 32))
+		signer := data.NewMessageSigner(&data.Ed25519Keys{
+			PrivateKey: func() (string, ed25519.PrivateKey, error) {
+				return data.Ed25519KeyID(key.Public().(ed25519.PublicKey)), key, nil
+			},
+		}, func() uint64 { return hb.SeqNum << 32 })
+		m, err := signer.Sign(data.SignedTypeHeartBeat, "node", hb)
+		if err != nil {
+			t.Fatalf("sign error %v", err)
+		}
+		payload, _ := json.Marshal(m)
+		return payload
+	}
+
+	// the key carried by the heartbeat itself is never trusted
+	hb, err := c.openHeartBeat(sign('n', &data.HeartBeat{Name: "node", SeqNum: 1, PublicKey: []byte("forged")}))
+	if err != nil {
+		t.Fatalf("open heartbeat error %v", err)
+	}
+	if name, _ := heartBeatName(sign('n', hb)); name != "node" {
+		t.Errorf("expect heartbeat of node, get %s", name)
+	}
+	pinned := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{'n'}, 32)).Public().(ed25519.PublicKey)
+	if !bytes.Equal(hb.PublicKey, pinned) || !hb.Signed {
+		t.Fatalf("expect heartbeat verified by the key of the node")
+	}
+	c.Nodes.Update(hb.Name, func(old *NodeState) *NodeState {
+		return NewNodeStateFromHeartBeat(hb)
+	})
+
+	// the heartbeats signed by another key are rejected once the node is pinned
+	if _, err := c.openHeartBeat(sign('x', &data.HeartBeat{Name: "node", SeqNum: 2})); err == nil {
+		t.Errorf("expect heartbeat signed by another key rejected")
+	}
+	// a node can not send the heartbeats of another node
+	if _, err := c.openHeartBeat(sign('n', &data.HeartBeat{Name: "other", SeqNum: 2})); err == nil {
+		t.Errorf("expect heartbeat of another node rejected")
+	}
+	if _, err := c.openHeartBeat(sign('n', &data.HeartBeat{Name: "node", SeqNum: 3})); err != nil {
+		t.Errorf("open heartbeat error %v", err)
+	}
+	unsigned, _ := json.Marshal(&data.HeartBeat{Name: "node", SeqNum: 3, EncryptionKey: []byte("forged"), Signed: true})
+	if _, err := c.openHeartBeat(unsigned); err == nil {
+		t.Errorf("expect unsigned heartbeat rejected")
+	}
+	// the unsigned heartbeats accepted carry no encryption key
+	c.MessageVerifier = data.NewMessageVerifier(keys, true)
+	if hb, err := c.openHeartBeat(unsigned); err != nil || hb.Signed || len(hb.EncryptionKey) != 0 {
+		t.Errorf("expect unsigned heartbeat without encryption key, get %+v error %v", hb, err)
+	}
+
+	// the unsigned heartbeats keep the pinned key
+	n := c.Nodes.Get("node").WithHeartBeat(&data.HeartBeat{Name: "node", SeqNum: 3})
+	if !bytes.Equal(n.HeartBeat.PublicKey, pinned) {
+		t.Errorf("expect pinned key kept")
+	}
+
+	// the identity key is pinned by the first registration
+	n = n.WithHeartBeat(&data.HeartBeat{Name: "node", SeqNum: 4, State: data.HeartBeatRegistering, EncryptionKey: []byte("identity")})
+	n = n.WithHeartBeat(&data.HeartBeat{Name: "node", SeqNum: 5, State: data.HeartBeatRegistering, EncryptionKey: []byte("other")})
+	if string(n.HeartBeat.EncryptionKey) != "identity" {
+		t.Errorf("expect pinned identity key kept, get %q", n.HeartBeat.EncryptionKey)
+	}
+}
+
+func TestOpenWill(t *testing.T) {
+	master := &data.StaticKeys{}
+	master.Add("master", bytes.Repeat([]byte("m"), 32))
+	c := &KoleController{Nodes: NewNodeStore()}
+	c.MessageVerifier = data.NewMessageVerifier(&data.HMACKeys{Source: master, Derive: true}, false)
+
+	nodeKeys := &data.StaticKeys{}
+	nodeKeys.Add("master", data.DeriveNodeKey(bytes.Repeat([]byte("m"), 32), "node"))
+	signer := data.NewMessageSigner(&data.HMACKeys{Source: nodeKeys}, func() uint64 { return 1 << 32 })
+	sign := func(typ string, will *data.NodeWill) []byte {
+		m, err := signer.Sign(typ, "node", will)
+		if err != nil {
+			t.Fatalf("sign error %v", err)
+		}
+		payload, _ := json.Marshal(m)
+		return payload
+	}
+
+	will := sign(data.SignedTypeWill, &data.NodeWill{Name: "node", SeqNum: 1})
+	if w, err := c.openWill(will); err != nil || w.Name != "node" || w.SeqNum != 1 {
+		t.Fatalf("expect will of node opened, get %+v error %v", w, err)
+	}
+	if _, err := c.openWill(will); err == nil {
+		t.Errorf("expect replayed will rejected")
+	}
+	// a node can not send the will of another node, or a heartbeat as a will
+	if _, err := c.openWill(sign(data.SignedTypeWill, &data.NodeWill{Name: "other", SeqNum: 1})); err == nil {
+		t.Errorf("expect will of another node rejected")
+	}
+	if _, err := c.openWill(sign(data.SignedTypeHeartBeat, &data.NodeWill{Name: "node", SeqNum: 1})); err == nil {
+		t.Errorf("expect heartbeat rejected as will")
+	}
+	unsigned, _ := json.Marshal(&data.NodeWill{Name: "node", SeqNum: 1})
+	if _, err := c.openWill(unsigned); err == nil {
+		t.Errorf("expect unsigned will rejected")
+	}
+}
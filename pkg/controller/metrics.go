@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metricsNamespace = "kole"
+	metricsSubsystem = "controller"
+)
+
+var (
+	heartBeatQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "heartbeat_queue_depth",
+		Help:      "The number of nodes whose heartbeats are waiting to be processed.",
+	})
+	heartBeatReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "heartbeat_received_total",
+		Help:      "The number of heartbeats received by the processing pipeline.",
+	})
+	heartBeatCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "heartbeat_coalesced_total",
+		Help:      "The number of heartbeats replaced by a newer pending heartbeat of the same node.",
+	})
+	heartBeatDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "heartbeat_dropped_total",
+		Help:      "The number of heartbeats dropped because the processing queue is full.",
+	})
+	heartBeatProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "heartbeat_processed_total",
+		Help:      "The number of heartbeats processed by the workers.",
+	})
+
+	outboundPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "outbound_pending",
+		Help:      "The number of messages waiting to be delivered to the nodes.",
+	}, []string{"priority"})
+	outboundDelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "outbound_delivered_total",
+		Help:      "The number of messages delivered to the nodes.",
+	}, []string{"priority"})
+	outboundRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "outbound_retries_total",
+		Help:      "The number of retries of failed deliveries.",
+	}, []string{"priority"})
+	outboundFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "outbound_failed_total",
+		Help:      "The number of messages dropped after all the retries failed.",
+	}, []string{"priority"})
+
+	nodesByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "nodes",
+		Help:      "The number of cached nodes in each state.",
+	}, []string{"state"})
+	nodeTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "node_transitions_total",
+		Help:      "The number of state transitions of the nodes.",
+	}, []string{"from", "to", "reason"})
+	flappingNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "flapping_nodes",
+		Help:      "The number of nodes changing their states too often recently.",
+	})
+	nodeFlaps = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "node_flaps_total",
+		Help:      "The number of times the nodes start flapping.",
+	})
+	registrationsAdmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "registrations_admitted_total",
+		Help:      "The number of registrations admitted by the rate limit.",
+	})
+	registrationsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "registrations_rejected_total",
+		Help:      "The number of registrations rejected by the rate limit, which are retried later.",
+	})
+	registrationsApproved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "registrations_approved_total",
+		Help:      "The number of registrations approved by every approval policy.",
+	}, []string{"policy"})
+	messagesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "messages_rejected_total",
+		Help:      "The number of messages rejected by the signature verification, by message type and reason.",
+	}, []string{"type", "reason"})
+	certificateRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "certificate_requests_total",
+		Help:      "The number of the certificate requests of the nodes handled, by result.",
+	}, []string{"result"})
+	nodesRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "nodes_removed_total",
+		Help:      "The number of nodes removed by unregistering, deleting and garbage collection.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		heartBeatQueueDepth,
+		heartBeatReceived,
+		heartBeatCoalesced,
+		heartBeatDropped,
+		heartBeatProcessed,
+		outboundPending,
+		outboundDelivered,
+		outboundRetries,
+		outboundFailed,
+		nodesByState,
+		nodeTransitions,
+		flappingNodes,
+		nodeFlaps,
+		nodesRemoved,
+		registrationsAdmitted,
+		registrationsRejected,
+		registrationsApproved,
+		messagesRejected,
+		certificateRequests,
+	)
+}
+
+// ServeMetrics serves the prometheus metrics at addr/metrics.
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	klog.Infof("Serve metrics at %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		klog.Errorf("Serve metrics at %s error %v", addr, err)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// Node removal reasons, which label the removed nodes metric.
+const (
+	NodeRemovedUnregistered = "unregistered"
+	NodeRemovedDeleted      = "deleted"
+	NodeRemovedCollected    = "collected"
+)
+
+// removeNode drops node name from the store if keep returns false for its state, and returns true if it is removed.
+// The other caches of the node are cleaned as well, and the KoleDaemonSets are synced to stop counting it.
+func (c *KoleController) removeNode(name, reason string, keep func(old *NodeState) bool) bool {
+	var removed bool
+	c.Nodes.Update(name, func(old *NodeState) *NodeState {
+		if old == nil || keep(old) {
+			return old
+		}
+		removed = true
+		return nil
+	})
+	if !removed {
+		return false
+	}
+
+	klog.Infof("Node %s is removed, reason %s", name, reason)
+	nodesRemoved.WithLabelValues(reason).Inc()
+	c.RemoveNodes([]string{name})
+	if c.KoleDaemonSetController != nil {
+		c.KoleDaemonSetController.EnqueueAll()
+	}
+	return true
+}
+
+// unregister removes the node shutting down cleanly, unless hb is out of order.
+func (c *KoleController) unregister(hb *data.HeartBeat) bool {
+	return c.removeNode(hb.Name, NodeRemovedUnregistered, func(old *NodeState) bool {
+		return !old.Accepts(hb)
+	})
+}
+
+// DeleteNode removes node name deleted by the admin, unless the node sends heartbeats since before.
+func (c *KoleController) DeleteNode(name string, before time.Time) bool {
+	return c.removeNode(name, NodeRemovedDeleted, func(old *NodeState) bool {
+		return old.HeartBeat.LasterTimeStamp >= before.Unix()
+	})
+}
+
+// collectNode removes node name offline longer than the gc period, or pending without heartbeats longer than it.
+func (c *KoleController) collectNode(name string) {
+	removed := c.removeNode(name, NodeRemovedCollected, func(old *NodeState) bool {
+		switch old.HeartBeat.State {
+		case data.HeartBeatOffline:
+			return false
+		case data.HeartBeatPending:
+			// a heartbeat may be accepted after the deadline expires
+			return time.Since(lastHeartBeat(old)) < c.NodeGC.timeout
+		}
+		// the node is back after the deadline expires
+		return true
+	})
+	if removed && c.MessageVerifier != nil {
+		// the replay window of a node is dropped only once it is long gone, the heartbeats replayed
+		// while it is cached are dropped by the sequence filter anyway
+		c.MessageVerifier.Forget(name)
+	}
+}
+
+// lastHeartBeat returns the time the latest heartbeat of node is received, the nodes loaded from snapshots
+// are treated as if their heartbeats are just received.
+func lastHeartBeat(node *NodeState) time.Time {
+	if node.HeartBeat.LasterTimeStamp == 0 {
+		return time.Now()
+	}
+	return time.Unix(node.HeartBeat.LasterTimeStamp, 0)
+}
+
+// watchGC starts the gc period of a node once it goes offline, and stops it once the node is back.
+// The gc period of a pending node is restarted with every heartbeat, so the nodes never approved are removed
+// once they stop registering.
+func (c *KoleController) watchGC(old, new *NodeState) {
+	if c.NodeGC == nil {
+		return
+	}
+	wasOffline := old != nil && old.HeartBeat.State == data.HeartBeatOffline
+	isOffline := new != nil && new.HeartBeat.State == data.HeartBeatOffline
+	wasPending := old != nil && old.HeartBeat.State == data.HeartBeatPending
+	isPending := new != nil && new.HeartBeat.State == data.HeartBeatPending
+	switch {
+	case isOffline && !wasOffline:
+		c.NodeGC.Touch(new.Name, time.Now())
+	case isPending && (old == nil || old.HeartBeat != new.HeartBeat):
+		c.NodeGC.Touch(new.Name, lastHeartBeat(new))
+	case (wasOffline || wasPending) && !isOffline && !isPending:
+		c.NodeGC.Forget(old.Name)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestRemoveNodes(t *testing.T) {
+	c := &KoleController{
+		Nodes:             NewNodeStore(),
+		DaemonSetCounters: NewDaemonSetCounters(),
+		QueryNodeStatusCache: &QueryNodeStatusCache{
+			RWMutex:      &sync.RWMutex{},
+			NameToStatus: make(map[string]*v1alpha1.KoleQueryStatus),
+		},
+		Dispatcher:       NewDispatcher(DispatcherOptions{Workers: 1, QPS: 100, Burst: 100, NodeQPS: 100, NodeBurst: 100}),
+		HeartBeatTimeOut: 300,
+	}
+	c.NodeReconciler = NewNodeReconciler(c)
+	c.OfflineDetector = NewOfflineDetector(time.Hour, c.markOffline)
+	c.NodeGC = NewOfflineDetector(time.Hour, c.collectNode)
+	c.AddTransitionHandler(c.queryStatusTransition)
+	c.Nodes.OnChange = c.onNodeChange
+
+	now := time.Now().Unix()
+	for name, last := range map[string]int64{"unregistered": now, "deleted": now, "back": now, "offline": now - 300} {
+		hb := &data.HeartBeat{Name: name, SeqNum: 2, TimeStamp: 10, State: data.HeartBeatRegisterd, LasterTimeStamp: last}
+		c.Nodes.Update(name, func(old *NodeState) *NodeState {
+			return NewNodeStateFromHeartBeat(hb)
+		})
+	}
+
+	// an unregistering heartbeat out of order is dropped
+	if c.ConsumeSingleHeartBeat(&data.HeartBeat{Name: "unregistered", SeqNum: 1, TimeStamp: 11, State: data.HeartBeatUnregistering}) {
+		t.Errorf("expect unregistering heartbeat of the old process dropped")
+	}
+	if !c.ConsumeSingleHeartBeat(&data.HeartBeat{Name: "unregistered", SeqNum: 2, TimeStamp: 11, State: data.HeartBeatUnregistering}) {
+		t.Errorf("expect unregistering heartbeat accepted")
+	}
+
+	if !c.DeleteNode("deleted", time.Unix(now+1, 0)) {
+		t.Errorf("expect node deleted")
+	}
+	if c.DeleteNode("back", time.Unix(now, 0)) {
+		t.Errorf("expect node sending heartbeats after the deletion kept")
+	}
+
+	// the node offline is collected, but not the one back online
+	c.markOffline("offline")
+	c.collectNode("back")
+	if c.NodeGC.Len() != 1 {
+		t.Errorf("expect 1 offline node watched by gc, get %d", c.NodeGC.Len())
+	}
+	c.collectNode("offline")
+
+	for name, expect := range map[string]bool{"unregistered": false, "deleted": false, "back": true, "offline": false} {
+		if c.Nodes.Has(name) != expect {
+			t.Errorf("expect node %s cached %v", name, expect)
+		}
+		if (c.QueryNodeStatusCache.GetNodeStatus(name) != nil) != expect {
+			t.Errorf("expect status of node %s cached %v", name, expect)
+		}
+	}
+	if c.NodeGC.Len() != 0 || c.OfflineDetector.Len() != 1 {
+		t.Errorf("expect only node back watched, get gc %d offline %d", c.NodeGC.Len(), c.OfflineDetector.Len())
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+)
+
+// transitionHistorySize is the min number of transitions kept for a node.
+const transitionHistorySize = 32
+
+// NodeHistory keeps the latest state transitions of every node, and finds the flapping nodes which change
+// their states at least threshold times within window. The new nodes are not recorded until they change their states,
+// and the history of a node is dropped when it is removed.
+type NodeHistory struct {
+	lock sync.RWMutex
+	// node name / the transitions, the oldest first. A history is replaced instead of modified, so it can be read without lock.
+	transitions map[string][]NodeTransition
+	size        int
+	window      time.Duration
+	threshold   int
+}
+
+// NewNodeHistory creates a NodeHistory finding the nodes changing their states at least threshold times within window.
+func NewNodeHistory(window time.Duration, threshold int) *NodeHistory {
+	size := transitionHistorySize
+	if threshold > size {
+		size = threshold
+	}
+	return &NodeHistory{
+		transitions: make(map[string][]NodeTransition),
+		size:        size,
+		window:      window,
+		threshold:   threshold,
+	}
+}
+
+// Record is a NodeTransitionHandler recording t into the history of its node.
+func (h *NodeHistory) Record(t NodeTransition) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if t.To == "" {
+		delete(h.transitions, t.Name)
+		return
+	}
+	if t.From == "" {
+		return
+	}
+
+	old := h.transitions[t.Name]
+	start := 0
+	if len(old) >= h.size {
+		start = len(old) - h.size + 1
+	}
+	transitions := make([]NodeTransition, 0, len(old)-start+1)
+	transitions = append(transitions, old[start:]...)
+	transitions = append(transitions, t)
+	h.transitions[t.Name] = transitions
+
+	if !h.flapping(old, t.Time) && h.flapping(transitions, t.Time) {
+		klog.V(4).Infof("Node %s is flapping, %d transitions within %v", t.Name, h.threshold, h.window)
+		nodeFlaps.Inc()
+	}
+}
+
+func (h *NodeHistory) flapping(transitions []NodeTransition, now time.Time) bool {
+	since := now.Add(-h.window)
+	var n int
+	for i := len(transitions) - 1; i >= 0 && transitions[i].Time.After(since); i-- {
+		n++
+	}
+	return n >= h.threshold
+}
+
+// Transitions returns the history of node name, the oldest first. The returned slice must not be modified.
+func (h *NodeHistory) Transitions(name string) []NodeTransition {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.transitions[name]
+}
+
+// IsFlapping returns true if node name changes its state at least threshold times within the window before now.
+func (h *NodeHistory) IsFlapping(name string, now time.Time) bool {
+	return h.flapping(h.Transitions(name), now)
+}
+
+// Flapping returns the names of the flapping nodes.
+func (h *NodeHistory) Flapping(now time.Time) []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var names []string
+	for name, transitions := range h.transitions {
+		if h.flapping(transitions, now) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// QueryStatus fills the history of node name into its status queried by KoleQuery.
+func (h *NodeHistory) QueryStatus(name string, s *v1alpha1.KoleQueryStatus) {
+	transitions := h.Transitions(name)
+	s.Flapping = h.flapping(transitions, time.Now())
+	s.Transitions = nil
+	for _, t := range transitions {
+		s.Transitions = append(s.Transitions, v1alpha1.KoleQueryTransition{
+			// truncated as serialized, so the status is not updated again and again
+			Time:   metav1.NewTime(t.Time.Truncate(time.Second)),
+			From:   t.From,
+			To:     t.To,
+			Reason: t.Reason,
+		})
+	}
+}
+
+// Run updates the metric of the flapping nodes periodically until stop is closed, since a node stops flapping
+// without any transition.
+func (h *NodeHistory) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		flappingNodes.Set(float64(len(h.Flapping(time.Now()))))
+	}, 30*time.Second, stop)
+}
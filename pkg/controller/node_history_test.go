@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestNodeHistory(t *testing.T) {
+	h := NewNodeHistory(time.Minute, 4)
+	start := time.Now()
+	flap := func(name string, n int, since time.Time) {
+		for i := 0; i < n; i++ {
+			from, to, reason := data.HeartBeatRegisterd, data.HeartBeatOffline, TransitionReasonTimeout
+			if i%2 == 1 {
+				from, to, reason = to, from, TransitionReasonHeartBeat
+			}
+			h.Record(NodeTransition{Name: name, From: from, To: to, Reason: reason, Time: since.Add(time.Duration(i) * time.Second)})
+		}
+	}
+
+	h.Record(NodeTransition{Name: "new", To: data.HeartBeatRegistering, Time: start})
+	flap("stable", 3, start)
+	flap("flapping", 40, start)
+	// the transitions out of the window are not counted
+	flap("recovered", 10, start.Add(-time.Hour))
+
+	if transitions := h.Transitions("new"); len(transitions) != 0 {
+		t.Errorf("expect new node not recorded, get %d transitions", len(transitions))
+	}
+	if transitions := h.Transitions("flapping"); len(transitions) != transitionHistorySize || transitions[0].Time != start.Add(8*time.Second) {
+		t.Errorf("expect the latest %d transitions kept, get %d", transitionHistorySize, len(transitions))
+	}
+	now := start.Add(time.Minute)
+	if flapping := h.Flapping(now); len(flapping) != 1 || flapping[0] != "flapping" {
+		t.Errorf("expect only node flapping found, get %v", flapping)
+	}
+	if h.IsFlapping("stable", now) || h.IsFlapping("recovered", now) {
+		t.Errorf("expect stable and recovered nodes not flapping")
+	}
+
+	s := &v1alpha1.KoleQueryStatus{}
+	h.QueryStatus("stable", s)
+	if s.Flapping || len(s.Transitions) != 3 || s.Transitions[0].Reason != TransitionReasonTimeout || s.Transitions[2].To != data.HeartBeatOffline {
+		t.Errorf("unexpected query status %+v", s)
+	}
+
+	h.Record(NodeTransition{Name: "flapping", From: data.HeartBeatOffline, Time: now})
+	if len(h.Transitions("flapping")) != 0 {
+		t.Errorf("expect history dropped with the node")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// NodeReconciler pushes the pods of a node to make its reported pods the desired ones.
+// The nodes are enqueued by their heartbeats, which carry their pods and labels, and by the changes of the
+// KoleDaemonSets and the overrides, and a worker diffs the desired pods with the pods of the latest heartbeat.
+type NodeReconciler struct {
+	queue   workqueue.RateLimitingInterface
+	koleCtl *KoleController
+}
+
+// NewNodeReconciler creates a new NodeReconciler.
+func NewNodeReconciler(koleCtl *KoleController) *NodeReconciler {
+	return &NodeReconciler{
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "nodes"),
+		koleCtl: koleCtl,
+	}
+}
+
+// Enqueue enqueues node name to be reconciled.
+func (r *NodeReconciler) Enqueue(name string) {
+	r.queue.Add(name)
+}
+
+// EnqueueDesired enqueues all the nodes desiring the pod templates.
+func (r *NodeReconciler) EnqueueDesired() {
+	for _, node := range r.koleCtl.Nodes.Snapshot() {
+		if node.Desired {
+			r.queue.Add(node.Name)
+		}
+	}
+}
+
+// Len returns the number of nodes waiting to be reconciled.
+func (r *NodeReconciler) Len() int {
+	return r.queue.Len()
+}
+
+func (r *NodeReconciler) Run(threadiness int, stopCh chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer r.queue.ShutDown()
+
+	klog.Infof("Starting node reconciler")
+
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(r.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	klog.Warningf("Stopping node reconciler")
+}
+
+func (r *NodeReconciler) runWorker() {
+	for r.processNextItem() {
+	}
+}
+
+func (r *NodeReconciler) processNextItem() bool {
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	defer r.queue.Done(key)
+
+	err := r.reconcile(key.(string))
+
+	r.handleErr(err, key)
+	return true
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (r *NodeReconciler) handleErr(err error, key interface{}) {
+	if err == nil {
+		r.queue.Forget(key)
+		return
+	}
+
+	// This controller retries 5 times if something goes wrong. After that, it stops trying.
+	if r.queue.NumRequeues(key) < 5 {
+		klog.Infof("Error reconciling node %v: %v", key, err)
+		r.queue.AddRateLimited(key)
+		return
+	}
+
+	r.queue.Forget(key)
+	utilruntime.HandleError(err)
+	klog.Infof("Dropping node %q out of the queue: %v", key, err)
+}
+
+// DiffPods returns the pods to push to a node reporting pods, which are the desired pods missing or out of date,
+// and the deletions of the reported pods not desired.
+func DiffPods(desiredPods map[string]*data.Pod, pods []*data.HeartBeatPod) []*data.Pod {
+	syncPods := make([]*data.Pod, 0, len(desiredPods))
+	for key, desiredPod := range desiredPods {
+		find := false
+		needUpdate := false
+		for _, hbPod := range pods {
+			if key == hbPod.Key() {
+				find = true
+				if desiredPod.Hash != hbPod.Hash {
+					needUpdate = true
+				}
+				break
+			}
+		}
+		if !find || needUpdate {
+			syncPods = append(syncPods, desiredPod)
+		}
+	}
+	deleteT := metav1.Now()
+	for _, hbPod := range pods {
+		if _, ok := desiredPods[hbPod.Key()]; !ok {
+			syncPods = append(syncPods, &data.Pod{
+				Hash:            hbPod.Hash,
+				Name:            hbPod.Name,
+				NameSpace:       hbPod.NameSpace,
+				DeleteTimeStamp: &deleteT,
+			})
+		}
+	}
+	return syncPods
+}
+
+func (r *NodeReconciler) reconcile(name string) error {
+	// the standbys never push pods, the nodes are reconciled with their heartbeats after taking over
+	if !r.koleCtl.IsLeader() {
+		return nil
+	}
+
+	node := r.koleCtl.Nodes.Get(name)
+	if node == nil || !node.Desired {
+		klog.V(5).Infof("Node %s is not added yet, skip reconciling", name)
+		return nil
+	}
+
+	syncPods := DiffPods(node.DesiredPods(r.koleCtl.PodTemplates.List()), node.HeartBeat.Pods)
+	// the pods whose secrets can not be sealed are retried, the others are pushed anyway
+	var errs []error
+	sealedPods := syncPods[:0]
+	for _, pod := range syncPods {
+		sealed, err := r.koleCtl.sealPod(node, pod)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sealedPods = append(sealedPods, sealed)
+	}
+	if len(sealedPods) != 0 {
+		klog.V(5).Infof("Reconcile node %s, push %d pods", name, len(sealedPods))
+		r.koleCtl.Dispatcher.SendPods(name, sealedPods...)
+	}
+	return utilerrors.NewAggregate(errs)
+}
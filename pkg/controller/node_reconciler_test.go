@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestDiffPods(t *testing.T) {
+	desiredPods := map[string]*data.Pod{
+		"ns-same":    {NameSpace: "ns", Name: "same", Hash: "1"},
+		"ns-updated": {NameSpace: "ns", Name: "updated", Hash: "2"},
+		"ns-missing": {NameSpace: "ns", Name: "missing", Hash: "1"},
+	}
+	pods := []*data.HeartBeatPod{
+		{NameSpace: "ns", Name: "same", Hash: "1"},
+		{NameSpace: "ns", Name: "updated", Hash: "1"},
+		{NameSpace: "ns", Name: "deleted", Hash: "1"},
+	}
+
+	syncPods := DiffPods(desiredPods, pods)
+	var pushed, deleted []string
+	for _, p := range syncPods {
+		if p.DeleteTimeStamp != nil {
+			deleted = append(deleted, p.Key())
+		} else {
+			pushed = append(pushed, p.Key())
+		}
+	}
+	sort.Strings(pushed)
+	if len(pushed) != 2 || pushed[0] != "ns-missing" || pushed[1] != "ns-updated" {
+		t.Errorf("expect pushed pods [ns-missing ns-updated], get %v", pushed)
+	}
+	if len(deleted) != 1 || deleted[0] != "ns-deleted" {
+		t.Errorf("expect deleted pods [ns-deleted], get %v", deleted)
+	}
+
+	if syncPods := DiffPods(map[string]*data.Pod{"ns-same": desiredPods["ns-same"]}, pods[:1]); len(syncPods) != 0 {
+		t.Errorf("expect no pods to push for a node up to date, get %d", len(syncPods))
+	}
+}
+
+func TestDiffPodsOverrides(t *testing.T) {
+	templates := map[string]*data.Pod{
+		"ns-a": {NameSpace: "ns", Name: "a", Hash: "1"},
+		"ns-b": {NameSpace: "ns", Name: "b", Hash: "1"},
+	}
+	pods := []*data.HeartBeatPod{
+		{NameSpace: "ns", Name: "a", Hash: "1"},
+		{NameSpace: "ns", Name: "b", Hash: "1"},
+	}
+	node := &NodeState{Name: "node", Desired: true}
+	if syncPods := DiffPods(node.DesiredPods(templates), pods); len(syncPods) != 0 {
+		t.Fatalf("expect no pods to push for a node following the templates, get %d", len(syncPods))
+	}
+
+	// the node replacing a and excluding b gets the replacement pushed and b deleted
+	a2 := &data.Pod{NameSpace: "ns", Name: "a", Hash: "2"}
+	node = node.WithOverride("ns-a", a2, false).WithOverride("ns-b", nil, false)
+	syncPods := DiffPods(node.DesiredPods(templates), pods)
+	if len(syncPods) != 2 {
+		t.Fatalf("expect 2 pods to push, get %d", len(syncPods))
+	}
+	for _, p := range syncPods {
+		switch p.Key() {
+		case "ns-a":
+			if p != a2 {
+				t.Errorf("expect the override of a pushed, get hash %s", p.Hash)
+			}
+		case "ns-b":
+			if p.DeleteTimeStamp == nil {
+				t.Errorf("expect the excluded pod b deleted")
+			}
+		default:
+			t.Errorf("unexpected pod %s", p.Key())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// TransitionReasons are why the state of a node is changed.
+const (
+	// the state is reported by a heartbeat
+	TransitionReasonHeartBeat = "HeartBeat"
+	// the state is reported by a heartbeat with a new SeqNum, the node is restarted and registers again
+	TransitionReasonRestarted = "Restarted"
+	// the registering node is acked
+	TransitionReasonAcked = "Acked"
+	// the node sends no heartbeat within the timeout
+	TransitionReasonTimeout = "Timeout"
+	// the will of the node is published by the broker
+	TransitionReasonWill = "Will"
+	// the node is removed
+	TransitionReasonRemoved = "Removed"
+	// the pending node is approved
+	TransitionReasonApproved = "Approved"
+)
+
+// NodeTransition is a change of the state of a node, From is empty for a new node and To is empty for a removed node.
+type NodeTransition struct {
+	Name   string
+	From   string
+	To     string
+	Reason string
+	Time   time.Time
+}
+
+// NodeTransitionHandler handles the transitions of the nodes.
+// It is called with the lock of the node held, so it must not block, and must not access the node store.
+type NodeTransitionHandler func(t NodeTransition)
+
+// AddTransitionHandler registers h to receive the state transitions of all the nodes, it must be called before
+// the controller starts consuming heartbeats.
+func (c *KoleController) AddTransitionHandler(h NodeTransitionHandler) {
+	c.transitionHandlers = append(c.transitionHandlers, h)
+}
+
+// onNodeChange is called by the node store whenever the state of a node is replaced.
+func (c *KoleController) onNodeChange(old, new *NodeState) {
+	c.DaemonSetCounters.Update(old, new)
+	c.watchOffline(old, new)
+	c.watchGC(old, new)
+	c.observeTransition(old, new)
+}
+
+// watchOffline postpones the deadline of a registered node with every heartbeat, the other nodes are not watched.
+func (c *KoleController) watchOffline(old, new *NodeState) {
+	if new == nil || new.HeartBeat.State != data.HeartBeatRegisterd {
+		if old != nil {
+			c.OfflineDetector.Forget(old.Name)
+		}
+		return
+	}
+	if old != nil && old.HeartBeat == new.HeartBeat {
+		return
+	}
+	// the nodes loaded from snapshots are given a full timeout to send heartbeats
+	c.OfflineDetector.Touch(new.Name, lastHeartBeat(new))
+}
+
+// observeTransition emits the transition from old to new, if the state of the node is changed.
+func (c *KoleController) observeTransition(old, new *NodeState) {
+	var t NodeTransition
+	if old != nil {
+		t.Name, t.From = old.Name, old.HeartBeat.State
+	}
+	t.Reason = TransitionReasonRemoved
+	if new != nil {
+		t.Name, t.To, t.Reason = new.Name, new.HeartBeat.State, new.Reason
+	}
+	if t.From == t.To && old != nil && new != nil {
+		return
+	}
+	t.Time = time.Now()
+	for _, h := range c.transitionHandlers {
+		h(t)
+	}
+}
+
+// markOffline sets node name offline if it sends no heartbeat within the timeout since it is registered.
+func (c *KoleController) markOffline(name string) {
+	c.Nodes.Update(name, func(old *NodeState) *NodeState {
+		if old == nil || old.HeartBeat.State != data.HeartBeatRegisterd {
+			return old
+		}
+		// a heartbeat may be accepted after the deadline expires
+		if subTime := time.Now().Unix() - old.HeartBeat.LasterTimeStamp; subTime < c.HeartBeatTimeOut {
+			return old
+		}
+		klog.V(5).Infof("Nodename %s set offline, no heartbeat in %d s", name, c.HeartBeatTimeOut)
+		return old.WithState(data.HeartBeatOffline, TransitionReasonTimeout)
+	})
+}
+
+// ConsumeWill sets the node offline at once when the broker publishes its will, which means the node is disconnected.
+// The will is registered when the node connects, so it is ignored if the node is restarted since then,
+// and any heartbeat accepted later brings the node back.
+func (c *KoleController) ConsumeWill(will *data.NodeWill) {
+	if !c.Shard.Owns(will.Name) {
+		return
+	}
+	c.Nodes.Update(will.Name, func(old *NodeState) *NodeState {
+		if old == nil || old.HeartBeat.State != data.HeartBeatRegisterd {
+			return old
+		}
+		if will.SeqNum < old.Filter.SeqNum {
+			klog.V(4).Infof("Skip the will of node %s, seq %d is less than cached seq %d", will.Name, will.SeqNum, old.Filter.SeqNum)
+			return old
+		}
+		klog.V(4).Infof("Nodename %s set offline by its will", will.Name)
+		return old.WithState(data.HeartBeatOffline, TransitionReasonWill)
+	})
+}
+
+// countTransition keeps the number of nodes in every state.
+func countTransition(t NodeTransition) {
+	if t.From != "" {
+		nodesByState.WithLabelValues(t.From).Dec()
+	}
+	if t.To != "" {
+		nodesByState.WithLabelValues(t.To).Inc()
+	}
+	if t.From != "" && t.To != "" {
+		nodeTransitions.WithLabelValues(t.From, t.To, t.Reason).Inc()
+	}
+}
+
+// queryStatusTransition keeps the node status queried by KoleQuery up to date between snapshots.
+func (c *KoleController) queryStatusTransition(t NodeTransition) {
+	if t.To == "" {
+		c.QueryNodeStatusCache.Remove([]string{t.Name})
+		return
+	}
+	c.QueryNodeStatusCache.Set(t.Name, &v1alpha1.KoleQueryStatus{
+		ObjectStatus: t.To,
+		ObjectName:   t.Name,
+		ObjectType:   v1alpha1.KoleObjectNode,
+	})
+}
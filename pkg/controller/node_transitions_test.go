@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func TestConsumeWill(t *testing.T) {
+	c := &KoleController{
+		Nodes: NewNodeStore(),
+	}
+	c.NodeReconciler = NewNodeReconciler(c)
+	var transitions []NodeTransition
+	c.AddTransitionHandler(func(t NodeTransition) {
+		transitions = append(transitions, t)
+	})
+	c.Nodes.OnChange = c.observeTransition
+
+	c.Nodes.Update("node", func(old *NodeState) *NodeState {
+		return NewNodeStateFromHeartBeat(&data.HeartBeat{Name: "node", SeqNum: 2, TimeStamp: 10, State: data.HeartBeatRegisterd})
+	})
+	state := func() string {
+		return c.Nodes.Get("node").HeartBeat.State
+	}
+
+	// the will registered before the node restarts
+	c.ConsumeWill(&data.NodeWill{Name: "node", SeqNum: 1})
+	if state() != data.HeartBeatRegisterd {
+		t.Errorf("expect stale will ignored, get state %s", state())
+	}
+
+	c.ConsumeWill(&data.NodeWill{Name: "node", SeqNum: 2})
+	if state() != data.HeartBeatOffline {
+		t.Errorf("expect node offline by its will, get state %s", state())
+	}
+
+	if !c.ConsumeSingleHeartBeat(&data.HeartBeat{Name: "node", SeqNum: 2, TimeStamp: 11, State: data.HeartBeatRegisterd}) {
+		t.Errorf("expect heartbeat accepted after the will")
+	}
+	if state() != data.HeartBeatRegisterd {
+		t.Errorf("expect node back by the heartbeat, get state %s", state())
+	}
+
+	expect := []NodeTransition{
+		{Name: "node", To: data.HeartBeatRegisterd, Reason: TransitionReasonHeartBeat},
+		{Name: "node", From: data.HeartBeatRegisterd, To: data.HeartBeatOffline, Reason: TransitionReasonWill},
+		{Name: "node", From: data.HeartBeatOffline, To: data.HeartBeatRegisterd, Reason: TransitionReasonHeartBeat},
+	}
+	if len(transitions) != len(expect) {
+		t.Fatalf("expect %d transitions, get %d", len(expect), len(transitions))
+	}
+	for i := range expect {
+		if transitions[i].Name != expect[i].Name || transitions[i].From != expect[i].From || transitions[i].To != expect[i].To ||
+			transitions[i].Reason != expect[i].Reason {
+			t.Errorf("expect transition %v, get %v", expect[i], transitions[i])
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+type deadline struct {
+	name string
+	at   time.Time
+	// the index in the heap, maintained by heap.Interface
+	index int
+}
+
+// deadlineHeap is a min heap of deadlines ordered by time.
+type deadlineHeap []*deadline
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	d := x.(*deadline)
+	d.index = len(*h)
+	*h = append(*h, d)
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	d.index = -1
+	return d
+}
+
+// OfflineDetector fires a callback once a node is not touched within the timeout, such as a node sending no heartbeat,
+// or a node staying offline.
+// The deadlines of the nodes are kept in a heap, so a heartbeat costs O(log n) and
+// only the expired nodes are visited, instead of scanning all the nodes periodically.
+type OfflineDetector struct {
+	lock      sync.Mutex
+	deadlines map[string]*deadline
+	heap      deadlineHeap
+	timeout   time.Duration
+	// notifies Run that the earliest deadline is changed
+	wakeup   chan struct{}
+	onExpire func(name string)
+}
+
+// NewOfflineDetector creates an OfflineDetector calling onExpire with the nodes without heartbeats within timeout.
+func NewOfflineDetector(timeout time.Duration, onExpire func(name string)) *OfflineDetector {
+	return &OfflineDetector{
+		deadlines: make(map[string]*deadline),
+		timeout:   timeout,
+		wakeup:    make(chan struct{}, 1),
+		onExpire:  onExpire,
+	}
+}
+
+// Touch records that node name sent a heartbeat at last, and its deadline is postponed to last + timeout.
+func (d *OfflineDetector) Touch(name string, last time.Time) {
+	at := last.Add(d.timeout)
+
+	d.lock.Lock()
+	if dl, ok := d.deadlines[name]; ok {
+		dl.at = at
+		heap.Fix(&d.heap, dl.index)
+	} else {
+		dl = &deadline{name: name, at: at}
+		d.deadlines[name] = dl
+		heap.Push(&d.heap, dl)
+	}
+	earliest := d.heap[0].name == name
+	d.lock.Unlock()
+
+	if earliest {
+		d.notify()
+	}
+}
+
+// Forget stops watching node name.
+func (d *OfflineDetector) Forget(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if dl, ok := d.deadlines[name]; ok {
+		heap.Remove(&d.heap, dl.index)
+		delete(d.deadlines, name)
+	}
+}
+
+// Len returns the number of watched nodes.
+func (d *OfflineDetector) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.deadlines)
+}
+
+func (d *OfflineDetector) notify() {
+	select {
+	case d.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// expire pops the nodes whose deadlines are not after now, and returns how long to wait for the next deadline,
+// a negative duration if no node is watched.
+func (d *OfflineDetector) expire(now time.Time) ([]string, time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var expired []string
+	for len(d.heap) != 0 && !d.heap[0].at.After(now) {
+		dl := heap.Pop(&d.heap).(*deadline)
+		delete(d.deadlines, dl.name)
+		expired = append(expired, dl.name)
+	}
+	if len(d.heap) == 0 {
+		return expired, -1
+	}
+	return expired, d.heap[0].at.Sub(now)
+}
+
+// Run fires the expired deadlines until stop is closed. An expired node is not watched any more,
+// until it is touched by a new heartbeat.
+func (d *OfflineDetector) Run(stop <-chan struct{}) {
+	klog.Infof("Starting offline detector, timeout %v", d.timeout)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		expired, wait := d.expire(time.Now())
+		for _, name := range expired {
+			d.onExpire(name)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-stop:
+			klog.Warningf("Stopping offline detector")
+			return
+		case <-d.wakeup:
+		case <-timeout:
+		}
+	}
+}
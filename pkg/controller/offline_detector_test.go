@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOfflineDetector(t *testing.T) {
+	expired := make(chan string, 10)
+	d := NewOfflineDetector(100*time.Millisecond, func(name string) {
+		expired <- name
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+
+	start := time.Now()
+	d.Touch("a", start)
+	d.Touch("b", start)
+	d.Touch("c", start)
+	d.Forget("c")
+	// a sends a heartbeat later, it expires after b
+	d.Touch("a", start.Add(200*time.Millisecond))
+	// an earlier deadline wakes up the detector
+	d.Touch("d", start.Add(-time.Second))
+
+	for _, expect := range []string{"d", "b", "a"} {
+		select {
+		case name := <-expired:
+			if name != expect {
+				t.Fatalf("expect %s expired, get %s", expect, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s expired, timeout", expect)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expect a expired after its deadline, elapsed %v", elapsed)
+	}
+	select {
+	case name := <-expired:
+		t.Errorf("expect no more expired nodes, get %s", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if d.Len() != 0 {
+		t.Errorf("expect no node watched, get %d", d.Len())
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// PodSecretLabel must be set to "true" on the Secrets referred by the pods, the other Secrets are never sent to the nodes.
+const PodSecretLabel = "lite.openyurt.io/pod-secret"
+
+// PodSecrets resolves the Secrets referred by the env of the pods with the cached pod secrets.
+type PodSecrets struct {
+	lister corelisters.SecretLister
+}
+
+// NewPodSecrets creates PodSecrets looking up the pod secrets by lister.
+func NewPodSecrets(lister corelisters.SecretLister) *PodSecrets {
+	return &PodSecrets{
+		lister: lister,
+	}
+}
+
+// NewPodSecretInformerFactory creates the informer factory caching only the pod secrets.
+func NewPodSecretInformerFactory(client kubernetes.Interface) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.SelectorFromSet(labels.Set{PodSecretLabel: "true"}).String()
+		}))
+}
+
+// hasSecretRefs returns true if the env of spec refers to any Secret.
+func hasSecretRefs(spec *v1alpha1.PodSpec) bool {
+	if spec == nil {
+		return false
+	}
+	for _, env := range spec.Env {
+		if env.SecretKeyRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PodSecrets) get(namespace, name string) (*corev1.Secret, error) {
+	secret, err := s.lister.Secrets(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("secret %s/%s is not found, or it is not labeled with %s=true", namespace, name, PodSecretLabel)
+	}
+	return secret, err
+}
+
+// Value returns the value selected by ref in namespace.
+func (s *PodSecrets) Value(namespace string, ref *v1alpha1.SecretKeySelector) ([]byte, error) {
+	secret, err := s.get(namespace, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s is not found in secret %s/%s", ref.Key, namespace, ref.Name)
+	}
+	return value, nil
+}
+
+// Hash returns the hash of the pods of spec in namespace, which changes with the Secrets referred by spec as well,
+// so the pods are pushed again once the values of their Secrets change.
+func (s *PodSecrets) Hash(namespace string, spec *v1alpha1.PodSpec) (string, error) {
+	hash, err := Md5PodSpec(spec)
+	if err != nil || s == nil || !hasSecretRefs(spec) {
+		return hash, err
+	}
+	m := md5.New()
+	m.Write([]byte(hash))
+	for _, env := range spec.Env {
+		if env.SecretKeyRef == nil {
+			continue
+		}
+		// a missing secret is found by the sealing, and it is retried until the secret is created
+		if secret, err := s.get(namespace, env.SecretKeyRef.Name); err == nil {
+			m.Write([]byte(secret.ResourceVersion))
+		}
+		m.Write([]byte{0})
+	}
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+// refersTo returns true if the env of spec refers to the Secret name.
+func refersTo(spec *v1alpha1.PodSpec, name string) bool {
+	if spec == nil {
+		return false
+	}
+	for _, env := range spec.Env {
+		if env.SecretKeyRef != nil && env.SecretKeyRef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sealPod returns pod with the values of its Secrets sealed to node. The pod is returned as it is
+// if it refers to no Secret, otherwise it is copied since the pods are shared by the nodes.
+func (c *KoleController) sealPod(node *NodeState, pod *data.Pod) (*data.Pod, error) {
+	if pod.DeleteTimeStamp != nil || !hasSecretRefs(pod.Spec) {
+		return pod, nil
+	}
+	if c.PodSecrets == nil {
+		return nil, fmt.Errorf("pod %s refers to secrets, but pod secrets are not enabled", pod.Key())
+	}
+	// the encryption key is trusted only if the node proves its name by the signatures
+	if !node.HeartBeat.Signed {
+		return nil, fmt.Errorf("heartbeat of node %s is not signed, refuse to seal the secrets of pod %s", node.Name, pod.Key())
+	}
+	if len(node.HeartBeat.EncryptionKey) == 0 {
+		return nil, fmt.Errorf("node %s reports no encryption key to receive the secrets of pod %s", node.Name, pod.Key())
+	}
+	key, err := data.ParseEncryptionKey(node.HeartBeat.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key of node %s: %v", node.Name, err)
+	}
+
+	sealed := *pod
+	sealed.SealedEnv = make(map[string]*data.SealedValue)
+	for _, env := range pod.Spec.Env {
+		if env.SecretKeyRef == nil {
+			continue
+		}
+		value, err := c.PodSecrets.Value(pod.NameSpace, env.SecretKeyRef)
+		if err != nil {
+			return nil, fmt.Errorf("resolve env %s of pod %s error %v", env.Name, pod.Key(), err)
+		}
+		if sealed.SealedEnv[env.Name], err = data.SealValue(key, value, data.SealedEnvAAD(node.Name, pod.Key(), env.Name)); err != nil {
+			return nil, err
+		}
+	}
+	return &sealed, nil
+}
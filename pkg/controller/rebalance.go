@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sort"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// Rebalance is called when the shards change. The nodes not owned by the shard any more are dropped,
+// and the owned nodes missing in the caches are adopted from the latest snapshots of the other shards.
+// All the KoleDaemonSets and KoleQueries are synced again to update their status.
+func (c *KoleController) Rebalance(ring *HashRing) {
+	start := time.Now()
+	klog.Infof("Rebalance start, shard %s, shards %v", c.Shard.Name(), ring.Members())
+
+	dropped := make([]string, 0, 1024)
+	for _, node := range c.Nodes.Snapshot() {
+		if !c.Shard.Owns(node.Name) {
+			dropped = append(dropped, node.Name)
+		}
+	}
+	c.RemoveNodes(dropped)
+
+	adopted, err := c.adoptNodes()
+	if err != nil {
+		klog.Errorf("Rebalance: adopt nodes error %v", err)
+	}
+
+	c.KoleDaemonSetController.EnqueueAll()
+	c.KoleQueryController.EnqueueAll()
+	klog.Infof("Rebalance end, drop %d nodes, adopt %d nodes, use %v", len(dropped), adopted, time.Since(start))
+}
+
+// RemoveNodes drops all the cached states of nodeNames.
+func (c *KoleController) RemoveNodes(nodeNames []string) {
+	if len(nodeNames) == 0 {
+		return
+	}
+	c.Nodes.Remove(nodeNames)
+	c.QueryNodeStatusCache.Remove(nodeNames)
+	for _, name := range nodeNames {
+		c.Dispatcher.Forget(name)
+	}
+}
+
+// adoptNodes loads the owned nodes missing in the caches from the latest complete generation of every other shard,
+// the newer generations are loaded first so a node moved between shards is adopted with its latest state.
+// The snapshots saved before sharding is enabled are loaded as the generations of an empty shard.
+func (c *KoleController) adoptNodes() (int, error) {
+	shards, err := c.SnapshotStore.ListShards()
+	if err != nil {
+		return 0, err
+	}
+
+	type shardGeneration struct {
+		shard string
+		g     *SnapshotGeneration
+	}
+	latest := make([]shardGeneration, 0, len(shards))
+	for shard, generations := range shards {
+		if shard == c.Shard.Name() {
+			continue
+		}
+		g, _ := SelectSnapshotGeneration(generations, -1)
+		if g == nil {
+			continue
+		}
+		latest = append(latest, shardGeneration{shard: shard, g: g})
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].g.Timestamp > latest[j].g.Timestamp
+	})
+
+	var adopted int
+	for _, l := range latest {
+		n, err := c.adoptGeneration(c.SnapshotStore.ForShard(l.shard), l.g)
+		if err != nil {
+			klog.Errorf("Adopt nodes from snapshot generation %d of shard %q error %v", l.g.Index, l.shard, err)
+			continue
+		}
+		klog.Infof("Adopt %d nodes from snapshot generation %d of shard %q", n, l.g.Index, l.shard)
+		adopted += n
+	}
+	return adopted, nil
+}
+
+func (c *KoleController) adoptGeneration(store *SnapshotStore, g *SnapshotGeneration) (int, error) {
+	process, err := c.SnapshotProcesser(g.Codec)
+	if err != nil {
+		return 0, err
+	}
+	reader, err := AsStreamProcesser(process).NewReader(store.NewReader(g))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	var adopted int
+	err = DecodeHeartBeats(reader, func(name string, hb *data.HeartBeat) error {
+		if !c.Shard.Owns(name) || c.Nodes.Has(name) {
+			return nil
+		}
+		// the node is consumed as if its last heartbeat is received again, and its desired pods are pushed.
+		// It is approved by the other shard, or it is still pending.
+		c.consumeHeartBeat(hb, true)
+		adopted++
+		return nil
+	})
+	return adopted, err
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// RegistrationAdmitter limits the rate of the registrations, so a mass power-on of the nodes is admitted gradually.
+// The rejected registrations are told when to retry as if they wait in a queue drained at the admission rate,
+// so the retries are spread over time instead of coming back together.
+type RegistrationAdmitter struct {
+	limiter *rate.Limiter
+	qps     float64
+
+	lock sync.Mutex
+	// the rejected registrations expected to retry, decreased at the admission rate
+	waiting float64
+	last    time.Time
+}
+
+// NewRegistrationAdmitter creates a RegistrationAdmitter admitting qps registrations per second with burst.
+func NewRegistrationAdmitter(qps float64, burst int) *RegistrationAdmitter {
+	return &RegistrationAdmitter{
+		limiter: rate.NewLimiter(rate.Limit(qps), burst),
+		qps:     qps,
+	}
+}
+
+// Admit returns true if a registration is admitted at now, otherwise it returns how long to wait before retrying.
+func (a *RegistrationAdmitter) Admit(now time.Time) (bool, time.Duration) {
+	if a.limiter.AllowN(now, 1) {
+		registrationsAdmitted.Inc()
+		return true, 0
+	}
+	registrationsRejected.Inc()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.last.IsZero() {
+		a.waiting = math.Max(0, a.waiting-now.Sub(a.last).Seconds()*a.qps)
+	}
+	a.last = now
+	a.waiting++
+	retryAfter := time.Duration(a.waiting / a.qps * float64(time.Second))
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return false, retryAfter
+}
+
+// isRegistration returns true if hb registers a node, which is new, or registers again after it is registered.
+// The registering heartbeats resent by a node admitted before are not registrations any more.
+func isRegistration(old *NodeState, hb *data.HeartBeat) bool {
+	return hb.State == data.HeartBeatRegistering && (old == nil || old.HeartBeat.State != data.HeartBeatRegistering)
+}
+
+// rejectRegistration tells the node when to retry its registration.
+func (c *KoleController) rejectRegistration(hb *data.HeartBeat, retryAfter time.Duration) {
+	klog.V(4).Infof("Reject registering of node %s, retry after %v", hb.Name, retryAfter)
+	// the acks are sent by the leader only
+	if !c.IsLeader() {
+		return
+	}
+	c.Dispatcher.SendAck(&data.HeartBeatACK{
+		Identifier: hb.Identifier,
+		Registerd:  false,
+		RetryAfter: int64(math.Ceil(retryAfter.Seconds())),
+		NodeName:   hb.Name,
+	})
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistrationAdmitter(t *testing.T) {
+	a := NewRegistrationAdmitter(10, 5)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if ok, _ := a.Admit(now); !ok {
+			t.Fatalf("expect registration %d admitted by the burst", i)
+		}
+	}
+	// the rejected registrations are spread at the admission rate
+	var last time.Duration
+	for i := 0; i < 30; i++ {
+		ok, retryAfter := a.Admit(now)
+		if ok {
+			t.Fatalf("expect registration %d rejected", i)
+		}
+		if retryAfter < time.Second || retryAfter < last {
+			t.Fatalf("expect increasing retry after at least 1s, get %v after %v", retryAfter, last)
+		}
+		last = retryAfter
+	}
+	if last != 3*time.Second {
+		t.Errorf("expect the last of 30 rejected registrations retry after 3s, get %v", last)
+	}
+
+	// the waiting registrations are drained as time goes by
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 5; i++ {
+		if ok, _ := a.Admit(now); !ok {
+			t.Fatalf("expect registration %d admitted after the burst is refilled", i)
+		}
+	}
+	if _, retryAfter := a.Admit(now); retryAfter != time.Second {
+		t.Errorf("expect retry after 1s once the rejected registrations are drained, get %v", retryAfter)
+	}
+}
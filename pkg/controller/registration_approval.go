@@ -0,0 +1,165 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// Approval policies, which label the approved registrations metric.
+const (
+	ApprovedByBootstrapToken = "bootstrap-token"
+	ApprovedByLabels         = "labels"
+	ApprovedByAddress        = "address"
+	ApprovedManually         = "manual"
+)
+
+// pendingRetryAfter is the time a pending node waits before registering again.
+const pendingRetryAfter = 30 * time.Second
+
+// RegistrationApprover approves the registering nodes by the auto-approval policies, the nodes not approved stay
+// pending until they are approved manually by KoleQueryApprove queries. The labels and the addresses are reported
+// by the nodes themselves, so they are only trustworthy if the nodes reaching the broker are trusted.
+type RegistrationApprover struct {
+	// approve the nodes presenting a valid bootstrap token, nil if the tokens are not used
+	Tokens *BootstrapTokens
+	// approve the nodes with matched labels, nil if the labels are not used
+	Selector labels.Selector
+	// approve the nodes with an internal address in any of the ranges
+	CIDRs []*net.IPNet
+}
+
+// NewRegistrationApprover creates a RegistrationApprover with the label selector and the address ranges,
+// the policy is not used if it is empty.
+func NewRegistrationApprover(tokens *BootstrapTokens, selector string, cidrs []string) (*RegistrationApprover, error) {
+	a := &RegistrationApprover{
+		Tokens: tokens,
+	}
+	if len(selector) != 0 {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval label selector %q: %v", selector, err)
+		}
+		a.Selector = s
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval address range %q: %v", cidr, err)
+		}
+		a.CIDRs = append(a.CIDRs, ipNet)
+	}
+	return a, nil
+}
+
+// Approve returns the policy approving the node sending hb with token at now, empty if it is not approved.
+func (a *RegistrationApprover) Approve(hb *data.HeartBeat, token string, now time.Time) string {
+	if a.Tokens != nil && len(token) != 0 {
+		if err := a.Tokens.Validate(token, now); err != nil {
+			klog.V(4).Infof("Node %s presents an invalid bootstrap token: %v", hb.Name, err)
+		} else {
+			return ApprovedByBootstrapToken
+		}
+	}
+	if a.Selector != nil && a.Selector.Matches(labels.Set(hb.Labels)) {
+		return ApprovedByLabels
+	}
+	if len(a.CIDRs) != 0 && hb.Status != nil {
+		for _, addr := range hb.Status.Addresses {
+			if addr == nil || addr.Type != data.AddressTypeInternal {
+				continue
+			}
+			ip := net.ParseIP(addr.Address)
+			for _, ipNet := range a.CIDRs {
+				if ip != nil && ipNet.Contains(ip) {
+					return ApprovedByAddress
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// needsApproval returns true if the node sending hb must be approved before it is registered,
+// which is a registering node not cached or pending. The nodes adopted from snapshots are not approved again.
+func needsApproval(old *NodeState, hb *data.HeartBeat) bool {
+	return hb.State == data.HeartBeatRegistering && (old == nil || old.HeartBeat.State == data.HeartBeatPending)
+}
+
+// needsReregistration returns true if the node sending hb skips registering, while it is not cached or pending,
+// e.g. it is adopted from another shard, it has to register again to be approved.
+func needsReregistration(old *NodeState, hb *data.HeartBeat) bool {
+	return hb.State != data.HeartBeatRegistering && (old == nil || old.HeartBeat.State == data.HeartBeatPending)
+}
+
+// holdRegistration tells the registering node to wait for approval.
+func (c *KoleController) holdRegistration(hb *data.HeartBeat) {
+	klog.V(4).Infof("Node %s is pending for approval", hb.Name)
+	// the acks are sent by the leader only
+	if !c.IsLeader() || hb.State != data.HeartBeatRegistering {
+		return
+	}
+	c.Dispatcher.SendAck(&data.HeartBeatACK{
+		Identifier: hb.Identifier,
+		Registerd:  false,
+		RetryAfter: int64(pendingRetryAfter.Seconds()),
+		Pending:    true,
+		NodeName:   hb.Name,
+	})
+}
+
+// requestReregistration tells the node skipping registering to register again.
+func (c *KoleController) requestReregistration(hb *data.HeartBeat) {
+	klog.V(4).Infof("Node %s is not approved, ask it to register again", hb.Name)
+	// the acks are sent by the leader only
+	if !c.IsLeader() {
+		return
+	}
+	c.Dispatcher.SendAck(&data.HeartBeatACK{
+		Identifier: hb.Identifier,
+		Registerd:  false,
+		Reregister: true,
+		NodeName:   hb.Name,
+	})
+}
+
+// ApproveNode approves the pending node name manually, and returns true if it is approved.
+// The node is acked by the next snapshot as the other registering nodes.
+func (c *KoleController) ApproveNode(name string) bool {
+	var approved bool
+	c.Nodes.Update(name, func(old *NodeState) *NodeState {
+		if old == nil || old.HeartBeat.State != data.HeartBeatPending {
+			return old
+		}
+		approved = true
+		return old.WithState(data.HeartBeatRegistering, TransitionReasonApproved)
+	})
+	if !approved {
+		return false
+	}
+
+	klog.Infof("Node %s is approved manually", name)
+	registrationsApproved.WithLabelValues(ApprovedManually).Inc()
+	c.KoleDaemonSetController.AddHost(name)
+	return true
+}
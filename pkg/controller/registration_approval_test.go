@@ -0,0 +1,197 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/client/clientset/versioned/fake"
+	"github.com/openyurtio/kole/pkg/client/informers/externalversions"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+func newBootstrapTokenSecret(id, secret, expiration string) *corev1.Secret {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kole", Name: BootstrapTokenSecretPrefix + id},
+		Type:       BootstrapTokenSecretType,
+		Data: map[string][]byte{
+			BootstrapTokenIDKey:     []byte(id),
+			BootstrapTokenSecretKey: []byte(secret),
+		},
+	}
+	if len(expiration) != 0 {
+		s.Data[BootstrapTokenExpirationKey] = []byte(expiration)
+	}
+	return s
+}
+
+func TestRegistrationApproval(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	indexer.Add(newBootstrapTokenSecret("abcdef", "0123456789abcdef", ""))
+	indexer.Add(newBootstrapTokenSecret("expire", "0123456789abcdef", time.Now().Add(-time.Minute).Format(time.RFC3339)))
+	tokens := NewBootstrapTokens(corelisters.NewSecretLister(indexer).Secrets("kole"))
+
+	approver, err := NewRegistrationApprover(tokens, "region=hangzhou", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("new approver error %v", err)
+	}
+	c := &KoleController{
+		Nodes:             NewNodeStore(),
+		DaemonSetCounters: NewDaemonSetCounters(),
+		QueryNodeStatusCache: &QueryNodeStatusCache{
+			RWMutex:      &sync.RWMutex{},
+			NameToStatus: make(map[string]*v1alpha1.KoleQueryStatus),
+		},
+		Dispatcher:           NewDispatcher(DispatcherOptions{Workers: 1, QPS: 100, Burst: 100, NodeQPS: 100, NodeBurst: 100}),
+		HeartBeatTimeOut:     300,
+		PodTemplates:         NewPodTemplates(),
+		RegistrationApprover: approver,
+		leading:              1,
+	}
+	c.NodeReconciler = NewNodeReconciler(c)
+	c.OfflineDetector = NewOfflineDetector(time.Hour, c.markOffline)
+	c.NodeGC = NewOfflineDetector(time.Hour, c.collectNode)
+	c.Nodes.OnChange = c.onNodeChange
+	crdclient := fake.NewSimpleClientset()
+	factory := externalversions.NewSharedInformerFactory(crdclient, 0)
+	c.KoleDaemonSetController, _ = NewKoleDaemonSetController(crdclient, factory.Lite().V1alpha1().KoleDaemonSets(), c)
+
+	var reasons []string
+	c.AddTransitionHandler(func(t NodeTransition) {
+		reasons = append(reasons, t.Reason)
+	})
+
+	registering := func(name, token string) *data.HeartBeat {
+		return &data.HeartBeat{Name: name, SeqNum: 1, TimeStamp: time.Now().UnixNano(), State: data.HeartBeatRegistering, BootstrapToken: token}
+	}
+	heartbeats := map[string]*data.HeartBeat{
+		"unknown":      registering("unknown", ""),
+		"token":        registering("token", "abcdef.0123456789abcdef"),
+		"wrong-secret": registering("wrong-secret", "abcdef.0123456789abcdee"),
+		"expired":      registering("expired", "expire.0123456789abcdef"),
+		"labels":       registering("labels", ""),
+		"address":      registering("address", ""),
+		"registered":   {Name: "registered", SeqNum: 1, TimeStamp: 1, State: data.HeartBeatRegisterd},
+	}
+	heartbeats["labels"].Labels = map[string]string{"region": "hangzhou"}
+	heartbeats["address"].Status = &data.HeartBeatStatus{Addresses: []*data.Address{{Address: "10.1.2.3", Type: data.AddressTypeInternal}}}
+	for _, hb := range heartbeats {
+		// a node not cached may skip registering, it is asked to register again
+		if accepted := c.ConsumeSingleHeartBeat(hb); accepted != (hb.State == data.HeartBeatRegistering) {
+			t.Errorf("expect heartbeat of %s accepted %v, get %v", hb.Name, hb.State == data.HeartBeatRegistering, accepted)
+		}
+	}
+	if c.Nodes.Has("registered") {
+		t.Errorf("expect the node skipping registering not cached")
+	}
+
+	expectState := func(name, state string) {
+		t.Helper()
+		node := c.Nodes.Get(name)
+		if node == nil {
+			t.Fatalf("expect node %s cached", name)
+		}
+		if node.HeartBeat.State != state {
+			t.Errorf("expect node %s %s, get %s", name, state, node.HeartBeat.State)
+		}
+		if node.Desired != (state != data.HeartBeatPending) {
+			t.Errorf("expect node %s desired %v", name, state != data.HeartBeatPending)
+		}
+		if len(node.HeartBeat.BootstrapToken) != 0 {
+			t.Errorf("expect the bootstrap token of node %s never cached", name)
+		}
+	}
+	for name, state := range map[string]string{
+		"unknown":      data.HeartBeatPending,
+		"token":        data.HeartBeatRegistering,
+		"wrong-secret": data.HeartBeatPending,
+		"expired":      data.HeartBeatPending,
+		"labels":       data.HeartBeatRegistering,
+		"address":      data.HeartBeatRegistering,
+	} {
+		expectState(name, state)
+	}
+	if c.NodeGC.Len() != 3 {
+		t.Errorf("expect 3 pending nodes watched by gc, get %d", c.NodeGC.Len())
+	}
+
+	// the pending nodes are approved by a valid token later, or manually
+	reasons = nil
+	hb := registering("expired", "abcdef.0123456789abcdef")
+	hb.TimeStamp++
+	c.ConsumeSingleHeartBeat(hb)
+	expectState("expired", data.HeartBeatRegistering)
+	if !c.ApproveNode("unknown") || c.ApproveNode("token") || c.ApproveNode("missing") {
+		t.Errorf("expect only the pending node approved")
+	}
+	expectState("unknown", data.HeartBeatRegistering)
+	if len(reasons) != 2 || reasons[0] != TransitionReasonApproved || reasons[1] != TransitionReasonApproved {
+		t.Errorf("expect 2 transitions approved, get %v", reasons)
+	}
+	if c.NodeGC.Len() != 1 {
+		t.Errorf("expect 1 pending node watched by gc, get %d", c.NodeGC.Len())
+	}
+
+	// the registered heartbeats of an approved node are accepted
+	registered := registering("unknown", "")
+	registered.State = data.HeartBeatRegisterd
+	if !c.ConsumeSingleHeartBeat(registered) {
+		t.Errorf("expect the registered heartbeat of the approved node accepted")
+	}
+	expectState("unknown", data.HeartBeatRegisterd)
+
+	// the nodes adopted from snapshots are approved before, unless they are pending
+	c.consumeHeartBeat(&data.HeartBeat{Name: "adopted", SeqNum: 1, TimeStamp: 1, State: data.HeartBeatRegisterd}, true)
+	c.consumeHeartBeat(&data.HeartBeat{Name: "adopted-pending", SeqNum: 1, TimeStamp: 1, State: data.HeartBeatPending}, true)
+	expectState("adopted", data.HeartBeatRegisterd)
+	expectState("adopted-pending", data.HeartBeatPending)
+
+	// the pending nodes not registering any more are collected
+	c.Nodes.Update("wrong-secret", func(old *NodeState) *NodeState {
+		n := old.Copy()
+		hb := *old.HeartBeat
+		hb.LasterTimeStamp = time.Now().Add(-2 * time.Hour).Unix()
+		n.HeartBeat = &hb
+		return n
+	})
+	c.collectNode("wrong-secret")
+	c.collectNode("adopted-pending")
+	if c.Nodes.Has("wrong-secret") || !c.Nodes.Has("adopted-pending") {
+		t.Errorf("expect only the pending node without heartbeats collected")
+	}
+}
+
+func TestParseBootstrapToken(t *testing.T) {
+	for token, valid := range map[string]bool{
+		"abcdef.0123456789abcdef":  true,
+		"abcdef.0123456789abcde":   false,
+		"ABCDEF.0123456789abcdef":  false,
+		"abcdef-0123456789abcdef":  false,
+		"abcdef.0123456789abcdef.": false,
+	} {
+		if _, _, err := ParseBootstrapToken(token); (err == nil) != valid {
+			t.Errorf("expect token %q valid %v, get error %v", token, valid, err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ShardLeasePrefix is the name prefix of the shard leases
+	ShardLeasePrefix = "kole-shard-"
+	// ShardLeaseLabel labels all the shard leases
+	ShardLeaseLabel = "kole-shard"
+)
+
+// ShardManager keeps the lease of a controller shard, and builds the hash ring of all the shards
+// whose leases are not expired. Every shard owns the node names mapped to it by the ring.
+type ShardManager struct {
+	client        kubernetes.Interface
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	// ReleaseOnStop deletes the lease when the manager stops, it is kept if the standbys of the shard renew it too
+	ReleaseOnStop bool
+
+	lock     sync.RWMutex
+	ring     *HashRing
+	handlers []func(ring *HashRing)
+	changed  chan struct{}
+}
+
+// NewShardManager creates the manager of shard name, whose lease is kept in namespace.
+func NewShardManager(client kubernetes.Interface, namespace, name string, leaseDuration time.Duration) *ShardManager {
+	return &ShardManager{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		leaseDuration: leaseDuration,
+		ReleaseOnStop: true,
+		changed:       make(chan struct{}, 1),
+	}
+}
+
+// Name returns the name of the shard, it is empty if m is nil.
+func (m *ShardManager) Name() string {
+	if m == nil {
+		return ""
+	}
+	return m.name
+}
+
+// Ring returns the current hash ring of the shards.
+func (m *ShardManager) Ring() *HashRing {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.ring
+}
+
+// Owns returns true if the node is owned by the shard, a nil manager owns all the nodes.
+func (m *ShardManager) Owns(nodeName string) bool {
+	if m == nil {
+		return true
+	}
+	return m.Ring().Owner(nodeName) == m.name
+}
+
+// Members returns the names of the live shards.
+func (m *ShardManager) Members() []string {
+	return m.Ring().Members()
+}
+
+// AddHandler registers f, which is called with the new ring every time the shards change.
+// The handlers are called one by one out of the lease loop, and only the latest ring is passed if the shards change again meanwhile.
+func (m *ShardManager) AddHandler(f func(ring *HashRing)) {
+	m.lock.Lock()
+	m.handlers = append(m.handlers, f)
+	m.lock.Unlock()
+}
+
+// Join takes the lease of the shard and loads the live shards.
+func (m *ShardManager) Join() error {
+	if err := m.renew(); err != nil {
+		klog.Errorf("Renew lease of shard %s error %v", m.name, err)
+		return err
+	}
+	if err := m.refresh(); err != nil {
+		klog.Errorf("List shard leases in ns[%s] error %v", m.namespace, err)
+		return err
+	}
+	return nil
+}
+
+// Run renews the lease and refreshes the live shards every third of the lease duration until stop,
+// then the lease is released if ReleaseOnStop so the other shards take over the nodes at once.
+func (m *ShardManager) Run(stop <-chan struct{}) {
+	go m.notifyLoop(stop)
+
+	wait.Until(func() {
+		if err := m.renew(); err != nil {
+			klog.Errorf("Renew lease of shard %s error %v", m.name, err)
+		}
+		if err := m.refresh(); err != nil {
+			klog.Errorf("List shard leases in ns[%s] error %v", m.namespace, err)
+		}
+	}, m.leaseDuration/3, stop)
+
+	if !m.ReleaseOnStop {
+		return
+	}
+	if err := m.client.CoordinationV1().Leases(m.namespace).Delete(context.Background(), m.leaseName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("Release lease of shard %s error %v", m.name, err)
+		return
+	}
+	klog.Infof("Shard %s left", m.name)
+}
+
+func (m *ShardManager) leaseName() string {
+	return ShardLeasePrefix + m.name
+}
+
+func (m *ShardManager) renew() error {
+	leases := m.client.CoordinationV1().Leases(m.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(m.leaseDuration / time.Second)
+
+	lease, err := leases.Get(context.Background(), m.leaseName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(context.Background(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.namespace,
+				Labels: map[string]string{
+					ShardLeaseLabel: "true",
+				},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.name,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &m.name
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// refresh rebuilds the ring with the shards whose leases are not expired.
+// The shard itself is also removed if its lease can not be renewed, the other shards have taken over its nodes then.
+func (m *ShardManager) refresh() error {
+	list, err := m.client.CoordinationV1().Leases(m.namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: ShardLeaseLabel + "=true",
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	members := make([]string, 0, len(list.Items))
+	for i := range list.Items {
+		spec := list.Items[i].Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		if spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).Before(now) {
+			klog.V(4).Infof("Lease of shard %s is expired", *spec.HolderIdentity)
+			continue
+		}
+		members = append(members, *spec.HolderIdentity)
+	}
+
+	ring := NewHashRing(members, DefaultVirtualNodes)
+	m.lock.Lock()
+	changed := !ring.Equal(m.ring)
+	if changed {
+		m.ring = ring
+	}
+	m.lock.Unlock()
+
+	if changed {
+		klog.Infof("Shards change to %v", ring.Members())
+		select {
+		case m.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (m *ShardManager) notifyLoop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.changed:
+			ring := m.Ring()
+			m.lock.RLock()
+			handlers := append([]func(ring *HashRing){}, m.handlers...)
+			m.lock.RUnlock()
+			for _, f := range handlers {
+				f(ring)
+			}
+		}
+	}
+}
@@ -16,23 +16,14 @@ limitations under the License.
 package controller
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"path/filepath"
-	"sort"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 
 	"github.com/openyurtio/kole/cmd/kole-controller/app/options"
 	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
-	"github.com/openyurtio/kole/pkg/client/clientset/versioned"
 	"github.com/openyurtio/kole/pkg/data"
-	"github.com/openyurtio/kole/pkg/util"
 )
 
 func (c *KoleController) HeartBeatStatisticalLoop() {
@@ -49,91 +40,111 @@ func (c *KoleController) SnapShotLoop() {
 	for {
 		select {
 		case <-ticker.C:
+			// the standbys never save snapshots, their caches are kept by consuming heartbeats
+			if c.IsLeader() {
+				c.snapShot()
+			}
+		case <-c.tookOver:
+			c.syncGenerations()
 			c.snapShot()
 		}
 	}
 }
 
 func (c *KoleController) GetReceiveNum() {
-	klog.V(4).Infof("Get HB num %d", c.ReceiveNum)
+	klog.V(4).Infof("Get HB num %d", atomic.LoadInt64(&c.ReceiveNum))
 }
 
 func (c *KoleController) snapShot() {
 
 	klog.Infof("Snapshot loop start ...")
 
-	var registeringNum, registedNum, offlineNum int
+	var registeringNum, registedNum, offlineNum, pendingNum int
 	nameToStatus := make(map[string]*v1alpha1.KoleQueryStatus)
-	var hdata []byte
-	var err error
+	var hbs []*data.HeartBeat
+	// nodes consumed while the shards change, they are dropped here
+	var notOwned []string
 
 	ackLists := make([]*data.HeartBeatACK, 0, 10000)
 
 	n := time.Now().Unix()
-	c.HeartBeatCache.SafeReadOperate(func() {
-		if c.FirstSnapTime == 0 {
-			c.FirstSnapTime = n
+	if c.FirstSnapTime == 0 {
+		c.FirstSnapTime = n
+	}
+	// the states are got at a point in time without blocking ingestion, and marshaled later
+	nodes := c.Nodes.Snapshot()
+	hbs = make([]*data.HeartBeat, 0, len(nodes))
+
+	for _, node := range nodes {
+		hb := node.HeartBeat
+		if !c.Shard.Owns(hb.Name) {
+			notOwned = append(notOwned, hb.Name)
+			continue
 		}
 
-		for _, hb := range c.HeartBeatCache.Cache {
-
-			subTime := n - hb.LasterTimeStamp
-			if hb.State == data.HeartBeatRegisterd && subTime >= c.HeartBeatTimeOut {
-				klog.V(5).Infof("Nodename %s set offline, offline Time %d s", hb.Name, subTime)
-				hb.State = data.HeartBeatOffline
-			}
-
-			if hb.State == data.HeartBeatRegistering {
-				hb.State = data.HeartBeatRegisterd
-				ackLists = append(ackLists, &data.HeartBeatACK{
-					Identifier: hb.Identifier,
-					Registerd:  true,
-					NodeName:   hb.Name,
-				})
-				klog.V(5).Infof("Snapshot loop: find need ack hb[%s][%s]", hb.Identifier, hb.Name)
-			}
+		// the nodes are set offline by OfflineDetector as soon as their heartbeats time out,
+		// and the pending nodes are never acked before they are approved
+		state := hb.State
+		if state == data.HeartBeatRegistering {
+			state = data.HeartBeatRegisterd
+			ackLists = append(ackLists, &data.HeartBeatACK{
+				Identifier: hb.Identifier,
+				Registerd:  true,
+				NodeName:   hb.Name,
+			})
+			klog.V(5).Infof("Snapshot loop: find need ack hb[%s][%s]", hb.Identifier, hb.Name)
+		}
 
-			nameToStatus[hb.Name] = &v1alpha1.KoleQueryStatus{
-				ObjectStatus: hb.State,
-				ObjectName:   hb.Name,
-				ObjectType:   v1alpha1.KoleObjectNode,
-			}
+		if state != hb.State {
+			hb = c.setNodeState(node, state, TransitionReasonAcked)
+		}
 
-			switch hb.State {
-			case data.HeartBeatRegistering:
-				registeringNum++
-			case data.HeartBeatRegisterd:
-				registedNum++
-			case data.HeartBeatOffline:
-				offlineNum++
-			}
+		nameToStatus[hb.Name] = &v1alpha1.KoleQueryStatus{
+			ObjectStatus: hb.State,
+			ObjectName:   hb.Name,
+			ObjectType:   v1alpha1.KoleObjectNode,
 		}
-		hdata, err = json.Marshal(c.HeartBeatCache.Cache)
-		if err != nil {
-			klog.Errorf("Snapshot Loop: marshal heartBeatCache error %v", hdata)
-			return
+
+		switch hb.State {
+		case data.HeartBeatRegistering:
+			registeringNum++
+		case data.HeartBeatRegisterd:
+			registedNum++
+		case data.HeartBeatOffline:
+			offlineNum++
+		case data.HeartBeatPending:
+			pendingNum++
 		}
-	})
+		hbs = append(hbs, hb)
+	}
 
 	// Lock
 	c.QueryNodeStatusCache.Reset(nameToStatus)
+	if len(notOwned) != 0 {
+		klog.Infof("Snapshot loop: drop %d nodes not owned by shard %s", len(notOwned), c.Shard.Name())
+		c.RemoveNodes(notOwned)
+	}
 
-	if c.DataProcess != nil {
-		hdata, err = c.DataProcess.Compress(hdata)
-		if err != nil {
-			klog.Info()
+	if c.SnapshotKeyring != nil {
+		if rotated, err := c.SnapshotKeyring.Refresh(c.SnapshotStore.EncryptionKeyInUse); err != nil {
+			klog.Errorf("Snapshot Loop: refresh encryption keys error %v, use the cached keys", err)
+		} else if rotated {
+			id, _ := c.SnapshotKeyring.ActiveKey()
+			klog.Infof("Snapshot Loop: encryption key is rotated to %s, the snapshot generations from %d are encrypted with it, "+
+				"the kept generations stay encrypted with the previous keys", id, c.LasterSnapIndex)
 		}
 	}
+
 	c.syncAcks(ackLists)
-	c.syncSummaris(hdata)
+	size := c.syncSummaris(hbs)
 
 	var needTime int64
 	nt := time.Now().Unix()
 	if c.LasterSnapTime != 0 {
 		needTime = nt - c.LasterSnapTime
 	}
-	klog.Infof("Snapshot Loop: registeringNum %d registerdNum %d offlineNum %d allNum %d len of HBCacheData is %d",
-		registeringNum, registedNum, offlineNum, registedNum+registeringNum+offlineNum, len(hdata))
+	klog.Infof("Snapshot Loop: registeringNum %d registerdNum %d offlineNum %d pendingNum %d allNum %d len of HBCacheData is %d",
+		registeringNum, registedNum, offlineNum, pendingNum, registedNum+registeringNum+offlineNum+pendingNum, size)
 	klog.Infof("Current snap use %d s, laster jiange %d s, total jiange %d s", nt-n, needTime, nt-c.FirstSnapTime)
 
 	c.LasterSnapTime = nt
@@ -141,209 +152,130 @@ func (c *KoleController) snapShot() {
 	klog.Infof("Snapshot Loop end ...")
 }
 
+// setNodeState returns a copy of the heartbeat of node with state, which replaces the heartbeat in the store
+// unless a newer heartbeat of the node is accepted after the snapshot is taken.
+func (c *KoleController) setNodeState(node *NodeState, state, reason string) *data.HeartBeat {
+	n := node.WithState(state, reason)
+	c.Nodes.Update(node.Name, func(old *NodeState) *NodeState {
+		if old == nil || old.HeartBeat != node.HeartBeat {
+			return old
+		}
+		// the other state of the node may be changed after the snapshot is taken
+		m := old.Copy()
+		m.HeartBeat = n.HeartBeat
+		m.Reason = n.Reason
+		return m
+	})
+	return n.HeartBeat
+}
+
 func (c *KoleController) syncAcks(acks []*data.HeartBeatACK) {
 
 	for i, _ := range acks {
-		go func(ack *data.HeartBeatACK) {
-			ctlTopic := filepath.Join(util.TopicCTLPrefix, ack.NodeName)
-			if err := c.MessageHandler.PublishAck(context.Background(), ctlTopic, 0, false, ack); err != nil {
-				klog.Errorf("Mqtt5 publish error %v", err)
-				return
-			}
-		}(acks[i])
-
+		c.Dispatcher.SendAck(acks[i])
 	}
 }
 
-func (c *KoleController) syncSummaris(hdata []byte) {
-	snapedSummarisNames := make([]string, 0, 1024)
-	namesLock := &sync.Mutex{}
-
-	klog.V(4).Infof("Snapshot Loop: prepare to update summary ... ")
-	deleteSummary := func(ns, name string) {
-		for i := 0; i < 3; i++ {
-			if err := c.LiteClient.LiteV1alpha1().Summaries(ns).Delete(context.Background(),
-				name, metav1.DeleteOptions{}); err != nil {
-				klog.Errorf("Delete[%d] old summary %s crd error %v", i, name, err)
-				time.Sleep(time.Millisecond * 10)
-			} else {
-				break
-			}
-		}
-	}
-	deleteGroup := sync.WaitGroup{}
-	for _, oldN := range c.SnapdSummaryNames {
-		deleteGroup.Add(1)
-		go func(ns, name string) {
-			defer deleteGroup.Done()
-			deleteSummary(ns, name)
-		}(c.SummaryNS, oldN)
-	}
-	deleteGroup.Wait()
-
-	// break down chunk
-	bf := bytes.NewBuffer(hdata)
-	lb := make(map[string]string)
-	flag := fmt.Sprintf("%d", c.LasterSnapIndex)
-	lb[util.SNAPSHOT_LABEL_IDENTIFIER] = flag
-	lb[util.SNAPSHOT_LABEL_SUMMARY] = util.SNAPSHOT_LABEL_SUMMARY_VALUE
-	bufferLen := util.SNAPSHOT_MAX_BUFFER_LEN
-
-	if bf.Len()%bufferLen == 0 {
-		lb[util.SNAPSHOT_LABEL_MAX_NUM] = fmt.Sprintf("%d", bf.Len()/bufferLen)
-	} else {
-		lb[util.SNAPSHOT_LABEL_MAX_NUM] = fmt.Sprintf("%d", bf.Len()/bufferLen+1)
-	}
-
-	createSummary := func(s *v1alpha1.Summary) {
-		for j := 0; j < 3; j++ {
-			if _, err := c.LiteClient.LiteV1alpha1().Summaries(s.Namespace).Create(context.Background(),
-				s, metav1.CreateOptions{}); err != nil {
-				klog.Errorf("create summary [%s][%s] error %v", s.GetNamespace(), s.GetName(), err)
-				time.Sleep(time.Second)
-			} else {
-				klog.V(4).Infof("create summary [%s][%s] successful", s.GetNamespace(), s.GetName())
-				namesLock.Lock()
-				snapedSummarisNames = append(snapedSummarisNames, s.GetName())
-				namesLock.Unlock()
-				break
-			}
-		}
+// syncSummaris encodes, processes and saves the heartbeats as a new summary generation chunk by chunk,
+// and returns the size of the saved data.
+func (c *KoleController) syncSummaris(hbs []*data.HeartBeat) int64 {
+	klog.V(4).Infof("Snapshot Loop: prepare to create summary generation %d ... ", c.LasterSnapIndex)
 
+	snapedSummarisNames, size, err := c.SnapshotStore.SaveGeneration(c.LasterSnapIndex, c.SnapshotCodec, c.DataProcess, hbs)
+	if err != nil {
+		// keep the history as it is
+		return size
 	}
 
-	createGroup := sync.WaitGroup{}
-	for i := 0; ; i++ {
-		data := bf.Next(bufferLen)
-		if len(data) == 0 {
-			break
-		}
-		sum := &v1alpha1.Summary{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: c.SummaryNS,
-				Name:      fmt.Sprintf("%s-%d", flag, i),
-				Labels:    lb,
-			},
-			Data:  data,
-			Index: i,
-		}
-		// TODO  need batch create
-		createGroup.Add(1)
-		go func(s *v1alpha1.Summary) {
-			defer createGroup.Done()
-			createSummary(s)
-		}(sum)
+	c.SnapedGenerations = append(c.SnapedGenerations, snapedSummarisNames)
+	for len(c.SnapedGenerations) > c.SnapshotHistory {
+		c.SnapshotStore.DeleteSummaries(c.SnapedGenerations[0])
+		c.SnapedGenerations = c.SnapedGenerations[1:]
 	}
-	createGroup.Wait()
-
-	c.SnapdSummaryNames = snapedSummarisNames
-	klog.Infof("Save %d summares successful", len(c.SnapdSummaryNames))
+	klog.Infof("Save %d summares of generation %d successful, keep %d generations", len(snapedSummarisNames), c.LasterSnapIndex, len(c.SnapedGenerations))
+	return size
 }
 
-func LoadSnapShot(liteClient versioned.Interface, config *options.KoleControllerFlags, process DataProcesser) (
-	map[string]*data.HeartBeat,
-	map[string]*FilterInfo,
-	[]string,
-	map[string]map[string]*data.HeartBeatPod,
+// LoadSnapShot restores the node states from the generation selected by config.RestoreSnapshotIndex,
+// it also returns the summary names of all the saved generations and the index of the next generation.
+// The generation is read, processed and decoded chunk by chunk, so the whole snapshot data is never in memory.
+// newProcesser returns the processer of the snapshots compressed by the codec.
+func LoadSnapShot(store *SnapshotStore, config *options.KoleControllerFlags, newProcesser func(codec string) (DataProcesser, error)) (
+	*NodeStore,
+	[][]string,
+	int64,
 	map[string]*v1alpha1.KoleQueryStatus,
 	error) {
 
 	klog.Infof("Load snapshot start ...")
 
-	heartBeatCache := make(map[string]*data.HeartBeat)
-	heartBeatFilter := make(map[string]*FilterInfo)
-	observerdPods := make(map[string]map[string]*data.HeartBeatPod)
+	nodes := NewNodeStore()
 	nodeStatus := make(map[string]*v1alpha1.KoleQueryStatus)
-	// get current summery crd
-
-	var timeoutS int64 = 60
-	var continueStr string
-	var max int64 = 500
-	var total int
-
-	hbData := make([]byte, 0, 1024*1000*1000)
-	snapedName := make([]string, 0, 1024)
-	allSummaris := make([]v1alpha1.Summary, 0, 1024)
-
-	for {
-		localSummaries, err := liteClient.LiteV1alpha1().Summaries(config.NameSpace).List(context.Background(), metav1.ListOptions{
-			TimeoutSeconds: &timeoutS,
-			Limit:          max,
-			Continue:       continueStr,
-		})
-		if err != nil {
-			klog.Errorf("List all summarys in ns[%s] error %v", config.NameSpace, err)
-			return nil, nil, snapedName, observerdPods, nodeStatus, err
-		}
-		getLen := len(localSummaries.Items)
-		total = total + getLen
-		if getLen == 0 {
-			break
-		}
-
-		// TODO we may use bytes.buffer
-		for i, load := range localSummaries.Items {
-			//data, ok := load.BinaryData[CONFIGMAP_KEY]
-			snapedName = append(snapedName, load.GetName())
-			allSummaris = append(allSummaris, localSummaries.Items[i])
-		}
-
-		//klog.Infof("Current get list node chunk nums %d ", len(listedNodes.Items))
-		continueStr = localSummaries.GetContinue()
 
-		if len(continueStr) == 0 {
-			klog.Infof("continueStr is null , break ")
-			break
-		}
-
-		//klog.Infof("Current get all node chunk nums %d,  continueStr %s ", allNum, continueStr)
-		if getLen < int(max) {
-			break
-		}
+	var nextIndex int64
+	snapedGenerations := make([][]string, 0, config.SnapshotHistory)
 
+	generations, err := store.ListGenerations()
+	if err != nil {
+		return nil, nil, 0, nodeStatus, err
 	}
-
-	// sort by index
-	sort.Stable(BySummary(allSummaris))
-	for i, _ := range allSummaris {
-		hbData = append(hbData, allSummaris[i].Data...)
+	for _, g := range generations {
+		snapedGenerations = append(snapedGenerations, g.Names())
+	}
+	if len(generations) != 0 {
+		nextIndex = generations[len(generations)-1].Index + 1
 	}
 
-	if total == 0 || len(hbData) == 0 {
+	index := config.RestoreSnapshotIndex
+	if index >= 0 && restoredBefore(generations, index) {
+		// the index is only restored once, the startups after it restore the latest complete generation
+		klog.Warningf("Snapshot generation %d is restored before, restore from the latest complete generation instead", index)
+		index = -1
+	}
+	restore, err := SelectSnapshotGeneration(generations, index)
+	if err != nil {
+		klog.Errorf("Select snapshot generation in ns[%s] error %v", config.NameSpace, err)
+		return nil, nil, 0, nil, err
+	}
+	if config.RestoreSnapshotIndex >= 0 {
+		// the generations saved from now on tell the next startups the index is restored
+		restoredFrom := config.RestoreSnapshotIndex
+		store.RestoredFrom = &restoredFrom
+	}
+	if restore == nil {
 		klog.Infof("Can not get any summary cr")
-		return heartBeatCache, heartBeatFilter, snapedName, observerdPods, nodeStatus, nil
+		return nodes, snapedGenerations, nextIndex, nodeStatus, nil
 	}
 
-	if process != nil {
-		hbData, _ = process.UnCompress(hbData)
+	klog.Infof("Restore from snapshot generation %d saved at %s by codec %s", restore.Index, time.Unix(restore.Timestamp, 0), restore.Codec)
+	process, err := newProcesser(restore.Codec)
+	if err != nil {
+		klog.Errorf("Create processer of snapshot generation %d error %v", restore.Index, err)
+		return nil, nil, 0, nil, err
 	}
-	// TODO we may use fast json
-	if err := json.Unmarshal(hbData, &heartBeatCache); err != nil {
-		klog.Errorf("unmarshal error %v", err)
-		return nil, nil, snapedName, nil, nil, err
+	reader, err := AsStreamProcesser(process).NewReader(store.NewReader(restore))
+	if err != nil {
+		klog.Errorf("Process snapshot generation %d data error %v", restore.Index, err)
+		return nil, nil, 0, nil, err
 	}
-	for i, hb := range heartBeatCache {
-		heartBeatFilter[i] = &FilterInfo{
-			SeqNum:    hb.SeqNum,
-			TimeStamp: hb.TimeStamp,
-		}
+	defer reader.Close()
+
+	err = DecodeHeartBeats(reader, func(name string, hb *data.HeartBeat) error {
+		nodes.Update(name, func(old *NodeState) *NodeState {
+			return NewNodeStateFromHeartBeat(hb)
+		})
 		nodeStatus[hb.Name] = &v1alpha1.KoleQueryStatus{
 			ObjectStatus: hb.State,
 			ObjectName:   hb.Name,
 			ObjectType:   v1alpha1.KoleObjectNode,
 		}
-		observerdPods[hb.Name] = make(map[string]*data.HeartBeatPod)
-		for _, hbp := range hb.Pods {
-			observerdPods[hb.Name][hbp.Key()] = &data.HeartBeatPod{
-				Hash:      hbp.Hash,
-				Name:      hbp.Name,
-				NameSpace: hbp.NameSpace,
-				Status:    hbp.Status,
-			}
-		}
-
+		return nil
+	})
+	if err != nil {
+		klog.Errorf("Decode snapshot generation %d error %v", restore.Index, err)
+		return nil, nil, 0, nil, err
 	}
 
 	klog.Infof("Load snapshot end ...\n")
-	return heartBeatCache, heartBeatFilter, snapedName, observerdPods, nodeStatus, nil
+	return nodes, snapedGenerations, nextIndex, nodeStatus, nil
 }
@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+// SnapshotGeneration is one complete snapshot, saved as a group of summary chunks
+// sharing the same SNAPSHOT_LABEL_IDENTIFIER label.
+type SnapshotGeneration struct {
+	Index     int64
+	Timestamp int64
+	// Codec is the name of the codec compressing the generation
+	Codec string
+	// RestoredFrom is the generation restored by the controller saving the generation, negative means none
+	RestoredFrom int64
+	// MaxNum is only labelled on the last chunk, because it is not known until all data is written
+	MaxNum    int
+	Summaries []metav1.ObjectMeta
+}
+
+// Complete returns true if all the chunks of the generation are saved.
+func (g *SnapshotGeneration) Complete() bool {
+	return g.MaxNum > 0 && len(g.Summaries) == g.MaxNum
+}
+
+// Names returns the names of all the summaries of the generation.
+func (g *SnapshotGeneration) Names() []string {
+	names := make([]string, 0, len(g.Summaries))
+	for i := range g.Summaries {
+		names = append(names, g.Summaries[i].GetName())
+	}
+	return names
+}
+
+// GroupSnapshotGenerations groups summaries by their generation, sorted from the oldest to the latest.
+// Summaries without the snapshot labels are skipped.
+func GroupSnapshotGenerations(summaries []metav1.ObjectMeta) []*SnapshotGeneration {
+	indexToGeneration := make(map[int64]*SnapshotGeneration)
+	for i := range summaries {
+		s := summaries[i]
+		lb := s.GetLabels()
+		index, err := strconv.ParseInt(lb[util.SNAPSHOT_LABEL_IDENTIFIER], 10, 64)
+		if err != nil {
+			klog.Warningf("Summary %s has no valid %s label, skip", s.GetName(), util.SNAPSHOT_LABEL_IDENTIFIER)
+			continue
+		}
+		g, ok := indexToGeneration[index]
+		if !ok {
+			g = &SnapshotGeneration{
+				Index:     index,
+				Summaries: make([]metav1.ObjectMeta, 0, 16),
+			}
+			g.Timestamp, _ = strconv.ParseInt(lb[util.SNAPSHOT_LABEL_TIMESTAMP], 10, 64)
+			g.Codec = lb[util.SNAPSHOT_LABEL_CODEC]
+			if len(g.Codec) == 0 {
+				g.Codec = CodecLegacy
+			}
+			g.RestoredFrom = -1
+			if restored, err := strconv.ParseInt(lb[util.SNAPSHOT_LABEL_RESTORED_FROM], 10, 64); err == nil {
+				g.RestoredFrom = restored
+			}
+			indexToGeneration[index] = g
+		}
+		if maxNum, err := strconv.Atoi(lb[util.SNAPSHOT_LABEL_MAX_NUM]); err == nil && maxNum > g.MaxNum {
+			g.MaxNum = maxNum
+		}
+		g.Summaries = append(g.Summaries, s)
+	}
+
+	generations := make([]*SnapshotGeneration, 0, len(indexToGeneration))
+	for _, g := range indexToGeneration {
+		generations = append(generations, g)
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Index < generations[j].Index
+	})
+	return generations
+}
+
+// SelectSnapshotGeneration returns the generation to restore from.
+// If index is negative, the latest complete generation is returned, nil means there is no snapshot at all.
+func SelectSnapshotGeneration(generations []*SnapshotGeneration, index int64) (*SnapshotGeneration, error) {
+	if index >= 0 {
+		for _, g := range generations {
+			if g.Index != index {
+				continue
+			}
+			if !g.Complete() {
+				return nil, fmt.Errorf("snapshot generation %d is incomplete, get %d of %d summaries", index, len(g.Summaries), g.MaxNum)
+			}
+			return g, nil
+		}
+		return nil, fmt.Errorf("snapshot generation %d not found", index)
+	}
+
+	return latestCompleteGeneration(generations), nil
+}
+
+// restoredBefore returns true if the generation index is restored by a previous startup,
+// which labels the generations it saves with the index.
+func restoredBefore(generations []*SnapshotGeneration, index int64) bool {
+	for _, g := range generations {
+		if g.RestoredFrom == index {
+			return true
+		}
+	}
+	return false
+}
+
+func latestCompleteGeneration(generations []*SnapshotGeneration) *SnapshotGeneration {
+	for i := len(generations) - 1; i >= 0; i-- {
+		if generations[i].Complete() {
+			return generations[i]
+		}
+		klog.Warningf("Snapshot generation %d is incomplete, get %d of %d summaries, skip", generations[i].Index,
+			len(generations[i].Summaries), generations[i].MaxNum)
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/client/clientset/versioned"
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+// summaryCreateParallel is the max number of chunks being created at the same time by a SummaryWriter,
+// which also bounds the memory used by the writer.
+const summaryCreateParallel = 8
+
+// SnapshotStore saves and loads snapshot generations as summary chunks in Namespace.
+type SnapshotStore struct {
+	Client versioned.Interface
+	// Metadata is used to list summaries without their data
+	Metadata  metadata.Interface
+	Namespace string
+	// Shard is the controller shard owning the snapshots, empty if the controller is not sharded.
+	// Every shard saves its own generations, which are labelled and prefixed with the shard name.
+	Shard string
+	// RestoredFrom is the generation restored by --restore-snapshot-index, it is labelled on the generations saved
+	// after the restore, so the generation is not restored again at the next startup. Nil means none.
+	RestoredFrom *int64
+}
+
+// ForShard returns a store of the snapshots saved by shard.
+func (s *SnapshotStore) ForShard(shard string) *SnapshotStore {
+	store := *s
+	store.Shard = shard
+	return &store
+}
+
+// Selector returns the label selector of the summaries saved by the shard of the store.
+func (s *SnapshotStore) Selector() string {
+	if len(s.Shard) == 0 {
+		return "!" + util.SNAPSHOT_LABEL_SHARD
+	}
+	return fmt.Sprintf("%s=%s", util.SNAPSHOT_LABEL_SHARD, s.Shard)
+}
+
+func (s *SnapshotStore) summaryName(index int64, chunk int) string {
+	if len(s.Shard) == 0 {
+		return fmt.Sprintf("%d-%d", index, chunk)
+	}
+	return fmt.Sprintf("%s-%d-%d", s.Shard, index, chunk)
+}
+
+// ListGenerations lists the metadata of the summaries of the shard and groups them by generation.
+func (s *SnapshotStore) ListGenerations() ([]*SnapshotGeneration, error) {
+	metas, err := s.listSummaries(s.Selector())
+	if err != nil {
+		return nil, err
+	}
+	return GroupSnapshotGenerations(metas), nil
+}
+
+// ListShards lists the metadata of the summaries of all shards and groups them by shard and generation,
+// the summaries saved without sharding are keyed by the empty shard.
+func (s *SnapshotStore) ListShards() (map[string][]*SnapshotGeneration, error) {
+	metas, err := s.listSummaries("")
+	if err != nil {
+		return nil, err
+	}
+	shardToMetas := make(map[string][]metav1.ObjectMeta)
+	for i := range metas {
+		shard := metas[i].GetLabels()[util.SNAPSHOT_LABEL_SHARD]
+		shardToMetas[shard] = append(shardToMetas[shard], metas[i])
+	}
+	shards := make(map[string][]*SnapshotGeneration, len(shardToMetas))
+	for shard, m := range shardToMetas {
+		shards[shard] = GroupSnapshotGenerations(m)
+	}
+	return shards, nil
+}
+
+func (s *SnapshotStore) listSummaries(selector string) ([]metav1.ObjectMeta, error) {
+	var timeoutS int64 = 60
+	var continueStr string
+	var max int64 = 500
+
+	metas := make([]metav1.ObjectMeta, 0, 1024)
+	gvr := v1alpha1.SchemeGroupVersion.WithResource("summaries")
+	for {
+		list, err := s.Metadata.Resource(gvr).Namespace(s.Namespace).List(context.Background(), metav1.ListOptions{
+			TimeoutSeconds: &timeoutS,
+			Limit:          max,
+			Continue:       continueStr,
+			LabelSelector:  selector,
+		})
+		if err != nil {
+			klog.Errorf("List all summarys in ns[%s] error %v", s.Namespace, err)
+			return nil, err
+		}
+		for i := range list.Items {
+			metas = append(metas, list.Items[i].ObjectMeta)
+		}
+		continueStr = list.GetContinue()
+		if len(continueStr) == 0 || len(list.Items) < int(max) {
+			break
+		}
+	}
+	return metas, nil
+}
+
+// DeleteSummaries deletes the summaries by names, the summaries not found are ignored.
+func (s *SnapshotStore) DeleteSummaries(names []string) {
+	deleteSummary := func(name string) {
+		for i := 0; i < 3; i++ {
+			if err := s.Client.LiteV1alpha1().Summaries(s.Namespace).Delete(context.Background(),
+				name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				klog.Errorf("Delete[%d] old summary %s crd error %v", i, name, err)
+				time.Sleep(time.Millisecond * 10)
+			} else {
+				break
+			}
+		}
+	}
+	deleteGroup := sync.WaitGroup{}
+	for _, oldN := range names {
+		deleteGroup.Add(1)
+		go func(name string) {
+			defer deleteGroup.Done()
+			deleteSummary(name)
+		}(oldN)
+	}
+	deleteGroup.Wait()
+}
+
+// SummaryWriter splits the written data into chunks of util.SNAPSHOT_MAX_BUFFER_LEN bytes,
+// every chunk is saved as a summary as soon as it is full.
+type SummaryWriter struct {
+	store  *SnapshotStore
+	index  int64
+	labels map[string]string
+	buf    []byte
+	chunk  int
+	size   int64
+	closed bool
+
+	inflight chan struct{}
+	group    sync.WaitGroup
+	lock     sync.Mutex
+	names    []string
+	err      error
+}
+
+// NewWriter returns a writer saving the generation index, which is compressed by codec.
+func (s *SnapshotStore) NewWriter(index int64, timestamp int64, codec string) *SummaryWriter {
+	labels := map[string]string{
+		util.SNAPSHOT_LABEL_IDENTIFIER: fmt.Sprintf("%d", index),
+		util.SNAPSHOT_LABEL_TIMESTAMP:  fmt.Sprintf("%d", timestamp),
+		util.SNAPSHOT_LABEL_SUMMARY:    util.SNAPSHOT_LABEL_SUMMARY_VALUE,
+		util.SNAPSHOT_LABEL_CODEC:      codec,
+	}
+	if len(s.Shard) != 0 {
+		labels[util.SNAPSHOT_LABEL_SHARD] = s.Shard
+	}
+	if s.RestoredFrom != nil {
+		labels[util.SNAPSHOT_LABEL_RESTORED_FROM] = fmt.Sprintf("%d", *s.RestoredFrom)
+	}
+	return &SummaryWriter{
+		store:    s,
+		index:    index,
+		labels:   labels,
+		buf:      make([]byte, 0, util.SNAPSHOT_MAX_BUFFER_LEN),
+		inflight: make(chan struct{}, summaryCreateParallel),
+		names:    make([]string, 0, 1024),
+	}
+}
+
+func (w *SummaryWriter) setErr(err error) {
+	w.lock.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.lock.Unlock()
+}
+
+func (w *SummaryWriter) getErr() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+func (w *SummaryWriter) flush(last bool) {
+	lb := make(map[string]string, len(w.labels)+1)
+	for k, v := range w.labels {
+		lb[k] = v
+	}
+	// the number of chunks is only known at the end, it is the mark of a complete generation
+	if last {
+		lb[util.SNAPSHOT_LABEL_MAX_NUM] = fmt.Sprintf("%d", w.chunk+1)
+	}
+	sum := &v1alpha1.Summary{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: w.store.Namespace,
+			Name:      w.store.summaryName(w.index, w.chunk),
+			Labels:    lb,
+		},
+		Data:  w.buf,
+		Index: w.chunk,
+	}
+	w.chunk++
+	w.buf = make([]byte, 0, util.SNAPSHOT_MAX_BUFFER_LEN)
+
+	w.inflight <- struct{}{}
+	w.group.Add(1)
+	go func(s *v1alpha1.Summary) {
+		defer func() {
+			<-w.inflight
+			w.group.Done()
+		}()
+		var err error
+		for j := 0; j < 3; j++ {
+			if _, err = w.store.Client.LiteV1alpha1().Summaries(s.Namespace).Create(context.Background(),
+				s, metav1.CreateOptions{}); err != nil {
+				klog.Errorf("create summary [%s][%s] error %v", s.GetNamespace(), s.GetName(), err)
+				time.Sleep(time.Second)
+			} else {
+				klog.V(4).Infof("create summary [%s][%s] successful", s.GetNamespace(), s.GetName())
+				w.lock.Lock()
+				w.names = append(w.names, s.GetName())
+				w.lock.Unlock()
+				return
+			}
+		}
+		w.setErr(fmt.Errorf("create summary %s error %v", s.GetName(), err))
+	}(sum)
+}
+
+func (w *SummaryWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed summary writer")
+	}
+	if err := w.getErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	w.size += int64(n)
+	for len(p) > 0 {
+		// keep the last chunk in buffer, it is saved by Close with the number of chunks
+		if len(w.buf) == util.SNAPSHOT_MAX_BUFFER_LEN {
+			w.flush(false)
+		}
+		l := util.SNAPSHOT_MAX_BUFFER_LEN - len(w.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+	}
+	return n, nil
+}
+
+// Close saves the last chunk and waits for all the chunks to be saved.
+func (w *SummaryWriter) Close() error {
+	if w.closed {
+		return w.getErr()
+	}
+	w.closed = true
+	if w.getErr() == nil {
+		w.flush(true)
+	}
+	w.group.Wait()
+	return w.getErr()
+}
+
+// Abort waits for the chunks being saved, but never saves the last chunk,
+// so the generation stays incomplete and is skipped by restore.
+func (w *SummaryWriter) Abort() {
+	w.closed = true
+	w.group.Wait()
+}
+
+// Size returns the number of bytes written.
+func (w *SummaryWriter) Size() int64 {
+	return w.size
+}
+
+// Names returns the names of the saved summaries.
+func (w *SummaryWriter) Names() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string{}, w.names...)
+}
+
+// SaveGeneration encodes, processes and saves the heartbeats as generation index chunk by chunk.
+// It returns the names of the saved summaries and the size of the saved data,
+// the saved summaries are deleted if the generation can not be saved completely.
+func (s *SnapshotStore) SaveGeneration(index int64, codec string, process DataProcesser, hbs []*data.HeartBeat) ([]string, int64, error) {
+	sw := s.NewWriter(index, time.Now().Unix(), codec)
+	err := func() error {
+		pw, err := AsStreamProcesser(process).NewWriter(sw)
+		if err != nil {
+			return err
+		}
+		if err := EncodeHeartBeats(pw, hbs); err != nil {
+			pw.Close()
+			return err
+		}
+		return pw.Close()
+	}()
+	if err != nil {
+		sw.Abort()
+	} else {
+		err = sw.Close()
+	}
+	if err != nil {
+		// an incomplete generation can not be restored
+		klog.Errorf("Save summary generation %d error %v, drop %d saved summaries", index, err, len(sw.Names()))
+		s.DeleteSummaries(sw.Names())
+		return nil, sw.Size(), err
+	}
+	return sw.Names(), sw.Size(), nil
+}
+
+// summaryReader reads the chunks of a generation one by one.
+type summaryReader struct {
+	store *SnapshotStore
+	g     *SnapshotGeneration
+	chunk int
+	data  []byte
+}
+
+// NewReader returns a reader of the data of generation g, only one chunk is kept in memory.
+func (s *SnapshotStore) NewReader(g *SnapshotGeneration) io.Reader {
+	return &summaryReader{
+		store: s,
+		g:     g,
+	}
+}
+
+func (r *summaryReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.chunk >= r.g.MaxNum {
+			return 0, io.EOF
+		}
+		name := r.store.summaryName(r.g.Index, r.chunk)
+		sum, err := r.store.Client.LiteV1alpha1().Summaries(r.store.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Get summary [%s][%s] error %v", r.store.Namespace, name, err)
+			return 0, err
+		}
+		if sum.Index != r.chunk {
+			return 0, fmt.Errorf("summary %s has index %d, expect %d", name, sum.Index, r.chunk)
+		}
+		r.data = sum.Data
+		r.chunk++
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// EncodeHeartBeats writes the heartbeats as a json object keyed by node name, one node at a time.
+func EncodeHeartBeats(w io.Writer, hbs []*data.HeartBeat) error {
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte('{'); err != nil {
+		return err
+	}
+	for i, hb := range hbs {
+		if i != 0 {
+			if err := bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		key, err := json.Marshal(hb.Name)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(hb)
+		if err != nil {
+			return err
+		}
+		bw.Write(key)
+		bw.WriteByte(':')
+		if _, err := bw.Write(value); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte('}'); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DecodeHeartBeats reads a json object written by EncodeHeartBeats and calls f with every heartbeat.
+func DecodeHeartBeats(r io.Reader, f func(name string, hb *data.HeartBeat) error) error {
+	decoder := json.NewDecoder(r)
+	if t, err := decoder.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("expect { at the beginning of snapshot, get %v", t)
+	}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expect node name in snapshot, get %v", t)
+		}
+		hb := &data.HeartBeat{}
+		if err := decoder.Decode(hb); err != nil {
+			return err
+		}
+		if err := f(name, hb); err != nil {
+			return err
+		}
+	}
+	if t, err := decoder.Token(); err != nil {
+		return err
+	} else if t != json.Delim('}') {
+		return fmt.Errorf("expect } at the end of snapshot, get %v", t)
+	}
+	return nil
+}
+
+// EncryptionKeyInUse returns true if the key id encrypts any generation of the store, which is read from the header
+// of the first chunk of every generation.
+func (s *SnapshotStore) EncryptionKeyInUse(id string) (bool, error) {
+	generations, err := s.ListGenerations()
+	if err != nil {
+		return false, err
+	}
+	for _, g := range generations {
+		name := s.summaryName(g.Index, 0)
+		sum, err := s.Client.LiteV1alpha1().Summaries(s.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		if keyID, ok := EncryptionKeyID(sum.Data); ok && keyID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -16,7 +16,15 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"fmt"
 	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openyurtio/kole/pkg/client/clientset/versioned/fake"
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/util"
 )
 
 func TestBytesBuffer(t *testing.T) {
@@ -47,3 +55,165 @@ func TestBytesBuffer(t *testing.T) {
 		}
 	*/
 }
+
+func newGenerationSummaries(index int64, maxNum, num int) []metav1.ObjectMeta {
+	summaries := make([]metav1.ObjectMeta, 0, num)
+	for i := num - 1; i >= 0; i-- {
+		lb := map[string]string{
+			util.SNAPSHOT_LABEL_IDENTIFIER: fmt.Sprintf("%d", index),
+			util.SNAPSHOT_LABEL_TIMESTAMP:  fmt.Sprintf("%d", 1000+index),
+		}
+		if i == maxNum-1 {
+			lb[util.SNAPSHOT_LABEL_MAX_NUM] = fmt.Sprintf("%d", maxNum)
+		}
+		summaries = append(summaries, metav1.ObjectMeta{
+			Name:   fmt.Sprintf("%d-%d", index, i),
+			Labels: lb,
+		})
+	}
+	return summaries
+}
+
+func TestSelectSnapshotGeneration(t *testing.T) {
+	summaries := make([]metav1.ObjectMeta, 0, 10)
+	summaries = append(summaries, newGenerationSummaries(7, 2, 1)...)
+	summaries = append(summaries, newGenerationSummaries(5, 2, 2)...)
+	summaries = append(summaries, newGenerationSummaries(6, 3, 3)...)
+	summaries = append(summaries, metav1.ObjectMeta{Name: "legacy"})
+
+	generations := GroupSnapshotGenerations(summaries)
+	if len(generations) != 3 {
+		t.Fatalf("expect 3 generations, get %d", len(generations))
+	}
+	for i, index := range []int64{5, 6, 7} {
+		if generations[i].Index != index {
+			t.Errorf("expect generation %d at %d, get %d", index, i, generations[i].Index)
+		}
+	}
+
+	cases := []struct {
+		Name      string
+		Index     int64
+		Expect    int64
+		ExpectErr bool
+	}{
+		{"latest complete", -1, 6, false},
+		{"specific", 5, 5, false},
+		{"incomplete", 7, 0, true},
+		{"not found", 8, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			g, err := SelectSnapshotGeneration(generations, c.Index)
+			if c.ExpectErr {
+				if err == nil {
+					t.Errorf("expect error, get generation %d", g.Index)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("select generation error %v", err)
+			}
+			if g.Index != c.Expect {
+				t.Errorf("expect generation %d, get %d", c.Expect, g.Index)
+			}
+			if g.Timestamp != 1000+c.Expect {
+				t.Errorf("expect timestamp %d, get %d", 1000+c.Expect, g.Timestamp)
+			}
+		})
+	}
+
+	if g, err := SelectSnapshotGeneration(nil, -1); err != nil || g != nil {
+		t.Errorf("expect no generation and no error, get %v %v", g, err)
+	}
+}
+
+func TestSelectRestoredSnapshotGeneration(t *testing.T) {
+	summaries := newGenerationSummaries(5, 1, 1)
+	restored := newGenerationSummaries(8, 1, 1)
+	restored[0].Labels[util.SNAPSHOT_LABEL_RESTORED_FROM] = "5"
+	summaries = append(summaries, restored...)
+	generations := GroupSnapshotGenerations(summaries)
+
+	// the generation asked for is selected as it is, even if it is restored before
+	g, err := SelectSnapshotGeneration(generations, 5)
+	if err != nil || g == nil || g.Index != 5 {
+		t.Fatalf("expect generation 5, get %v %v", g, err)
+	}
+	// a generation trimmed or never saved is never replaced by another one
+	for _, index := range []int64{4, 9} {
+		if g, err := SelectSnapshotGeneration(generations, index); err == nil {
+			t.Errorf("expect error for missing generation %d, get %v", index, g)
+		}
+	}
+
+	// only the generation labelled as restored is restored once
+	if !restoredBefore(generations, 5) {
+		t.Errorf("expect generation 5 restored before")
+	}
+	for _, index := range []int64{4, 8} {
+		if restoredBefore(generations, index) {
+			t.Errorf("expect generation %d never restored", index)
+		}
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	store := &SnapshotStore{
+		Client:    fake.NewSimpleClientset(),
+		Namespace: "kole",
+	}
+	hbs := make([]*data.HeartBeat, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		hbs = append(hbs, &data.HeartBeat{
+			Name:   fmt.Sprintf("node-%d", i),
+			State:  data.HeartBeatRegisterd,
+			SeqNum: uint64(i),
+		})
+	}
+
+	w := store.NewWriter(3, 1000, CodecNone)
+	if err := EncodeHeartBeats(w, hbs); err != nil {
+		t.Fatalf("encode heartbeats error %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close summary writer error %v", err)
+	}
+	if w.Size() <= util.SNAPSHOT_MAX_BUFFER_LEN {
+		t.Fatalf("expect more than one chunk, get %d bytes", w.Size())
+	}
+
+	list, err := store.Client.LiteV1alpha1().Summaries("kole").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list summaries error %v", err)
+	}
+	if len(list.Items) != len(w.Names()) {
+		t.Fatalf("expect %d summaries, get %d", len(w.Names()), len(list.Items))
+	}
+	metas := make([]metav1.ObjectMeta, 0, len(list.Items))
+	for i := range list.Items {
+		metas = append(metas, list.Items[i].ObjectMeta)
+	}
+	g, err := SelectSnapshotGeneration(GroupSnapshotGenerations(metas), -1)
+	if err != nil || g == nil {
+		t.Fatalf("select generation error %v", err)
+	}
+	if g.Index != 3 || g.MaxNum != len(list.Items) || g.Codec != CodecNone {
+		t.Errorf("unexpected generation %d with %d summaries by codec %s", g.Index, g.MaxNum, g.Codec)
+	}
+
+	decoded := make(map[string]*data.HeartBeat)
+	err = DecodeHeartBeats(store.NewReader(g), func(name string, hb *data.HeartBeat) error {
+		decoded[name] = hb
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode heartbeats error %v", err)
+	}
+	if len(decoded) != len(hbs) {
+		t.Fatalf("expect %d heartbeats, get %d", len(hbs), len(decoded))
+	}
+	if hb := decoded["node-19999"]; hb == nil || hb.SeqNum != 19999 {
+		t.Errorf("unexpected heartbeat %v", hb)
+	}
+}
@@ -18,16 +18,29 @@ package controller
 import (
 	outmqtt "github.com/eclipse/paho.mqtt.golang"
 	"k8s.io/klog/v2"
-
-	"github.com/openyurtio/kole/pkg/data"
 )
 
 func (c *KoleController) Mqtt3SubEdgeHeartBeat(client outmqtt.Client, message outmqtt.Message) {
-	hb, err := data.UnmarshalPayloadToHeartBeat(message.Payload())
+	hb, err := c.openHeartBeat(message.Payload())
 	if err != nil {
-		klog.Errorf("UnmarshalPayloadToHeartBeat error %v", err)
+		klog.Errorf("Open heartbeat error %v", err)
 		return
 	}
-	c.ConsumeHeartBeatDirect(hb)
+	c.HeartBeatPipeline.Enqueue(hb)
 	klog.V(5).Infof("sub heatbeat topic %s Name %s State %s", message.Topic(), hb.Name, hb.State)
 }
+
+func (c *KoleController) Mqtt3SubCertificateRequest(client outmqtt.Client, message outmqtt.Message) {
+	klog.V(5).Infof("sub certificate request topic %s", message.Topic())
+	c.ConsumeCertificateRequest(message.Payload())
+}
+
+func (c *KoleController) Mqtt3SubNodeWill(client outmqtt.Client, message outmqtt.Message) {
+	will, err := c.openWill(message.Payload())
+	if err != nil {
+		klog.Errorf("Open will error %v", err)
+		return
+	}
+	klog.V(5).Infof("sub will topic %s Name %s", message.Topic(), will.Name)
+	c.ConsumeWill(will)
+}
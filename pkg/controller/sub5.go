@@ -20,7 +20,6 @@ import (
 	"github.com/openyurtio/kole/pkg/message"
 	"k8s.io/klog/v2"
 
-	"github.com/openyurtio/kole/pkg/data"
 	"github.com/openyurtio/kole/pkg/util"
 )
 
@@ -28,21 +27,71 @@ func (c *KoleController) Mqtt5CreateSubscribes() []*message.SingleSubcribe {
 
 	subs := make([]*message.SingleSubcribe, 0, 2)
 	//HB
+	topic := util.TopicHeartBeat
+	if len(c.HeartBeatShareGroup) != 0 {
+		// heartbeats are received by the consumers of the shared subscription, and forwarded to the route of their owners
+		topic = HeartBeatRouteTopic(c.HeartBeatRoute)
+	}
 	subs = append(subs, &message.SingleSubcribe{
-		Topic: util.TopicHeartBeat,
+		Topic: topic,
 		Option: paho.SubscribeOptions{
 			QoS: 1,
 		},
 		Handler: func(publish *paho.Publish) {
-			hb, err := data.UnmarshalPayloadToHeartBeat(publish.Payload)
+			hb, err := c.openHeartBeat(publish.Payload)
 			if err != nil {
-				klog.Errorf("UnmarshalPayloadToHeartBeat error %v", err)
+				klog.Errorf("Open heartbeat error %v", err)
 				return
 			}
-			c.ConsumeHeartBeatDirect(hb)
+			c.HeartBeatPipeline.Enqueue(hb)
 			klog.V(5).Infof("sub heatbeat topic %s Name %s State %s", publish.Topic, hb.Name, hb.State)
 		},
 	})
+	//WILL
+	// the wills are rare, every instance subscribes them and consumes the ones of its own nodes
+	subs = append(subs, &message.SingleSubcribe{
+		Topic: util.TopicWill,
+		Option: paho.SubscribeOptions{
+			QoS: 1,
+		},
+		Handler: func(publish *paho.Publish) {
+			will, err := c.openWill(publish.Payload)
+			if err != nil {
+				klog.Errorf("Open will error %v", err)
+				return
+			}
+			klog.V(5).Infof("sub will topic %s Name %s", publish.Topic, will.Name)
+			c.ConsumeWill(will)
+		},
+	})
+	//CSR
+	// the certificate requests are rare as well, they are issued by the leader owning the node
+	subs = append(subs, &message.SingleSubcribe{
+		Topic: util.TopicCertificateRequest,
+		Option: paho.SubscribeOptions{
+			QoS: 1,
+		},
+		Handler: func(publish *paho.Publish) {
+			klog.V(5).Infof("sub certificate request topic %s", publish.Topic)
+			c.ConsumeCertificateRequest(publish.Payload)
+		},
+	})
 
 	return subs
 }
+
+// Mqtt5CreateSharedSubscribes creates the shared heartbeat subscription, the heartbeats are spread across all its consumers.
+func (c *KoleController) Mqtt5CreateSharedSubscribes() []*message.SingleSubcribe {
+	return []*message.SingleSubcribe{
+		{
+			Topic: message.SharedTopic(c.HeartBeatShareGroup, util.TopicHeartBeat),
+			Option: paho.SubscribeOptions{
+				QoS: 1,
+			},
+			Handler: func(publish *paho.Publish) {
+				c.RouteHeartBeat(publish.Payload)
+				klog.V(5).Infof("sub shared heatbeat topic %s", publish.Topic)
+			},
+		},
+	}
+}
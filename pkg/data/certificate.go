@@ -0,0 +1,40 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"encoding/json"
+)
+
+// CertificateOrganization is the organization of the client certificates of the nodes, whose common names are the node names.
+const CertificateOrganization = "kole:nodes"
+
+// CertificateRequest asks the controllers to issue a client certificate of the node for the MQTT mTLS,
+// the certificate is sent back to CTL of the node as the HeartBeatACK of Identifier.
+type CertificateRequest struct {
+	Name       string `json:"name,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	// Request is the PEM encoded PKCS#10 certificate request signed by the key of the node
+	Request []byte `json:"request,omitempty"`
+}
+
+func UnmarshalPayloadToCertificateRequest(payload []byte) (*CertificateRequest, error) {
+	d := &CertificateRequest{}
+	if err := json.Unmarshal(payload, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -30,6 +30,10 @@ type Pod struct {
 	NameSpace       string
 	Spec            *v1alpha1.PodSpec
 	DeleteTimeStamp *metav1.Time
+	// SealedEnv are the values of the env of Spec referring to Secrets, which are sealed to the node by env name
+	SealedEnv map[string]*SealedValue `json:"sealedEnv,omitempty"`
+	// Env is the environment of the pod resolved by the node, it is only kept in memory
+	Env map[string]string `json:"-"`
 }
 
 func UnmarshalPayloadToPod(payload []byte) (*Pod, error) {
@@ -24,6 +24,13 @@ const HeartBeatRegistering = "Registering"
 const HeartBeatRegisterd = "Registerd"
 const HeartBeatOffline = "Offline"
 
+// HeartBeatPending is the cached state of a registering node waiting for approval, it is never sent by the nodes
+const HeartBeatPending = "Pending"
+
+// HeartBeatUnregistering is sent by a node shutting down cleanly, the node is removed at once
+const HeartBeatUnregistering = "Unregistering"
+
+// OFFLINE_TIMEOUT is the default time (second) a node is kept after it goes offline
 const OFFLINE_TIMEOUT = 60 * 20
 
 type HeartBeat struct {
@@ -37,6 +44,16 @@ type HeartBeat struct {
 	State           string           `json:"state,omitempty"`
 	Status          *HeartBeatStatus `json:"status,omitempty"`
 	Pods            []*HeartBeatPod  `json:"pods,omitempty"`
+	// BootstrapToken is presented by the Registering heartbeats to be approved, it is never cached
+	BootstrapToken string `json:"bootstrapToken,omitempty"`
+	// PublicKey is the ed25519 key verifying the heartbeats of the node, it is pinned by the controllers
+	// to the key of the first verified heartbeat, and is never taken from the heartbeat itself
+	PublicKey []byte `json:"publicKey,omitempty"`
+	// EncryptionKey is the identity key of the node, which the secrets of the pods are sealed to and the client
+	// certificates are issued for. It is reported by the Registering heartbeats, and pinned by the first registration
+	EncryptionKey []byte `json:"encryptionKey,omitempty"`
+	// Signed is true if the heartbeat is verified by its signature, it is only set by the controllers
+	Signed bool `json:"signed,omitempty"`
 }
 
 type HeartBeatStatus struct {
@@ -22,7 +22,15 @@ import (
 type HeartBeatACK struct {
 	Identifier string `json:"identifier,omitempty"`
 	Registerd  bool   `json:"registerd,omitempty"`
-	NodeName   string `json:"-"`
+	// RetryAfter is the time (second) the node waits before registering again, when the registering is rejected
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+	// Pending is true if the node is waiting for approval
+	Pending bool `json:"pending,omitempty"`
+	// Reregister is true if the registered node is not approved by the controllers, the node must register again
+	Reregister bool `json:"reregister,omitempty"`
+	// Certificate is the PEM encoded client certificate issued for the certificate request of Identifier
+	Certificate []byte `json:"certificate,omitempty"`
+	NodeName    string `json:"-"`
 }
 
 func UnmarshalPayloadToHeartBeatACK(payload []byte) (*HeartBeatACK, error) {
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// SealedValue is a value encrypted to the P-256 key of a node: the AES-256-GCM key is derived from the ECDH secret
+// of an ephemeral key and the key of the node, so only the node can decrypt it.
+type SealedValue struct {
+	// EphemeralKey is the uncompressed point of the ephemeral public key
+	EphemeralKey []byte `json:"epk"`
+	Nonce        []byte `json:"nonce"`
+	Ciphertext   []byte `json:"ct"`
+}
+
+// MarshalEncryptionKey returns the DER encoded public key reported by a node to receive the sealed values.
+func MarshalEncryptionKey(key *ecdsa.PublicKey) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(key)
+}
+
+// ParseEncryptionKey returns the P-256 public key of der returned by MarshalEncryptionKey.
+func ParseEncryptionKey(der []byte) (*ecdsa.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("encryption key is not a P-256 key")
+	}
+	return key, nil
+}
+
+// SealedEnvAAD returns the additional data of the sealed env name of pod on node,
+// so a sealed value can not be moved to another env, pod or node.
+func SealedEnvAAD(node, pod, name string) []byte {
+	return []byte(node + "\x00" + pod + "\x00" + name)
+}
+
+func sealingAEAD(x *big.Int, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte("kole-sealed-value"))
+	secret := make([]byte, 32)
+	h.Write(x.FillBytes(secret))
+	h.Write(ephemeral)
+	h.Write(recipient)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealValue encrypts value to key with the additional data aad.
+func SealValue(key *ecdsa.PublicKey, value, aad []byte) (*SealedValue, error) {
+	curve := elliptic.P256()
+	d, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sx, _ := curve.ScalarMult(key.X, key.Y, d)
+	ephemeral := elliptic.Marshal(curve, x, y)
+	aead, err := sealingAEAD(sx, ephemeral, elliptic.Marshal(curve, key.X, key.Y))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return &SealedValue{
+		EphemeralKey: ephemeral,
+		Nonce:        nonce,
+		Ciphertext:   aead.Seal(nil, nonce, value, aad),
+	}, nil
+}
+
+// Open decrypts the value sealed to the public key of key with the additional data aad.
+func (v *SealedValue) Open(key *ecdsa.PrivateKey, aad []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, v.EphemeralKey)
+	if x == nil {
+		return nil, fmt.Errorf("invalid ephemeral key")
+	}
+	sx, _ := curve.ScalarMult(x, y, key.D.Bytes())
+	aead, err := sealingAEAD(sx, v.EphemeralKey, elliptic.Marshal(curve, key.X, key.Y))
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return aead.Open(nil, v.Nonce, v.Ciphertext, aad)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSealValue(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := MarshalEncryptionKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParseEncryptionKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aad := SealedEnvAAD("node-1", "default/pod", "PASSWORD")
+	sealed, err := SealValue(pub, []byte("secret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := sealed.Open(key, aad)
+	if err != nil {
+		t.Fatalf("open sealed value error %v", err)
+	}
+	if string(value) != "secret" {
+		t.Errorf("expected value secret, got %s", value)
+	}
+
+	if _, err := sealed.Open(other, aad); err == nil {
+		t.Errorf("expected the value sealed to another key not to open")
+	}
+	if _, err := sealed.Open(key, SealedEnvAAD("node-2", "default/pod", "PASSWORD")); err == nil {
+		t.Errorf("expected the value sealed to another node not to open")
+	}
+	if _, err := sealed.Open(key, SealedEnvAAD("node-1", "default/pod", "TOKEN")); err == nil {
+		t.Errorf("expected the value sealed to another env not to open")
+	}
+}
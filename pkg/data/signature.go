@@ -0,0 +1,442 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The types of the signed messages, a message signed as a type is never accepted as another type.
+const (
+	SignedTypeHeartBeat = "HeartBeat"
+	SignedTypeAck       = "Ack"
+	SignedTypePod       = "Pod"
+	// SignedTypeCertificateRequest is the type of the certificate requests of the nodes
+	SignedTypeCertificateRequest = "CertificateRequest"
+	// SignedTypeWill is the type of the wills of the nodes, which are signed once at the boot
+	SignedTypeWill = "Will"
+)
+
+// The algorithms signing the messages.
+const (
+	// SignatureHMAC signs the messages with the key shared by a node and the controllers,
+	// the controllers derive the keys of all the nodes from the master keys by DeriveNodeKey
+	SignatureHMAC = "hmac-sha256"
+	// SignatureEd25519 signs the messages with the private key of the sender
+	SignatureEd25519 = "ed25519"
+)
+
+// The reasons a message is rejected.
+const (
+	RejectUnsigned = "unsigned"
+	RejectInvalid  = "invalid"
+	RejectReplayed = "replayed"
+)
+
+// SignedMessage is a message with its signature. All the fields are signed, and Seq is increased with every message
+// of the sender, so a signed message can not be modified, sent to another node or as another type, or replayed.
+type SignedMessage struct {
+	Type string `json:"type"`
+	// the node sending the message, or the node the message is sent to
+	Node  string `json:"node"`
+	Seq   uint64 `json:"seq"`
+	Alg   string `json:"alg"`
+	KeyID string `json:"kid,omitempty"`
+	// the json of the message
+	Payload   json.RawMessage `json:"payload"`
+	Signature []byte          `json:"sig"`
+}
+
+func (m *SignedMessage) signedData() []byte {
+	var buf bytes.Buffer
+	for _, f := range []string{m.Alg, m.KeyID, m.Type, m.Node} {
+		buf.WriteString(f)
+		buf.WriteByte(0)
+	}
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], m.Seq)
+	buf.Write(seq[:])
+	buf.Write(m.Payload)
+	return buf.Bytes()
+}
+
+// SignatureError is the error of a rejected message.
+type SignatureError struct {
+	// one of the reject reasons
+	Reason string
+	Err    error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("message rejected as %s: %v", e.Reason, e.Err)
+}
+
+// MessageKeys signs and verifies the messages of the nodes.
+type MessageKeys interface {
+	// Signer returns the algorithm and the key id signing the messages to or from node, and the function signing data
+	Signer(node string) (string, string, func(data []byte) []byte, error)
+	// Verify checks the signature of data of a message to or from node
+	Verify(node, alg, kid string, data, sig []byte) error
+}
+
+// KeySource holds the keys indexed by key id, such as the keyring loaded from a secret.
+type KeySource interface {
+	// ActiveKey returns the key id and the key used to sign
+	ActiveKey() (string, []byte)
+	Key(id string) ([]byte, bool)
+}
+
+// StaticKeys is a KeySource of fixed keys, the first one is active.
+type StaticKeys struct {
+	ids  []string
+	keys map[string][]byte
+}
+
+// LoadKeyFile loads the keys in file, every line of which is a key id and a base64 encoded key separated by spaces,
+// the empty lines and the lines starting with # are skipped.
+func LoadKeyFile(file string) (*StaticKeys, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &StaticKeys{
+		keys: make(map[string][]byte),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %q in key file %s, expect <key id> <base64 key>", line, file)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s in key file %s: %v", fields[0], file, err)
+		}
+		k.Add(fields[0], key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.ids) == 0 {
+		return nil, fmt.Errorf("no key is found in key file %s", file)
+	}
+	return k, nil
+}
+
+// Add adds key of id, the first added key is active.
+func (k *StaticKeys) Add(id string, key []byte) {
+	if k.keys == nil {
+		k.keys = make(map[string][]byte)
+	}
+	if _, ok := k.keys[id]; !ok {
+		k.ids = append(k.ids, id)
+	}
+	k.keys[id] = key
+}
+
+func (k *StaticKeys) ActiveKey() (string, []byte) {
+	if len(k.ids) == 0 {
+		return "", nil
+	}
+	return k.ids[0], k.keys[k.ids[0]]
+}
+
+func (k *StaticKeys) Key(id string) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// DeriveNodeKey returns the HMAC key of node derived from master.
+func DeriveNodeKey(master []byte, node string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("kole-node-key/"))
+	mac.Write([]byte(node))
+	return mac.Sum(nil)
+}
+
+// HMACKeys signs the messages with HMAC-SHA256. If Derive is true, the keys of Source are the master keys
+// and the keys of the nodes are derived from them, otherwise the keys of Source are the keys of the node.
+type HMACKeys struct {
+	Source KeySource
+	Derive bool
+}
+
+func (k *HMACKeys) mac(node string, key, data []byte) []byte {
+	if k.Derive {
+		key = DeriveNodeKey(key, node)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k *HMACKeys) Signer(node string) (string, string, func(data []byte) []byte, error) {
+	kid, key := k.Source.ActiveKey()
+	if len(key) == 0 {
+		return "", "", nil, fmt.Errorf("no active hmac key")
+	}
+	return SignatureHMAC, kid, func(data []byte) []byte {
+		return k.mac(node, key, data)
+	}, nil
+}
+
+func (k *HMACKeys) Verify(node, alg, kid string, data, sig []byte) error {
+	if alg != SignatureHMAC {
+		return fmt.Errorf("unexpected signature algorithm %q", alg)
+	}
+	key, ok := k.Source.Key(kid)
+	if !ok {
+		return fmt.Errorf("hmac key %q is not found", kid)
+	}
+	if !hmac.Equal(k.mac(node, key, data), sig) {
+		return fmt.Errorf("hmac signature mismatch")
+	}
+	return nil
+}
+
+// Ed25519KeyID returns the key id of a public key, which is the key itself encoded by base64.
+func Ed25519KeyID(key ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// ParseEd25519KeyID returns the public key of kid returned by Ed25519KeyID.
+func ParseEd25519KeyID(kid string) (ed25519.PublicKey, error) {
+	key, err := base64.RawURLEncoding.DecodeString(kid)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 key id %q", kid)
+	}
+	return key, nil
+}
+
+// Ed25519Keys signs the messages with the private key of the sender returned by PrivateKey,
+// and verifies the messages of the other side with the public key returned by PublicKey.
+type Ed25519Keys struct {
+	PrivateKey func() (string, ed25519.PrivateKey, error)
+	PublicKey  func(node, kid string) (ed25519.PublicKey, error)
+}
+
+func (k *Ed25519Keys) Signer(node string) (string, string, func(data []byte) []byte, error) {
+	kid, key, err := k.PrivateKey()
+	if err != nil {
+		return "", "", nil, err
+	}
+	return SignatureEd25519, kid, func(data []byte) []byte {
+		return ed25519.Sign(key, data)
+	}, nil
+}
+
+func (k *Ed25519Keys) Verify(node, alg, kid string, data, sig []byte) error {
+	if alg != SignatureEd25519 {
+		return fmt.Errorf("unexpected signature algorithm %q", alg)
+	}
+	key, err := k.PublicKey(node, kid)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("ed25519 signature mismatch")
+	}
+	return nil
+}
+
+// Ed25519SeedKey returns the private key of seed, which is the key of a KeySource.
+func Ed25519SeedKey(seed []byte) (ed25519.PrivateKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ed25519 seed must be %d bytes, get %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// MessageSigner signs the messages of a sender, the sequence of every message is greater than the previous one
+// and not less than the one returned by base, so the sequences keep increasing across restarts.
+type MessageSigner struct {
+	keys MessageKeys
+	base func() uint64
+
+	lock sync.Mutex
+	seq  uint64
+}
+
+// NewMessageSigner creates a MessageSigner signing with keys.
+func NewMessageSigner(keys MessageKeys, base func() uint64) *MessageSigner {
+	return &MessageSigner{
+		keys: keys,
+		base: base,
+	}
+}
+
+func (s *MessageSigner) nextSeq() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	if b := s.base(); b > s.seq {
+		s.seq = b
+	}
+	return s.seq
+}
+
+// Sign signs object as a message of type typ to or from node.
+func (s *MessageSigner) Sign(typ, node string, object interface{}) (*SignedMessage, error) {
+	payload, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	alg, kid, sign, err := s.keys.Signer(node)
+	if err != nil {
+		return nil, err
+	}
+	m := &SignedMessage{
+		Type:    typ,
+		Node:    node,
+		Seq:     s.nextSeq(),
+		Alg:     alg,
+		KeyID:   kid,
+		Payload: payload,
+	}
+	m.Signature = sign(m.signedData())
+	return m, nil
+}
+
+// replayWindowSize is the number of the latest sequences of a sender remembered by its replay window,
+// the messages out of order within the window are accepted once.
+const replayWindowSize = 64
+
+type replayWindow struct {
+	top uint64
+	// bit i is set if top - i is accepted
+	seen uint64
+}
+
+func (w *replayWindow) accept(seq uint64) bool {
+	switch {
+	case seq > w.top:
+		if shift := seq - w.top; shift < replayWindowSize {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.top = seq
+		return true
+	case w.top-seq >= replayWindowSize:
+		return false
+	default:
+		bit := uint64(1) << (w.top - seq)
+		if w.seen&bit != 0 {
+			return false
+		}
+		w.seen |= bit
+		return true
+	}
+}
+
+const verifierShards = 64
+
+type verifierShard struct {
+	sync.Mutex
+	// indexed by the node and the type, the messages of different types are delivered by different clients
+	// and may be reordered beyond the window, while the messages of a type are delivered in order
+	windows map[string]*replayWindow
+}
+
+// MessageVerifier verifies the signed messages, and rejects the replayed ones by the replay windows of the nodes.
+type MessageVerifier struct {
+	keys MessageKeys
+	// accept the plain messages as well, such as while the signatures are rolled out
+	allowUnsigned bool
+	shards        [verifierShards]verifierShard
+}
+
+// NewMessageVerifier creates a MessageVerifier verifying with keys.
+func NewMessageVerifier(keys MessageKeys, allowUnsigned bool) *MessageVerifier {
+	v := &MessageVerifier{
+		keys:          keys,
+		allowUnsigned: allowUnsigned,
+	}
+	for i := range v.shards {
+		v.shards[i].windows = make(map[string]*replayWindow)
+	}
+	return v
+}
+
+func (v *MessageVerifier) shard(node string) *verifierShard {
+	h := fnv.New32a()
+	h.Write([]byte(node))
+	return &v.shards[h.Sum32()%verifierShards]
+}
+
+// Open verifies payload as a message of type typ, and returns the signed message.
+// A plain message accepted is returned as an unsigned message with only Type and Payload, and the error
+// returned for a rejected message is a *SignatureError.
+func (v *MessageVerifier) Open(typ string, payload []byte) (*SignedMessage, error) {
+	m := &SignedMessage{}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, &SignatureError{Reason: RejectInvalid, Err: err}
+	}
+	if len(m.Signature) == 0 && len(m.Payload) == 0 {
+		if !v.allowUnsigned {
+			return nil, &SignatureError{Reason: RejectUnsigned, Err: fmt.Errorf("%s message is not signed", typ)}
+		}
+		return &SignedMessage{Type: typ, Payload: payload}, nil
+	}
+	if m.Type != typ {
+		return nil, &SignatureError{Reason: RejectInvalid, Err: fmt.Errorf("expect %s message, get %s", typ, m.Type)}
+	}
+	if err := v.keys.Verify(m.Node, m.Alg, m.KeyID, m.signedData(), m.Signature); err != nil {
+		return nil, &SignatureError{Reason: RejectInvalid, Err: fmt.Errorf("%s message of node %s: %v", typ, m.Node, err)}
+	}
+
+	s := v.shard(m.Node)
+	s.Lock()
+	defer s.Unlock()
+	key := m.Node + "/" + typ
+	w, ok := s.windows[key]
+	if !ok {
+		w = &replayWindow{}
+		s.windows[key] = w
+	}
+	if !w.accept(m.Seq) {
+		return nil, &SignatureError{Reason: RejectReplayed, Err: fmt.Errorf("%s message of node %s seq %d is replayed", typ, m.Node, m.Seq)}
+	}
+	return m, nil
+}
+
+// Forget drops the replay windows of node.
+func (v *MessageVerifier) Forget(node string) {
+	s := v.shard(node)
+	s.Lock()
+	for _, typ := range []string{SignedTypeHeartBeat, SignedTypeAck, SignedTypePod, SignedTypeCertificateRequest} {
+		delete(s.windows, node+"/"+typ)
+	}
+	s.Unlock()
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMessageSignature(t *testing.T) {
+	master := &StaticKeys{}
+	master.Add("m1", bytes.Repeat([]byte("m"), 32))
+	node := &StaticKeys{}
+	node.Add("m1", DeriveNodeKey(bytes.Repeat([]byte("m"), 32), "node-1"))
+
+	controllerKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte("c"), 32))
+	nodeKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte("n"), 32))
+	ed25519Keys := func(key ed25519.PrivateKey, peer ed25519.PublicKey) MessageKeys {
+		return &Ed25519Keys{
+			PrivateKey: func() (string, ed25519.PrivateKey, error) {
+				return Ed25519KeyID(key.Public().(ed25519.PublicKey)), key, nil
+			},
+			PublicKey: func(node, kid string) (ed25519.PublicKey, error) {
+				if kid != Ed25519KeyID(peer) {
+					return nil, fmt.Errorf("unknown key %s", kid)
+				}
+				return peer, nil
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		nodeKeys, controllerKeys MessageKeys
+	}{
+		"hmac": {
+			nodeKeys:       &HMACKeys{Source: node},
+			controllerKeys: &HMACKeys{Source: master, Derive: true},
+		},
+		"ed25519": {
+			nodeKeys:       ed25519Keys(nodeKey, controllerKey.Public().(ed25519.PublicKey)),
+			controllerKeys: ed25519Keys(controllerKey, nodeKey.Public().(ed25519.PublicKey)),
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var base uint64 = 1 << 32
+			signer := NewMessageSigner(c.nodeKeys, func() uint64 { return base })
+			verifier := NewMessageVerifier(c.controllerKeys, false)
+			hb := &HeartBeat{Name: "node-1", State: HeartBeatRegisterd}
+
+			open := func(m *SignedMessage, typ string) (*SignedMessage, error) {
+				payload, err := json.Marshal(m)
+				if err != nil {
+					t.Fatalf("marshal error %v", err)
+				}
+				return verifier.Open(typ, payload)
+			}
+			expectReject := func(m *SignedMessage, typ, reason string) {
+				t.Helper()
+				_, err := open(m, typ)
+				if serr, ok := err.(*SignatureError); !ok || serr.Reason != reason {
+					t.Errorf("expect message rejected as %s, get %v", reason, err)
+				}
+			}
+
+			messages := make([]*SignedMessage, 3)
+			for i := range messages {
+				m, err := signer.Sign(SignedTypeHeartBeat, "node-1", hb)
+				if err != nil {
+					t.Fatalf("sign error %v", err)
+				}
+				messages[i] = m
+			}
+			// the messages out of order within the window are accepted once
+			for _, i := range []int{1, 0, 2} {
+				m, err := open(messages[i], SignedTypeHeartBeat)
+				if err != nil {
+					t.Fatalf("open message %d error %v", i, err)
+				}
+				if decoded, err := UnmarshalPayloadToHeartBeat(m.Payload); err != nil || decoded.Name != hb.Name {
+					t.Errorf("unexpected payload %s, %v", m.Payload, err)
+				}
+			}
+			expectReject(messages[0], SignedTypeHeartBeat, RejectReplayed)
+
+			// the messages older than the window are rejected
+			base = 2 << 32
+			m, _ := signer.Sign(SignedTypeHeartBeat, "node-1", hb)
+			if _, err := open(m, SignedTypeHeartBeat); err != nil {
+				t.Errorf("open message after restart error %v", err)
+			}
+			old, _ := NewMessageSigner(c.nodeKeys, func() uint64 { return 1 << 32 }).Sign(SignedTypeHeartBeat, "node-1", hb)
+			expectReject(old, SignedTypeHeartBeat, RejectReplayed)
+
+			// every field is signed
+			tampered := []func(m *SignedMessage){
+				func(m *SignedMessage) { m.Payload = json.RawMessage(`{"name":"node-1","state":"Offline"}`) },
+				func(m *SignedMessage) { m.Seq++ },
+				func(m *SignedMessage) { m.Node = "node-2" },
+				func(m *SignedMessage) { m.Signature[0] ^= 1 },
+			}
+			for i, tamper := range tampered {
+				m, _ := signer.Sign(SignedTypeHeartBeat, "node-1", hb)
+				tamper(m)
+				if _, err := open(m, SignedTypeHeartBeat); err == nil {
+					t.Errorf("expect error for tampered message %d", i)
+				}
+			}
+			m, _ = signer.Sign(SignedTypeHeartBeat, "node-1", hb)
+			expectReject(m, SignedTypeAck, RejectInvalid)
+
+			// the replay windows of the types are apart
+			ack, _ := signer.Sign(SignedTypeAck, "node-1", &HeartBeatACK{NodeName: "node-1"})
+			if _, err := open(ack, SignedTypeAck); err != nil {
+				t.Errorf("open ack error %v", err)
+			}
+			if _, err := open(m, SignedTypeHeartBeat); err != nil {
+				t.Errorf("open heartbeat signed before the ack error %v", err)
+			}
+
+			unsigned, _ := json.Marshal(hb)
+			if _, err := verifier.Open(SignedTypeHeartBeat, unsigned); err == nil {
+				t.Errorf("expect error for unsigned message")
+			}
+			if m, err := NewMessageVerifier(c.controllerKeys, true).Open(SignedTypeHeartBeat, unsigned); err != nil || len(m.Alg) != 0 {
+				t.Errorf("expect unsigned message accepted, %v", err)
+			}
+
+			// the windows of a node forgotten are started over
+			verifier.Forget("node-1")
+			if _, err := open(messages[0], SignedTypeHeartBeat); err != nil {
+				t.Errorf("open message after forgetting the node error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package data
+
+import (
+	"encoding/json"
+)
+
+// NodeWill is the last will registered by a node when it connects, the broker publishes it once the node
+// is disconnected unexpectedly.
+type NodeWill struct {
+	Name string `json:"name,omitempty"`
+	// the SeqNum of the heartbeats sent by the node process registering the will, it is increased every time the node restarts
+	SeqNum uint64 `json:"seqnum,omitempty"`
+}
+
+func UnmarshalPayloadToNodeWill(payload []byte) (*NodeWill, error) {
+	d := &NodeWill{}
+	if err := json.Unmarshal(payload, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
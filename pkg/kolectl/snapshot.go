@@ -0,0 +1,324 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kolectl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/cmd/kolectl/cmd/options"
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/client/clientset/versioned"
+	"github.com/openyurtio/kole/pkg/controller"
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+// Snapshot reads and writes the snapshot generations saved in summaries by kole-controller.
+type Snapshot struct {
+	Store      *controller.SnapshotStore
+	KubeClient kubernetes.Interface
+	Options    *options.SnapshotFlags
+}
+
+func NewSnapshot(config *options.SnapshotFlags) (*Snapshot, error) {
+	c, err := clientcmd.BuildConfigFromFlags("", config.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	crdclient, err := versioned.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	kubeclient, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Store: &controller.SnapshotStore{
+			Client:    crdclient,
+			Metadata:  metadataClient,
+			Namespace: config.NameSpace,
+			Shard:     config.Shard,
+		},
+		KubeClient: kubeclient,
+		Options:    config,
+	}, nil
+}
+
+// List prints all the snapshot generations.
+func (s *Snapshot) List(out io.Writer) error {
+	generations, err := s.Store.ListGenerations()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tTIMESTAMP\tCODEC\tSUMMARIES\tCOMPLETE")
+	for _, g := range generations {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%v\n", g.Index, time.Unix(g.Timestamp, 0).Format(time.RFC3339),
+			g.Codec, len(g.Summaries), g.MaxNum, g.Complete())
+	}
+	return w.Flush()
+}
+
+func (s *Snapshot) codecOptions() (controller.CodecOptions, error) {
+	opts := controller.CodecOptions{
+		Level: s.Options.CodecLevel,
+	}
+	if len(s.Options.CodecDictionary) != 0 {
+		dictionary, err := ioutil.ReadFile(s.Options.CodecDictionary)
+		if err != nil {
+			return opts, err
+		}
+		opts.Dictionary = dictionary
+	}
+	return opts, nil
+}
+
+func (s *Snapshot) keyring() (*controller.Keyring, error) {
+	if len(s.Options.EncryptionSecret) == 0 {
+		return nil, nil
+	}
+	keyring, err := controller.NewSecretKeyring(s.KubeClient, s.Options.NameSpace, s.Options.EncryptionSecret)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Keyring, nil
+}
+
+// ReassembleSummaries sorts the summaries of a generation by BySummary and joins their data,
+// an error is returned if any chunk is missing.
+func ReassembleSummaries(g *controller.SnapshotGeneration, summaries []v1alpha1.Summary) (io.Reader, error) {
+	if len(summaries) != g.MaxNum {
+		return nil, fmt.Errorf("snapshot generation %d is incomplete, get %d of %d summaries", g.Index, len(summaries), g.MaxNum)
+	}
+	sort.Stable(controller.BySummary(summaries))
+	readers := make([]io.Reader, 0, len(summaries))
+	for i := range summaries {
+		if summaries[i].Index != i {
+			return nil, fmt.Errorf("summary %d of snapshot generation %d is missing", i, g.Index)
+		}
+		readers = append(readers, bytes.NewReader(summaries[i].Data))
+	}
+	return io.MultiReader(readers...), nil
+}
+
+// read decodes the nodes of the selected snapshot generation, and calls f with every node.
+func (s *Snapshot) read(f func(hb *data.HeartBeat) error) error {
+	generations, err := s.Store.ListGenerations()
+	if err != nil {
+		return err
+	}
+	g, err := controller.SelectSnapshotGeneration(generations, s.Options.Index)
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return fmt.Errorf("there is no complete snapshot generation in ns[%s]", s.Options.NameSpace)
+	}
+
+	list, err := s.Store.Client.LiteV1alpha1().Summaries(s.Options.NameSpace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%d,%s", util.SNAPSHOT_LABEL_IDENTIFIER, g.Index, s.Store.Selector()),
+	})
+	if err != nil {
+		return err
+	}
+	r, err := ReassembleSummaries(g, list.Items)
+	if err != nil {
+		return err
+	}
+
+	opts, err := s.codecOptions()
+	if err != nil {
+		return err
+	}
+	// the level is only used to compress
+	opts.Level = nil
+	codec, err := controller.NewCodec(g.Codec, opts)
+	if err != nil {
+		return err
+	}
+	keyring, err := s.keyring()
+	if err != nil {
+		return err
+	}
+	klog.V(4).Infof("Read snapshot generation %d saved at %s by codec %s", g.Index, time.Unix(g.Timestamp, 0), g.Codec)
+
+	reader, err := controller.AsStreamProcesser(controller.NewSnapshotProcesser(codec, keyring, s.Options.AllowUnencrypted)).NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return controller.DecodeHeartBeats(reader, func(name string, hb *data.HeartBeat) error {
+		if !s.match(hb) {
+			return nil
+		}
+		return f(hb)
+	})
+}
+
+func (s *Snapshot) match(hb *data.HeartBeat) bool {
+	if len(s.Options.State) != 0 && hb.State != s.Options.State {
+		return false
+	}
+	if len(s.Options.Nodes) == 0 {
+		return true
+	}
+	for _, n := range s.Options.Nodes {
+		if n == hb.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect prints the nodes of the selected snapshot generation.
+func (s *Snapshot) Inspect(out io.Writer) error {
+	hbs := make([]*data.HeartBeat, 0, 1024)
+	if err := s.read(func(hb *data.HeartBeat) error {
+		hbs = append(hbs, hb)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(hbs, func(i, j int) bool {
+		return hbs[i].Name < hbs[j].Name
+	})
+
+	if s.Options.Output == options.OutputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(hbs)
+	}
+	return PrintNodes(out, hbs)
+}
+
+// PrintNodes prints nodes as a table.
+func PrintNodes(out io.Writer, hbs []*data.HeartBeat) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tSEQNUM\tTIMESTAMP\tADDRESSES\tPODS")
+	for _, hb := range hbs {
+		addresses := make([]string, 0, 2)
+		if hb.Status != nil {
+			for _, a := range hb.Status.Addresses {
+				addresses = append(addresses, a.Address)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\n", hb.Name, hb.State, hb.SeqNum,
+			time.Unix(hb.TimeStamp, 0).Format(time.RFC3339), strings.Join(addresses, ","), len(hb.Pods))
+	}
+	return w.Flush()
+}
+
+// Export writes the nodes of the selected snapshot generation to file as json.
+func (s *Snapshot) Export(file string) error {
+	hbs := make([]*data.HeartBeat, 0, 1024)
+	if err := s.read(func(hb *data.HeartBeat) error {
+		hbs = append(hbs, hb)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := controller.EncodeHeartBeats(f, hbs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	klog.Infof("Export %d nodes to %s", len(hbs), file)
+	return nil
+}
+
+// Import saves the nodes in file as a new snapshot generation.
+func (s *Snapshot) Import(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hbs := make([]*data.HeartBeat, 0, 1024)
+	if err := controller.DecodeHeartBeats(f, func(name string, hb *data.HeartBeat) error {
+		if name != hb.Name {
+			return fmt.Errorf("node %s is saved as %s", hb.Name, name)
+		}
+		hbs = append(hbs, hb)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("decode %s error %v", file, err)
+	}
+
+	generations, err := s.Store.ListGenerations()
+	if err != nil {
+		return err
+	}
+	index := s.Options.Index
+	if index < 0 {
+		index = 0
+		if len(generations) != 0 {
+			index = generations[len(generations)-1].Index + 1
+		}
+	}
+	for _, g := range generations {
+		if g.Index == index {
+			return fmt.Errorf("snapshot generation %d already exists", index)
+		}
+	}
+
+	opts, err := s.codecOptions()
+	if err != nil {
+		return err
+	}
+	codec, err := controller.NewCodec(s.Options.Codec, opts)
+	if err != nil {
+		return err
+	}
+	keyring, err := s.keyring()
+	if err != nil {
+		return err
+	}
+
+	names, _, err := s.Store.SaveGeneration(index, codec.Name(), controller.NewSnapshotProcesser(codec, keyring, false), hbs)
+	if err != nil {
+		return fmt.Errorf("save snapshot generation %d error %v", index, err)
+	}
+	klog.Infof("Import %d nodes as snapshot generation %d with %d summaries", len(hbs), index, len(names))
+	return nil
+}
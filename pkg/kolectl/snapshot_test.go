@@ -0,0 +1,48 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package kolectl
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/openyurtio/kole/pkg/apis/lite/v1alpha1"
+	"github.com/openyurtio/kole/pkg/controller"
+)
+
+func TestReassembleSummaries(t *testing.T) {
+	g := &controller.SnapshotGeneration{Index: 1, MaxNum: 3}
+	summaries := []v1alpha1.Summary{
+		{Index: 2, Data: []byte("c")},
+		{Index: 0, Data: []byte("a")},
+		{Index: 1, Data: []byte("b")},
+	}
+	r, err := ReassembleSummaries(g, summaries)
+	if err != nil {
+		t.Fatalf("reassemble summaries error %v", err)
+	}
+	if d, err := ioutil.ReadAll(r); err != nil || string(d) != "abc" {
+		t.Errorf("unexpected data %s, error %v", d, err)
+	}
+
+	if _, err := ReassembleSummaries(g, summaries[:2]); err == nil {
+		t.Errorf("expect error for incomplete generation")
+	}
+	summaries[0].Index = 3
+	if _, err := ReassembleSummaries(g, summaries); err == nil {
+		t.Errorf("expect error for missing summary")
+	}
+}
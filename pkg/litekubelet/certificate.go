@@ -0,0 +1,209 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package litekubelet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/cache"
+	"github.com/openyurtio/kole/pkg/data"
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+const (
+	// certificateRequestTimeout is the time the node waits for the certificate requested
+	certificateRequestTimeout = 30 * time.Second
+	// the certificate is renewed at a random time between 70% and 90% of its lifetime,
+	// so the nodes started together do not renew together
+	certificateRenewAt     = 0.7
+	certificateRenewJitter = 0.2
+)
+
+// CertificateManager requests the client certificate of the node for MQTT mTLS from the controllers,
+// and renews it before it expires. The certificate is kept in a file, so it is reused after the node restarts.
+type CertificateManager struct {
+	lite     *LiteKubelet
+	identity *NodeIdentity
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertificateManager creates the certificate manager of the node of identity, the certificate kept is loaded
+// if it is issued for the key of the node.
+func NewCertificateManager(lite *LiteKubelet, identity *NodeIdentity) *CertificateManager {
+	m := &CertificateManager{
+		lite:     lite,
+		identity: identity,
+	}
+	if d, err := ioutil.ReadFile(identity.CertFile); err == nil {
+		if cert, err := m.parse(d); err != nil {
+			klog.Warningf("Drop certificate file %s: %v", identity.CertFile, err)
+		} else {
+			m.cert = cert
+		}
+	} else if !os.IsNotExist(err) {
+		klog.Warningf("Read certificate file %s error %v", identity.CertFile, err)
+	}
+	return m
+}
+
+// Current returns the current certificate, nil if no valid certificate is issued.
+func (m *CertificateManager) Current() *tls.Certificate {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil || time.Now().After(m.cert.Leaf.NotAfter) {
+		return nil
+	}
+	return m.cert
+}
+
+// GetClientCertificate returns the current certificate for the tls handshakes of the MQTT clients,
+// no certificate is presented if it is not issued yet.
+func (m *CertificateManager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := m.Current(); cert != nil {
+		return cert, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// parse returns the certificate in the PEM encoded data, which must be issued for the key of the node.
+func (m *CertificateManager) parse(d []byte) (*tls.Certificate, error) {
+	block, _ := pem.Decode(d)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("certificate is not PEM encoded")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !m.identity.Key.PublicKey.Equal(leaf.PublicKey) {
+		return nil, fmt.Errorf("certificate is not issued for the key of node %s", m.identity.Name)
+	}
+	if leaf.Subject.CommonName != m.identity.Name {
+		return nil, fmt.Errorf("certificate is issued for node %s", leaf.Subject.CommonName)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %v", leaf.NotAfter)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{block.Bytes},
+		PrivateKey:  m.identity.Key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func renewTime(leaf *x509.Certificate) time.Time {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotBefore.Add(time.Duration(float64(lifetime) * (certificateRenewAt + certificateRenewJitter*mrand.Float64())))
+}
+
+// Run requests the certificate once the node is registered, and renews it before it expires.
+func (m *CertificateManager) Run() {
+	newBackoff := func() wait.Backoff {
+		return wait.Backoff{
+			Duration: registerRetryInitialDelay,
+			Factor:   2,
+			Jitter:   0.5,
+			Steps:    math.MaxInt32,
+			Cap:      registerRetryMaxDelay,
+		}
+	}
+	backoff := newBackoff()
+	for {
+		if cert := m.Current(); cert != nil {
+			at := renewTime(cert.Leaf)
+			klog.V(4).Infof("Certificate of node %s expires at %v, renew it at %v", m.identity.Name, cert.Leaf.NotAfter, at)
+			time.Sleep(time.Until(at))
+		}
+		retryAfter, err := m.renew()
+		if err != nil {
+			delay := backoff.Step()
+			if retryAfter > delay {
+				delay = wait.Jitter(retryAfter, 0.2)
+			}
+			klog.Errorf("%s request certificate error %v, retry after %v", m.identity.Name, err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		backoff = newBackoff()
+	}
+}
+
+// renew requests a new certificate for the key of the node, the time to retry is returned if it is rejected.
+func (m *CertificateManager) renew() (time.Duration, error) {
+	request, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   m.identity.Name,
+			Organization: []string{data.CertificateOrganization},
+		},
+	}, m.identity.Key)
+	if err != nil {
+		return 0, err
+	}
+	req := &data.CertificateRequest{
+		Name:       m.identity.Name,
+		Identifier: fmt.Sprintf("%v", uuid.New()),
+		Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+	}
+	var object interface{} = req
+	if m.lite.MessageSigner != nil {
+		if object, err = m.lite.MessageSigner.Sign(data.SignedTypeCertificateRequest, m.identity.Name, req); err != nil {
+			return 0, err
+		}
+	}
+	if err := m.lite.MessageHandler.PublishData(context.Background(), util.TopicCertificateRequest, 1, false, object); err != nil {
+		return 0, err
+	}
+
+	ack, ok := cache.GetDefaultTimeoutCache().PopWait(req.Identifier, certificateRequestTimeout)
+	if !ok {
+		return 0, fmt.Errorf("certificate request %s time out", req.Identifier)
+	}
+	a := ack.(*data.HeartBeatACK)
+	if len(a.Certificate) == 0 {
+		return time.Duration(a.RetryAfter) * time.Second, fmt.Errorf("certificate request %s is rejected", req.Identifier)
+	}
+	cert, err := m.parse(a.Certificate)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(m.identity.CertFile, a.Certificate, 0644); err != nil {
+		// the certificate is still used until the node restarts
+		klog.Errorf("Write certificate file %s error %v", m.identity.CertFile, err)
+	}
+	m.lock.Lock()
+	m.cert = cert
+	m.lock.Unlock()
+	klog.Infof("Certificate of node %s is issued, expires at %v", m.identity.Name, cert.Leaf.NotAfter)
+	return 0, nil
+}
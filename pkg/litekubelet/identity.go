@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package litekubelet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+)
+
+// NodeIdentity is the identity of a node created at its first boot and kept in PersistentDir,
+// so the node keeps its name and key across restarts.
+type NodeIdentity struct {
+	Name string `json:"name"`
+	// Key is the key of the client certificates of the node
+	Key *ecdsa.PrivateKey `json:"-"`
+	// the files of the identity
+	file    string
+	keyFile string
+	// CertFile keeps the latest client certificate of the node
+	CertFile string `json:"-"`
+}
+
+// loadOrCreateIdentity loads the identity of simulation index in dir, it is created if the node boots for the first time.
+// The node is named after podName if it is set, otherwise it keeps the random name given at its first boot.
+func loadOrCreateIdentity(dir, podName string, index int) (*NodeIdentity, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		klog.Errorf("mkdir %s error %v", dir, err)
+		return nil, err
+	}
+	i := &NodeIdentity{
+		file:    filepath.Join(dir, fmt.Sprintf("identity-%d.json", index)),
+		keyFile: filepath.Join(dir, fmt.Sprintf("identity-%d.key", index)),
+
+		CertFile: filepath.Join(dir, fmt.Sprintf("identity-%d.crt", index)),
+	}
+
+	if d, err := ioutil.ReadFile(i.file); err == nil {
+		if err := json.Unmarshal(d, i); err != nil {
+			klog.Errorf("Unmarshal identity file %s error %v", i.file, err)
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		klog.Errorf("Read identity file %s error %v", i.file, err)
+		return nil, err
+	}
+	name := i.Name
+	if len(podName) != 0 {
+		name = fmt.Sprintf("%s-%d", podName, index)
+	} else if len(name) == 0 {
+		name = fmt.Sprintf("%s-%d", uuid.New(), index)
+	}
+	if name != i.Name {
+		i.Name = name
+		d, err := json.Marshal(i)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(i.file, d, 0644); err != nil {
+			klog.Errorf("Write identity file %s error %v", i.file, err)
+			return nil, err
+		}
+	}
+
+	if d, err := ioutil.ReadFile(i.keyFile); err == nil {
+		block, _ := pem.Decode(d)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("key file %s is not a PEM encoded EC private key", i.keyFile)
+		}
+		if i.Key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %v", i.keyFile, err)
+		}
+		return i, nil
+	} else if !os.IsNotExist(err) {
+		klog.Errorf("Read key file %s error %v", i.keyFile, err)
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(i.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		klog.Errorf("Write key file %s error %v", i.keyFile, err)
+		return nil, err
+	}
+	klog.Infof("Create identity of node %s in %s", i.Name, dir)
+	i.Key = key
+	return i, nil
+}
+
+// writeFileAtomic writes data to file by renaming a temporary file, so the file is never seen half written.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
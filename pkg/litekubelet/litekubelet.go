@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"time"
@@ -26,7 +27,7 @@ import (
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
 	outmqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 
 	"github.com/openyurtio/kole/cmd/lite-kubelet/app/options"
@@ -47,25 +48,43 @@ type LiteKubelet struct {
 	Sub5CtlChan      chan *paho.Publish
 	Sub5DataChan     chan *paho.Publish
 	HostnameOverride string
+	// presented by the registering heartbeats to be approved
+	BootstrapToken string
 	// s
 	HeartBeatInterval int
 	SubTopics         map[string]outmqtt.MessageHandler
 	SeqNum            uint64
 	IndexFlag         int
 	ReceivePodDataNum int
+	// signs the heartbeats and verifies the acks and pods, nil if the messages are not signed
+	MessageSigner   *data.MessageSigner
+	MessageVerifier *data.MessageVerifier
+	// the number of the acks and pods rejected by the signature verification
+	RejectedMessageNum int64
+	// the persistent identity of the node
+	Identity *NodeIdentity
+	// requests and renews the client certificate of the node, nil if the certificate is not requested
+	Certificates *CertificateManager
+	// signaled when the controllers ask the registered node to register again
+	reregister chan struct{}
 }
 
+const (
+	registerRetryInitialDelay = 2 * time.Second
+	registerRetryMaxDelay     = 5 * time.Minute
+)
+
 type PersistentData struct {
 	HostNameOverride string `json:"host_name_override"`
 	SeqNum           uint64 `json:"seq_num"`
 }
 
 func NewMainLiteKubelet(deps *options.LiteKubeletFlags, index int, ismqtt5 bool) (*LiteKubelet, error) {
-	hostname := os.Getenv("POD_NAME")
-	if len(hostname) == 0 {
-		hostname = fmt.Sprintf("%s", uuid.New())
+	identity, err := loadOrCreateIdentity(deps.PersistentDir, os.Getenv("POD_NAME"), index)
+	if err != nil {
+		return nil, err
 	}
-	hostnameOverride := fmt.Sprintf("%s-%d", hostname, index)
+	hostnameOverride := identity.Name
 
 	seqNum, err := syncPersistentFile(deps.PersistentDir, hostnameOverride)
 	if err != nil {
@@ -74,6 +93,7 @@ func NewMainLiteKubelet(deps *options.LiteKubeletFlags, index int, ismqtt5 bool)
 
 	lite := &LiteKubelet{
 		HostnameOverride:  hostnameOverride,
+		BootstrapToken:    deps.BootstrapToken,
 		HeartBeatInterval: deps.HeartBeatInterval,
 		SubTopics:         make(map[string]outmqtt.MessageHandler),
 		SeqNum:            seqNum,
@@ -81,16 +101,69 @@ func NewMainLiteKubelet(deps *options.LiteKubeletFlags, index int, ismqtt5 bool)
 		Sub5DataChan:      make(chan *paho.Publish, 1000),
 		IsMqtt5:           ismqtt5,
 		IndexFlag:         index,
+		Identity:          identity,
+		reregister:        make(chan struct{}, 1),
+	}
+	if deps.EnableClientCertificate {
+		lite.Certificates = NewCertificateManager(lite, identity)
+	}
+
+	keys, err := newMessageKeys(deps)
+	if err != nil {
+		klog.Errorf("Load message signing keys error %v", err)
+		return nil, err
+	}
+	if keys != nil {
+		// the sequences of a boot are above those of the previous boots, since SeqNum is increased at every boot
+		lite.MessageSigner = data.NewMessageSigner(keys, func() uint64 {
+			return seqNum << 32
+		})
+		lite.MessageVerifier = data.NewMessageVerifier(keys, deps.AllowUnsignedMessages)
+	}
+
+	// the broker publishes the will once the node is disconnected, so the node is set offline without waiting for the heartbeat timeout
+	willMessage, err := lite.willMessage(&data.NodeWill{
+		Name:   hostnameOverride,
+		SeqNum: seqNum,
+	})
+	if err != nil {
+		klog.Errorf("Sign will error %v", err)
+		return nil, err
+	}
+	willPayload, err := json.Marshal(willMessage)
+	if err != nil {
+		klog.Errorf("Marshal will error %v", err)
+		return nil, err
+	}
+	will := &message.Will{
+		Topic:   util.TopicWill,
+		Payload: willPayload,
+		QoS:     1,
+		Delay:   uint32(deps.Mqtt5Flags.WillDelay),
+	}
+
+	tlsOptions := deps.MqttTLS
+	if lite.Certificates != nil && (tlsOptions.Configured() || !deps.IsMqtt5) {
+		// the certificate issued to the node is presented once it is issued, the connections are always tls with mqtt3
+		nodeTLS := *tlsOptions
+		nodeTLS.GetClientCertificate = lite.Certificates.GetClientCertificate
+		tlsOptions = &nodeTLS
+	}
+
+	auth, err := message.NewAuthProvider(deps.MqttAuth, deps.Mqtt3Flags.MqttInstance)
+	if err != nil {
+		klog.Errorf("New mqtt auth %s error %v", deps.MqttAuth.Provider, err)
+		return nil, err
 	}
 
 	if !deps.IsMqtt5 {
 		// mqtt3
-		h, err := message.NewMqtt3Handler(deps.Mqtt3Flags.MqttBroker, deps.Mqtt3Flags.MqttBrokerPort, deps.Mqtt3Flags.MqttInstance, deps.Mqtt3Flags.MqttGroup,
+		h, err := message.NewMqtt3Handler(deps.Mqtt3Flags.MqttBroker, deps.Mqtt3Flags.MqttBrokerPort, deps.Mqtt3Flags.MqttGroup,
 			hostnameOverride,
 			map[string]outmqtt.MessageHandler{
 				filepath.Join(util.TopicCTLPrefix, lite.HostnameOverride):  lite.SubCTL,
 				filepath.Join(util.TopicDataPrefix, lite.HostnameOverride): lite.SubData,
-			})
+			}, will, tlsOptions, auth)
 		if err != nil {
 			return nil, err
 		}
@@ -99,7 +172,7 @@ func NewMainLiteKubelet(deps *options.LiteKubeletFlags, index int, ismqtt5 bool)
 	} else {
 		// mqtt5
 		// mqtt 5
-		h, err := message.NewMqtt5Handler(deps.Mqtt5Flags.MqttServer, lite.CreateSubscribes5(), hostnameOverride, true)
+		h, err := message.NewMqtt5Handler(deps.Mqtt5Flags.MqttServer, lite.CreateSubscribes5(), hostnameOverride, true, will, tlsOptions, auth)
 		if err != nil {
 			return nil, err
 		}
@@ -112,21 +185,51 @@ func NewMainLiteKubelet(deps *options.LiteKubeletFlags, index int, ismqtt5 bool)
 }
 
 func (l *LiteKubelet) runRealyLoop() {
-	var hb *data.HeartBeat
-	var err error
+	var certificatesStarted bool
+	for {
+		hb := l.register()
+		l.Registerd = true
+		// the certificates are only issued to the registered nodes
+		if l.Certificates != nil && !certificatesStarted {
+			certificatesStarted = true
+			go l.Certificates.Run()
+		}
+
+		l.registerdHeartBeatLoop(hb)
+		l.Registerd = false
+		klog.Warningf("%s is not approved by the controllers, register again", l.HostnameOverride)
+	}
+}
 
+// register sends the registering heartbeats until the node is registered.
+func (l *LiteKubelet) register() *data.HeartBeat {
+	// the nodes powered on together retry at different times
+	backoff := wait.Backoff{
+		Duration: registerRetryInitialDelay,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    math.MaxInt32,
+		Cap:      registerRetryMaxDelay,
+	}
 	for {
-		hb, err = l.registeringHeartBeat(true)
+		hb, err := l.registeringHeartBeat(true)
 		if err != nil {
-			klog.Errorf("%s Registering heatbeat error %v", l.HostnameOverride, err)
-			time.Sleep(time.Second * 10)
+			delay := backoff.Step()
+			if rejected, ok := err.(*RegisterRejectedError); ok && rejected.RetryAfter > delay {
+				// the controller knows when the node can be admitted
+				delay = wait.Jitter(rejected.RetryAfter, 0.2)
+			}
+			klog.Errorf("%s Registering heatbeat error %v, retry after %v", l.HostnameOverride, err, delay)
+			time.Sleep(delay)
 			continue
 		}
-		break
+		// the request to register again is for the heartbeats sent before
+		select {
+		case <-l.reregister:
+		default:
+		}
+		return hb
 	}
-	l.Registerd = true
-
-	l.registerdHeartBeatLoop(hb)
 }
 
 func (l *LiteKubelet) Run() {
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package litekubelet
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/cmd/lite-kubelet/app/options"
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// newMessageKeys loads the keys of the message signature, nil if the messages are not signed.
+func newMessageKeys(deps *options.LiteKubeletFlags) (data.MessageKeys, error) {
+	switch deps.MessageSignature {
+	case "":
+		return nil, nil
+	case data.SignatureHMAC:
+		keys, err := data.LoadKeyFile(deps.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &data.HMACKeys{Source: keys}, nil
+	case data.SignatureEd25519:
+		keys, err := data.LoadKeyFile(deps.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		_, seed := keys.ActiveKey()
+		key, err := data.Ed25519SeedKey(seed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key file %s: %v", deps.SigningKeyFile, err)
+		}
+		kid := data.Ed25519KeyID(key.Public().(ed25519.PublicKey))
+		controllerKeys, err := data.LoadKeyFile(deps.ControllerPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &data.Ed25519Keys{
+			PrivateKey: func() (string, ed25519.PrivateKey, error) {
+				return kid, key, nil
+			},
+			PublicKey: func(node, kid string) (ed25519.PublicKey, error) {
+				key, ok := controllerKeys.Key(kid)
+				if !ok || len(key) != ed25519.PublicKeySize {
+					return nil, fmt.Errorf("ed25519 public key %q of the controllers is not found", kid)
+				}
+				return key, nil
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("message signature %q is not supported", deps.MessageSignature)
+}
+
+// heartBeatMessage returns the message of hb to publish, which is signed if the messages are signed.
+func (l *LiteKubelet) heartBeatMessage(hb *data.HeartBeat) (interface{}, error) {
+	if l.MessageSigner == nil {
+		return hb, nil
+	}
+	return l.MessageSigner.Sign(data.SignedTypeHeartBeat, l.HostnameOverride, hb)
+}
+
+// willMessage returns the will to register at connect, which is signed if the messages are signed.
+// The will is signed once at the boot, so its sequence is the first one of the boot derived from SeqNum.
+func (l *LiteKubelet) willMessage(will *data.NodeWill) (interface{}, error) {
+	if l.MessageSigner == nil {
+		return will, nil
+	}
+	return l.MessageSigner.Sign(data.SignedTypeWill, l.HostnameOverride, will)
+}
+
+// openMessage verifies payload as a message of type typ sent to the node, and returns the message in it.
+func (l *LiteKubelet) openMessage(typ string, payload []byte) ([]byte, error) {
+	if l.MessageVerifier == nil {
+		return payload, nil
+	}
+	m, err := l.MessageVerifier.Open(typ, payload)
+	if err == nil && len(m.Alg) != 0 && m.Node != l.HostnameOverride {
+		err = &data.SignatureError{Reason: data.RejectInvalid, Err: fmt.Errorf("%s message is sent to node %s", typ, m.Node)}
+	}
+	if err != nil {
+		atomic.AddInt64(&l.RejectedMessageNum, 1)
+		klog.Errorf("%s reject %s message: %v", l.HostnameOverride, typ, err)
+		return nil, err
+	}
+	return m.Payload, nil
+}
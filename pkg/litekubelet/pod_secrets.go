@@ -0,0 +1,50 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package litekubelet
+
+import (
+	"fmt"
+
+	"github.com/openyurtio/kole/pkg/data"
+)
+
+// openPodSecrets resolves the env of pod, opening the values sealed to the identity of the node.
+// The sealed values are dropped once opened, so the secrets are only kept in memory.
+func (l *LiteKubelet) openPodSecrets(pod *data.Pod) error {
+	// deleted pods are sent without their secrets
+	if pod.DeleteTimeStamp != nil || len(pod.Spec.Env) == 0 {
+		return nil
+	}
+	env := make(map[string]string, len(pod.Spec.Env))
+	for _, e := range pod.Spec.Env {
+		if e.SecretKeyRef == nil {
+			env[e.Name] = e.Value
+			continue
+		}
+		sealed, ok := pod.SealedEnv[e.Name]
+		if !ok {
+			return fmt.Errorf("env %s has no sealed value", e.Name)
+		}
+		value, err := sealed.Open(l.Identity.Key, data.SealedEnvAAD(l.HostnameOverride, pod.Key(), e.Name))
+		if err != nil {
+			return fmt.Errorf("open env %s error %v", e.Name, err)
+		}
+		env[e.Name] = string(value)
+	}
+	pod.Env = env
+	pod.SealedEnv = nil
+	return nil
+}
@@ -29,6 +29,21 @@ import (
 	"github.com/openyurtio/kole/pkg/util"
 )
 
+// RegisterRejectedError is returned when the controller rejects the registering, the node retries after RetryAfter.
+type RegisterRejectedError struct {
+	Name       string
+	RetryAfter time.Duration
+	// the node is waiting for approval
+	Pending bool
+}
+
+func (e *RegisterRejectedError) Error() string {
+	if e.Pending {
+		return fmt.Sprintf("registering pending for approval: node %s retry after %v", e.Name, e.RetryAfter)
+	}
+	return fmt.Sprintf("registering rejected: node %s retry after %v", e.Name, e.RetryAfter)
+}
+
 func (l *LiteKubelet) initHeartBeat() *data.HeartBeat {
 	hb := util.InitMockHeartBeat(l.HostnameOverride, l.SeqNum)
 	/*
@@ -59,7 +74,12 @@ func (l *LiteKubelet) sendHeartBeat(hb *data.HeartBeat, qos byte, needACK bool)
 
 	topic := util.TopicHeartBeat
 
-	if err := l.MessageHandler.PublishData(context.Background(), topic, 0, false, hb); err != nil {
+	m, err := l.heartBeatMessage(hb)
+	if err != nil {
+		klog.Errorf("Sign heartbeat error %v", err)
+		return err
+	}
+	if err := l.MessageHandler.PublishData(context.Background(), topic, qos, false, m); err != nil {
 		return err
 	}
 	hbdata, err := json.Marshal(hb)
@@ -73,7 +93,10 @@ func (l *LiteKubelet) sendHeartBeat(hb *data.HeartBeat, qos byte, needACK bool)
 		if !ok {
 			return fmt.Errorf("registering time out: node %s indentifier %s send topic %s, state %s", hb.Name, hb.Identifier, hb.State, topic)
 		}
-		if !ack.(*data.HeartBeatACK).Registerd {
+		if a := ack.(*data.HeartBeatACK); !a.Registerd {
+			if a.RetryAfter > 0 {
+				return &RegisterRejectedError{Name: hb.Name, RetryAfter: time.Duration(a.RetryAfter) * time.Second, Pending: a.Pending}
+			}
 			return fmt.Errorf("ack data is false: node %s indentifier %s send topic %s, state %s", hb.Name, hb.Identifier, hb.State, topic)
 		}
 		klog.V(4).Infof("#### data len %d , registering successful node %s", len(hbdata), hb.Name)
@@ -85,9 +108,18 @@ func (l *LiteKubelet) sendHeartBeat(hb *data.HeartBeat, qos byte, needACK bool)
 
 func (l *LiteKubelet) registeringHeartBeat(needAck bool) (*data.HeartBeat, error) {
 	hb := l.initHeartBeat()
-	if err := l.sendHeartBeat(hb, 1, needAck); err != nil {
+	hb.BootstrapToken = l.BootstrapToken
+	encryptionKey, err := data.MarshalEncryptionKey(&l.Identity.Key.PublicKey)
+	if err != nil {
 		return nil, err
 	}
+	hb.EncryptionKey = encryptionKey
+	if err := l.sendHeartBeat(hb, 0, needAck); err != nil {
+		return nil, err
+	}
+	// the token and the encryption key are only presented when registering
+	hb.BootstrapToken = ""
+	hb.EncryptionKey = nil
 	return hb, nil
 }
 
@@ -98,6 +130,13 @@ func (l *LiteKubelet) registerdHeartBeat(hb *data.HeartBeat) {
 	l.sendHeartBeat(hb, 0, false)
 }
 
+// Unregister tells the controllers that the node is shutting down cleanly, so it is removed at once instead of going offline.
+func (l *LiteKubelet) Unregister() error {
+	hb := l.initHeartBeat()
+	hb.State = data.HeartBeatUnregistering
+	return l.sendHeartBeat(hb, 1, false)
+}
+
 func (l *LiteKubelet) syncHeartBeat(hb *data.HeartBeat) {
 	localPodsLock.Lock()
 	defer localPodsLock.Unlock()
@@ -127,6 +166,20 @@ func (l *LiteKubelet) registerdHeartBeatLoop(hb *data.HeartBeat) {
 		case <-ticker.C:
 			l.syncHeartBeat(hb)
 			l.registerdHeartBeat(hb)
+		case <-l.reregister:
+			return
+		}
+	}
+}
+
+// handleAck hands ack to the registering heartbeat waiting for it, or makes the registered node register again.
+func (l *LiteKubelet) handleAck(ack *data.HeartBeatACK) {
+	if ack.Reregister {
+		select {
+		case l.reregister <- struct{}{}:
+		default:
 		}
+		return
 	}
+	cache.GetDefaultTimeoutCache().Set(ack.Identifier, ack)
 }
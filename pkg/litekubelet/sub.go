@@ -22,7 +22,6 @@ import (
 	outmqtt "github.com/eclipse/paho.mqtt.golang"
 	"k8s.io/klog/v2"
 
-	"github.com/openyurtio/kole/pkg/cache"
 	"github.com/openyurtio/kole/pkg/data"
 	"github.com/openyurtio/kole/pkg/util"
 )
@@ -49,21 +48,33 @@ func (c *LiteKubelet) RegisterAndSubscribeTopic() error {
 }
 
 func (c *LiteKubelet) SubCTL(client outmqtt.Client, message outmqtt.Message) {
-	ack, err := data.UnmarshalPayloadToHeartBeatACK(message.Payload())
+	payload, err := c.openMessage(data.SignedTypeAck, message.Payload())
+	if err != nil {
+		return
+	}
+	ack, err := data.UnmarshalPayloadToHeartBeatACK(payload)
 	if err != nil {
 		klog.Errorf("Unmarshalpayload to headbeatack error %v", err)
 		return
 	}
-	cache.GetDefaultTimeoutCache().Set(ack.Identifier, ack)
+	c.handleAck(ack)
 	klog.V(5).Infof("Sub heatbeat topic %s", message.Topic())
 }
 
 func (c *LiteKubelet) SubData(client outmqtt.Client, message outmqtt.Message) {
-	pod, err := data.UnmarshalPayloadToPod(message.Payload())
+	payload, err := c.openMessage(data.SignedTypePod, message.Payload())
+	if err != nil {
+		return
+	}
+	pod, err := data.UnmarshalPayloadToPod(payload)
 	if err != nil {
 		klog.Errorf("Unmarshalpayload to Pod error %v", err)
 		return
 	}
+	if err := c.openPodSecrets(pod); err != nil {
+		klog.Errorf("Open secrets of pod %s error %v", pod.Key(), err)
+		return
+	}
 	SyncLocalPod(pod)
 	klog.V(5).Infof("sub data topic %s", message.Topic())
 }
@@ -21,7 +21,6 @@ import (
 	"github.com/eclipse/paho.golang/paho"
 	"k8s.io/klog/v2"
 
-	"github.com/openyurtio/kole/pkg/cache"
 	"github.com/openyurtio/kole/pkg/data"
 	"github.com/openyurtio/kole/pkg/message"
 	"github.com/openyurtio/kole/pkg/util"
@@ -58,12 +57,16 @@ func (c *LiteKubelet) ConsumeSubLoop() {
 	// CTL
 	go func() {
 		for p := range c.Sub5CtlChan {
-			ack, err := data.UnmarshalPayloadToHeartBeatACK(p.Payload)
+			payload, err := c.openMessage(data.SignedTypeAck, p.Payload)
+			if err != nil {
+				continue
+			}
+			ack, err := data.UnmarshalPayloadToHeartBeatACK(payload)
 			if err != nil {
 				klog.Errorf("Unmarshalpayload to headbeatack error %v", err)
 				return
 			}
-			cache.GetDefaultTimeoutCache().Set(ack.Identifier, ack)
+			c.handleAck(ack)
 			klog.V(5).Infof("Sub heatbeat topic %s", p.Topic)
 		}
 	}()
@@ -71,14 +74,22 @@ func (c *LiteKubelet) ConsumeSubLoop() {
 	//Data
 	go func() {
 		for p := range c.Sub5DataChan {
-			pod, err := data.UnmarshalPayloadToPod(p.Payload)
+			payload, err := c.openMessage(data.SignedTypePod, p.Payload)
+			if err != nil {
+				continue
+			}
+			pod, err := data.UnmarshalPayloadToPod(payload)
 			if err != nil {
 				klog.Errorf("Unmarshalpayload to Pod error %v", err)
 				return
 			}
 			c.ReceivePodDataNum++
+			if err := c.openPodSecrets(pod); err != nil {
+				klog.Errorf("Open secrets of pod %s error %v", pod.Key(), err)
+				continue
+			}
 			SyncLocalPod(pod)
-			klog.V(4).Infof("Sub data topic %s ,data %s", p.Topic, p.Payload)
+			klog.V(4).Infof("Sub data topic %s ,data %s", p.Topic, payload)
 		}
 	}()
 }
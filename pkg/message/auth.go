@@ -0,0 +1,324 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package message
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+const (
+	// AuthNone connects without credentials
+	AuthNone = "none"
+	// AuthStatic connects with a static username and password
+	AuthStatic = "static"
+	// AuthAliyun connects with the Aliyun signature of the client id by ACCESS_KEY and ACCESS_SECRET
+	AuthAliyun = "aliyun"
+	// AuthJWT connects with a JWT signed by the key of the client as the password, which is refreshed before it expires
+	AuthJWT = "jwt"
+	// AuthClientCertificate authenticates the client by its TLS client certificate
+	AuthClientCertificate = "client-certificate"
+)
+
+// AuthProvider provides the credentials of the MQTT clients.
+type AuthProvider interface {
+	// Credentials returns the username and password of the client connecting the broker. It is called at every connection,
+	// so the expiring credentials are refreshed for the reconnections.
+	Credentials(clientID string) (username, password string, err error)
+}
+
+// AuthOptions selects and configures the AuthProvider of the MQTT clients.
+type AuthOptions struct {
+	// the auth provider, none, static, aliyun, jwt or client-certificate
+	Provider string
+	// the username of static, jwt and client-certificate, default to the client id with jwt
+	Username string
+	// the file of the password of static
+	PasswordFile string
+	// the PEM key signing the JWTs, ES256 for an ecdsa P-256 key and RS256 for an rsa key
+	JWTKeyFile string
+	// the aud claim of the JWTs
+	JWTAudience string
+	// the lifetime (s) of the JWTs
+	JWTLifetime int
+}
+
+// Validate checks the options of the provider, the client certificate must be configured by tls for client-certificate.
+func (o *AuthOptions) Validate(tlsOptions *TLSOptions, hasClientCertificate bool) error {
+	switch o.Provider {
+	case AuthNone, AuthAliyun:
+	case AuthStatic:
+		if len(o.Username) == 0 {
+			return fmt.Errorf("username must be set with auth %s", o.Provider)
+		}
+	case AuthJWT:
+		if len(o.JWTKeyFile) == 0 {
+			return fmt.Errorf("jwt key file must be set with auth %s", o.Provider)
+		}
+		if o.JWTLifetime < 60 {
+			return fmt.Errorf("jwt lifetime must be at least 60s")
+		}
+	case AuthClientCertificate:
+		if !hasClientCertificate && (tlsOptions == nil || len(tlsOptions.CertFile) == 0) {
+			return fmt.Errorf("a tls client certificate must be configured with auth %s", o.Provider)
+		}
+	default:
+		return fmt.Errorf("unsupported mqtt auth %s, must be %s, %s, %s, %s or %s", o.Provider,
+			AuthNone, AuthStatic, AuthAliyun, AuthJWT, AuthClientCertificate)
+	}
+	return nil
+}
+
+// NewAuthProvider creates the AuthProvider of the options, instance is the Aliyun instance of aliyun.
+// No provider is returned with none.
+func NewAuthProvider(o *AuthOptions, instance string) (AuthProvider, error) {
+	switch o.Provider {
+	case AuthNone:
+		return nil, nil
+	case AuthStatic:
+		password := ""
+		if len(o.PasswordFile) != 0 {
+			d, err := ioutil.ReadFile(o.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			password = strings.TrimSpace(string(d))
+		}
+		return &StaticAuth{Username: o.Username, Password: password}, nil
+	case AuthAliyun:
+		return NewAliyunAuthFromEnv(instance)
+	case AuthJWT:
+		d, err := ioutil.ReadFile(o.JWTKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseJWTKey(d)
+		if err != nil {
+			return nil, fmt.Errorf("parse jwt key file %s error %v", o.JWTKeyFile, err)
+		}
+		return NewJWTAuth(key, o.Username, o.JWTAudience, time.Duration(o.JWTLifetime)*time.Second)
+	case AuthClientCertificate:
+		return &ClientCertificateAuth{Username: o.Username}, nil
+	}
+	return nil, fmt.Errorf("unsupported mqtt auth %s", o.Provider)
+}
+
+// credentials returns the credentials of clientID by auth, empty if auth is nil.
+func credentials(auth AuthProvider, clientID string) (string, string) {
+	if auth == nil {
+		return "", ""
+	}
+	username, password, err := auth.Credentials(clientID)
+	if err != nil {
+		klog.Errorf("Get mqtt credentials of client %s error %v", clientID, err)
+		return "", ""
+	}
+	return username, password
+}
+
+// StaticAuth connects all the clients with the same username and password.
+type StaticAuth struct {
+	Username string
+	Password string
+}
+
+func (a *StaticAuth) Credentials(clientID string) (string, string, error) {
+	return a.Username, a.Password, nil
+}
+
+// AliyunAuth connects the clients with the Aliyun signatures of their client ids.
+type AliyunAuth struct {
+	AccessKey    string
+	AccessSecret string
+	Instance     string
+}
+
+// NewAliyunAuthFromEnv creates the AliyunAuth of instance by the env ACCESS_KEY and ACCESS_SECRET.
+func NewAliyunAuthFromEnv(instance string) (*AliyunAuth, error) {
+	key := os.Getenv("ACCESS_KEY")
+	secret := os.Getenv("ACCESS_SECRET")
+	if len(key) == 0 || len(secret) == 0 {
+		klog.Errorf("ACCESS_KEY or ACCESS_SECRET is nil")
+		return nil, fmt.Errorf("accesskey or secret is nil")
+	}
+	return &AliyunAuth{
+		AccessKey:    key,
+		AccessSecret: secret,
+		Instance:     instance,
+	}, nil
+}
+
+func (a *AliyunAuth) Credentials(clientID string) (string, string, error) {
+	return fmt.Sprintf("Signature|%s|%s", a.AccessKey, a.Instance), util.GetSignature(clientID, a.AccessSecret), nil
+}
+
+// ClientCertificateAuth authenticates the clients by their TLS client certificates, only the username is sent if it is set.
+type ClientCertificateAuth struct {
+	Username string
+}
+
+func (a *ClientCertificateAuth) Credentials(clientID string) (string, string, error) {
+	return a.Username, "", nil
+}
+
+// jwtRefreshAt is the part of the lifetime a JWT is used for, so it is refreshed before it expires
+const jwtRefreshAt = 0.8
+
+type jwtToken struct {
+	token     string
+	refreshAt time.Time
+}
+
+// JWTAuth connects the clients with JWTs signed by the key of the node as the password. The JWT of a client
+// is reused by its reconnections until 80% of its lifetime passes, then a new one is signed.
+type JWTAuth struct {
+	key      crypto.Signer
+	alg      string
+	username string
+	audience string
+	lifetime time.Duration
+	now      func() time.Time
+
+	lock   sync.Mutex
+	tokens map[string]*jwtToken
+}
+
+// NewJWTAuth creates the JWTAuth signing by key, which must be an ecdsa P-256 or rsa key.
+// The username is the client id if it is empty.
+func NewJWTAuth(key crypto.Signer, username, audience string, lifetime time.Duration) (*JWTAuth, error) {
+	a := &JWTAuth{
+		key:      key,
+		username: username,
+		audience: audience,
+		lifetime: lifetime,
+		now:      time.Now,
+		tokens:   make(map[string]*jwtToken),
+	}
+	switch k := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize != 256 {
+			return nil, fmt.Errorf("jwt ecdsa key must be P-256")
+		}
+		a.alg = "ES256"
+	case *rsa.PublicKey:
+		a.alg = "RS256"
+	default:
+		return nil, fmt.Errorf("unsupported jwt key %T", k)
+	}
+	return a, nil
+}
+
+func (a *JWTAuth) Credentials(clientID string) (string, string, error) {
+	username := a.username
+	if len(username) == 0 {
+		username = clientID
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := a.now()
+	if t, ok := a.tokens[clientID]; ok && now.Before(t.refreshAt) {
+		return username, t.token, nil
+	}
+	token, err := a.sign(username, now)
+	if err != nil {
+		return "", "", err
+	}
+	a.tokens[clientID] = &jwtToken{
+		token:     token,
+		refreshAt: now.Add(time.Duration(float64(a.lifetime) * jwtRefreshAt)),
+	}
+	return username, token, nil
+}
+
+func (a *JWTAuth) sign(subject string, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": a.alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(a.lifetime).Unix(),
+	}
+	if len(a.audience) != 0 {
+		claims["aud"] = a.audience
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch key := a.key.(type) {
+	case *ecdsa.PrivateKey:
+		// the ES256 signature is r and s in 32 bytes each instead of asn.1
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", err
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		if signature, err = a.key.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+			return "", err
+		}
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseJWTKey parses the PEM encoded ecdsa or rsa private key.
+func parseJWTKey(d []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(d)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key %T", key)
+	}
+	return signer, nil
+}
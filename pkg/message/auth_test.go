@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package message
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openyurtio/kole/pkg/util"
+)
+
+func TestJWTAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewJWTAuth(key, "", "kole", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	auth.now = func() time.Time { return now }
+
+	username, token, err := auth.Credentials("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "node-1" {
+		t.Errorf("expected username of the client id, got %s", username)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid jwt %s", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("invalid ES256 signature %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Errorf("expected the jwt verified by the key")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := struct {
+		Sub string `json:"sub"`
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Sub != "node-1" || claims.Aud != "kole" || claims.Exp != now.Add(time.Hour).Unix() {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// the jwt is reused by the reconnections until it is about to expire
+	now = now.Add(30 * time.Minute)
+	if _, reused, _ := auth.Credentials("node-1"); reused != token {
+		t.Errorf("expected the jwt reused")
+	}
+	now = now.Add(20 * time.Minute)
+	if _, refreshed, _ := auth.Credentials("node-1"); refreshed == token {
+		t.Errorf("expected the jwt refreshed before it expires")
+	}
+}
+
+func TestAliyunAuth(t *testing.T) {
+	auth := &AliyunAuth{AccessKey: "key", AccessSecret: "secret", Instance: "instance"}
+	username, password, err := auth.Credentials("GID@@@node-sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "Signature|key|instance" || password != util.GetSignature("GID@@@node-sub", "secret") {
+		t.Errorf("unexpected credentials %s %s", username, password)
+	}
+}
+
+func TestAuthOptionsValidate(t *testing.T) {
+	cases := []struct {
+		options            *AuthOptions
+		tls                *TLSOptions
+		clientCertificate  bool
+		expectedValidation bool
+	}{
+		{&AuthOptions{Provider: AuthNone}, nil, false, true},
+		{&AuthOptions{Provider: "token"}, nil, false, false},
+		{&AuthOptions{Provider: AuthStatic}, nil, false, false},
+		{&AuthOptions{Provider: AuthStatic, Username: "kole"}, nil, false, true},
+		{&AuthOptions{Provider: AuthJWT, JWTLifetime: 3600}, nil, false, false},
+		{&AuthOptions{Provider: AuthJWT, JWTKeyFile: "jwt.key", JWTLifetime: 10}, nil, false, false},
+		{&AuthOptions{Provider: AuthClientCertificate}, &TLSOptions{}, false, false},
+		{&AuthOptions{Provider: AuthClientCertificate}, &TLSOptions{CertFile: "tls.crt", KeyFile: "tls.key"}, false, true},
+		{&AuthOptions{Provider: AuthClientCertificate}, &TLSOptions{}, true, true},
+	}
+	for i, c := range cases {
+		err := c.options.Validate(c.tls, c.clientCertificate)
+		if (err == nil) != c.expectedValidation {
+			t.Errorf("case %d: expected validation %v, got %v", i, c.expectedValidation, err)
+		}
+	}
+}
@@ -19,6 +19,16 @@ import (
 	"context"
 )
 
+// Will is the last will of a client, the broker publishes it once the client is disconnected without a DISCONNECT.
+type Will struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+	// Delay is the seconds the broker waits for the client to reconnect before publishing the will, MQTT5 only
+	Delay uint32
+}
+
 type MessageHandler interface {
 	PublishData(ctx context.Context, topic string, qos byte, retained bool, object interface{}) error
 	PublishAck(ctx context.Context, topic string, qos byte, retained bool, object interface{}) error
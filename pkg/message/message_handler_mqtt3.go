@@ -18,13 +18,10 @@ package message
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
 	outmqtt "github.com/eclipse/paho.mqtt.golang"
 	"k8s.io/klog/v2"
-
-	"github.com/openyurtio/kole/pkg/util"
 )
 
 type Mqtt3Handler struct {
@@ -65,18 +62,12 @@ func (l *Mqtt3Handler) subscribeTopics() {
 func NewMqtt3Handler(
 	broker string,
 	port int,
-	instance,
 	group string,
 	hostname string,
-	subTopicsHandlers map[string]outmqtt.MessageHandler) (*Mqtt3Handler, error) {
-
-	key := os.Getenv("ACCESS_KEY")
-	secret := os.Getenv("ACCESS_SECRET")
-
-	if len(key) == 0 || len(secret) == 0 {
-		klog.Errorf("ACCESS_KEY[%s] or ACCESS_SECRET[%s] is nil", key, secret)
-		return nil, fmt.Errorf("accesskey or secret is nil")
-	}
+	subTopicsHandlers map[string]outmqtt.MessageHandler,
+	will *Will,
+	tlsOptions *TLSOptions,
+	auth AuthProvider) (*Mqtt3Handler, error) {
 
 	/*
 		deviceName := os.Getenv("HOSTNAME")
@@ -86,28 +77,26 @@ func NewMqtt3Handler(
 		}
 	*/
 
+	tlsConfig, err := NewTLSConfig(tlsOptions, broker)
+	if err != nil {
+		klog.Errorf("New tls config of mqtt broker %s error %v", broker, err)
+		return nil, err
+	}
+
 	h := &Mqtt3Handler{
 		SubTopicHandlers: subTopicsHandlers,
 	}
 
 	clientID := fmt.Sprintf("%s@@@%s-sub", group, hostname)
-	username := fmt.Sprintf("Signature|%s|%s", key, instance)
-	passwd := util.GetSignature(clientID, secret)
-
 	h.MqttSubClient = NewMqtt3Client(broker,
-		port, clientID, username, passwd, true, true, h.Reconnect, h.LostConnectHandler)
+		port, clientID, auth, true, true, h.Reconnect, h.LostConnectHandler, nil, tlsConfig)
 
 	pubClientID := fmt.Sprintf("%s@@@%s-pub", group, hostname)
-	pubUsername := fmt.Sprintf("Signature|%s|%s", key, instance)
-	pubPasswd := util.GetSignature(pubClientID, secret)
-
-	h.MqttDataClient = NewMqtt3Client(broker, port, pubClientID, pubUsername, pubPasswd, true, true, h.ReconnectNoSub, h.LostConnectHandler)
+	// the heartbeats are published by the data client, so its will is published once the heartbeats stop
+	h.MqttDataClient = NewMqtt3Client(broker, port, pubClientID, auth, true, true, h.ReconnectNoSub, h.LostConnectHandler, will, tlsConfig)
 
 	ackClientID := fmt.Sprintf("%s@@@%s-ack", group, hostname)
-	ackUsername := fmt.Sprintf("Signature|%s|%s", key, instance)
-	ackPasswd := util.GetSignature(ackClientID, secret)
-
-	h.MqttAckClient = NewMqtt3Client(broker, port, ackClientID, ackUsername, ackPasswd, true, true, h.ReconnectNoSub, h.LostConnectHandler)
+	h.MqttAckClient = NewMqtt3Client(broker, port, ackClientID, auth, true, true, h.ReconnectNoSub, h.LostConnectHandler, nil, tlsConfig)
 
 	return h, nil
 }
@@ -34,8 +34,15 @@ type Mqtt5Handler struct {
 func NewMqtt5Handler(server string,
 	subs []*SingleSubcribe,
 	hostnameOverride string,
-	oneClient bool) (*Mqtt5Handler, error) {
-	var err error
+	oneClient bool,
+	will *Will,
+	tlsOptions *TLSOptions,
+	auth AuthProvider) (*Mqtt5Handler, error) {
+	tlsConfig, err := newServerTLSConfig(tlsOptions, server)
+	if err != nil {
+		klog.Errorf("New tls config of mqtt server %s error %v", server, err)
+		return nil, err
+	}
 	h := &Mqtt5Handler{
 		OneClient: oneClient,
 	}
@@ -47,7 +54,7 @@ func NewMqtt5Handler(server string,
 		65535,
 		time.Second*5,
 		time.Minute*60,
-		server, fmt.Sprintf("%s-sub", hostnameOverride), subs)
+		server, fmt.Sprintf("%s-sub", hostnameOverride), will, tlsConfig, auth, subs)
 	if err != nil {
 		klog.Errorf("New mqtt sub client error %v", err)
 		return nil, err
@@ -64,7 +71,7 @@ func NewMqtt5Handler(server string,
 		10000,
 		time.Second*5,
 		time.Second*1200,
-		server, fmt.Sprintf("%s-ack", hostnameOverride), nil)
+		server, fmt.Sprintf("%s-ack", hostnameOverride), nil, tlsConfig, auth, nil)
 	if err != nil {
 		klog.Errorf("New mqtt pub client error %v", err)
 		return nil, err
@@ -77,7 +84,7 @@ func NewMqtt5Handler(server string,
 		10000,
 		time.Second*5,
 		time.Second*1200,
-		server, fmt.Sprintf("%s-data", hostnameOverride), nil)
+		server, fmt.Sprintf("%s-data", hostnameOverride), nil, tlsConfig, auth, nil)
 	if err != nil {
 		klog.Errorf("New mqtt pub client error %v", err)
 		return nil, err
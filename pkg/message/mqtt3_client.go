@@ -16,6 +16,7 @@ limitations under the License.
 package message
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -33,27 +34,30 @@ var defaultConnectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Clie
 }
 
 func NewSessionMqtt3Client(broker string, port int, clientid, username, passwd string) mqtt.Client {
-	return NewMqtt3Client(broker, port, clientid, username, passwd, false, true, defaultConnectHandler, defaultConnectLostHandler)
+	return NewMqtt3Client(broker, port, clientid, &StaticAuth{Username: username, Password: passwd}, false, true, defaultConnectHandler, defaultConnectLostHandler, nil, nil)
 }
 
 func NewMqtt3Client(
 	broker string,
 	port int,
-	clientid,
-	username,
-	passwd string,
+	clientid string,
+	auth AuthProvider,
 	cleanSession bool,
 	order bool,
 	connectHandler mqtt.OnConnectHandler,
-	connectLostHandler mqtt.ConnectionLostHandler) mqtt.Client {
+	connectLostHandler mqtt.ConnectionLostHandler,
+	will *Will,
+	tlsConfig *tls.Config) mqtt.Client {
 
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(fmt.Sprintf("ssl://%s:%d", broker, port))
 	opts.SetCleanSession(cleanSession)
 
 	opts.SetClientID(clientid)
-	opts.SetUsername(username)
-	opts.SetPassword(passwd)
+	// the credentials are asked at every connection, so the expiring ones are refreshed for the reconnections
+	opts.SetCredentialsProvider(func() (string, string) {
+		return credentials(auth, clientid)
+	})
 	opts.SetOrderMatters(order)
 	// 设置重新使用resumesub
 	opts.SetResumeSubs(true)
@@ -68,6 +72,12 @@ func NewMqtt3Client(
 	if connectLostHandler != nil {
 		opts.SetConnectionLostHandler(connectLostHandler)
 	}
+	if will != nil {
+		opts.SetBinaryWill(will.Topic, will.Payload, will.QoS, will.Retain)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 	//opts.SetKeepAlive(30 * time.Second)
 	//opts.SetConnectTimeout(30 * time.Second)
 	//opts.SetConnectRetryInterval(10 * time.Second)
@@ -17,8 +17,11 @@ package message
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/eclipse/paho.golang/autopaho"
@@ -26,6 +29,28 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// SharedSubscriptionPrefix is the prefix of MQTT5 shared subscriptions,
+// the messages of a shared subscription are spread across the clients subscribing it with the same group.
+const SharedSubscriptionPrefix = "$share/"
+
+// SharedTopic returns the shared subscription of topic in group.
+func SharedTopic(group, topic string) string {
+	return SharedSubscriptionPrefix + group + "/" + topic
+}
+
+// TopicOfSubscription returns the topic filter of a subscription without the shared subscription prefix,
+// the messages received by a shared subscription carry the topic without the prefix.
+func TopicOfSubscription(subscription string) string {
+	if !strings.HasPrefix(subscription, SharedSubscriptionPrefix) {
+		return subscription
+	}
+	parts := strings.SplitN(subscription, "/", 3)
+	if len(parts) < 3 {
+		return subscription
+	}
+	return parts[2]
+}
+
 type SingleSubcribe struct {
 	Topic   string
 	Handler func(publish *paho.Publish)
@@ -42,6 +67,9 @@ func NewMqtt5Manager(
 	packetTimeout time.Duration,
 	server string,
 	clientid string,
+	will *Will,
+	tlsConfig *tls.Config,
+	auth AuthProvider,
 	subs []*SingleSubcribe,
 ) (*autopaho.ConnectionManager, error) {
 
@@ -55,6 +83,7 @@ func NewMqtt5Manager(
 		BrokerUrls:        []*url.URL{serverURL},
 		KeepAlive:         keepAlive,
 		ConnectRetryDelay: connectRetryDelay,
+		TlsCfg:            tlsConfig,
 		OnConnectError: func(err error) {
 			klog.Errorf("Whilst attempting connection cliendid %s error: %s\n", clientid, err)
 		},
@@ -79,7 +108,7 @@ func NewMqtt5Manager(
 		subscribeOps := make(map[string]paho.SubscribeOptions)
 
 		for _, single := range subs {
-			router.RegisterHandler(single.Topic, single.Handler)
+			router.RegisterHandler(TopicOfSubscription(single.Topic), single.Handler)
 			subscribeOps[single.Topic] = single.Option
 		}
 
@@ -102,10 +131,29 @@ func NewMqtt5Manager(
 
 	cliCfg.SetConnectPacketConfigurator(func(connect *paho.Connect) *paho.Connect {
 		connect.CleanStart = cleanStart
+		// the configurator is called at every connection, so the expiring credentials are refreshed for the reconnections
+		if username, password := credentials(auth, clientid); len(username) != 0 || len(password) != 0 {
+			connect.UsernameFlag = len(username) != 0
+			connect.Username = username
+			connect.PasswordFlag = len(password) != 0
+			connect.Password = []byte(password)
+		}
 		connect.Properties = &paho.ConnectProperties{
 			SessionExpiryInterval: &sessionExpiryInterval,
 			ReceiveMaximum:        &receiveMaximum,
 		}
+		if will != nil {
+			connect.WillMessage = &paho.WillMessage{
+				Retain:  will.Retain,
+				QoS:     will.QoS,
+				Topic:   will.Topic,
+				Payload: will.Payload,
+			}
+			delay := will.Delay
+			connect.WillProperties = &paho.WillProperties{
+				WillDelayInterval: &delay,
+			}
+		}
 		return connect
 	})
 
@@ -158,3 +206,31 @@ func Mqtt5Send(ctx context.Context,
 	klog.V(4).Infof("sent %s message: %s", topic, data)
 	return nil
 }
+
+// NewMqtt5Consumers creates num clients subscribing subs, which are usually shared subscriptions,
+// so the messages are spread across the clients.
+func NewMqtt5Consumers(server string, subs []*SingleSubcribe, hostnameOverride string, num int, tlsOptions *TLSOptions,
+	auth AuthProvider) ([]*autopaho.ConnectionManager, error) {
+	tlsConfig, err := newServerTLSConfig(tlsOptions, server)
+	if err != nil {
+		klog.Errorf("New tls config of mqtt server %s error %v", server, err)
+		return nil, err
+	}
+	consumers := make([]*autopaho.ConnectionManager, 0, num)
+	for i := 0; i < num; i++ {
+		cm, err := NewMqtt5Manager(context.Background(),
+			30,
+			3600,
+			true,
+			65535,
+			time.Second*5,
+			time.Minute*60,
+			server, fmt.Sprintf("%s-consumer-%d", hostnameOverride, i), nil, tlsConfig, auth, subs)
+		if err != nil {
+			klog.Errorf("New mqtt consumer %d error %v", i, err)
+			return nil, err
+		}
+		consumers = append(consumers, cm)
+	}
+	return consumers, nil
+}
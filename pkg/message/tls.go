@@ -0,0 +1,269 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package message
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// TLSOptions configures the TLS of the connections to the MQTT broker.
+type TLSOptions struct {
+	// the PEM encoded CA bundle verifying the broker, the system roots are used if it is empty
+	CAFile string
+	// the PEM encoded client certificate and key presented to the broker for mTLS
+	CertFile string
+	KeyFile  string
+	// the name verified in the certificate of the broker, the host of the broker by default
+	ServerName string
+	// the minimum TLS version, 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// GetClientCertificate returns the client certificate preferred to CertFile, such as the certificate issued to the node,
+	// the certificate of CertFile is presented if it returns no certificate
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsSchemes are the schemes of the MQTT5 servers connected by TLS.
+var tlsSchemes = map[string]bool{
+	"ssl":      true,
+	"tls":      true,
+	"mqtts":    true,
+	"mqtt+ssl": true,
+	"tcps":     true,
+	"wss":      true,
+}
+
+// Configured returns true if any TLS setting is given, otherwise the defaults of the clients are used.
+func (o *TLSOptions) Configured() bool {
+	return o != nil && (len(o.CAFile) != 0 || len(o.CertFile) != 0 || len(o.KeyFile) != 0 ||
+		len(o.ServerName) != 0 || len(o.MinVersion) != 0 || o.GetClientCertificate != nil)
+}
+
+// Validate checks the settings and loads the files once, so the misconfigurations are reported at startup.
+func (o *TLSOptions) Validate() error {
+	if !o.Configured() {
+		return nil
+	}
+	if len(o.MinVersion) != 0 {
+		if _, ok := tlsVersions[o.MinVersion]; !ok {
+			return fmt.Errorf("unsupported tls version %s, must be 1.0, 1.1, 1.2 or 1.3", o.MinVersion)
+		}
+	}
+	if (len(o.CertFile) == 0) != (len(o.KeyFile) == 0) {
+		return fmt.Errorf("the client certificate and key must be set together")
+	}
+	r := &tlsReloader{options: o}
+	return r.reload()
+}
+
+// ValidateServerURL checks the MQTT5 server connected by TLS if the TLS settings are given.
+func (o *TLSOptions) ValidateServerURL(server string) error {
+	if !o.Configured() {
+		return nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	if !tlsSchemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("mqtt server %s is not connected by tls, but tls is configured", server)
+	}
+	return nil
+}
+
+// NewTLSConfig returns the TLS config of the connections to host, nil if no TLS setting is given so the defaults are used.
+// The CA bundle and the client certificate are reloaded at the handshakes once their files change,
+// so they can be rotated without restarting.
+func NewTLSConfig(o *TLSOptions, host string) (*tls.Config, error) {
+	if !o.Configured() {
+		return nil, nil
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	r := &tlsReloader{
+		options:    o,
+		serverName: o.ServerName,
+	}
+	if len(r.serverName) == 0 {
+		r.serverName = host
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:           r.serverName,
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: r.getClientCertificate,
+	}
+	if len(o.MinVersion) != 0 {
+		config.MinVersion = tlsVersions[o.MinVersion]
+	}
+	if len(o.CAFile) != 0 {
+		// the roots of the config can not be replaced once the clients are created, so the broker is verified
+		// by verifyConnection against the CA bundle loaded last instead
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = r.verifyConnection
+	}
+	return config, nil
+}
+
+// tlsReloader keeps the CA bundle and the client certificate of TLSOptions, and reloads them once their files change.
+type tlsReloader struct {
+	options    *TLSOptions
+	serverName string
+
+	lock        sync.Mutex
+	caModTime   time.Time
+	certModTime time.Time
+	keyModTime  time.Time
+	roots       *x509.CertPool
+	cert        *tls.Certificate
+}
+
+func modTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// reload loads the files changed since they were loaded last. The files loaded are kept if the changed ones are invalid.
+func (r *tlsReloader) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if file := r.options.CAFile; len(file) != 0 {
+		t, err := modTime(file)
+		if err != nil {
+			return err
+		}
+		if r.roots == nil || !t.Equal(r.caModTime) {
+			d, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(d) {
+				return fmt.Errorf("no certificate found in ca file %s", file)
+			}
+			if r.roots != nil {
+				klog.Infof("Reloaded mqtt ca file %s", file)
+			}
+			r.roots, r.caModTime = roots, t
+		}
+	}
+
+	if len(r.options.CertFile) != 0 {
+		certTime, err := modTime(r.options.CertFile)
+		if err != nil {
+			return err
+		}
+		keyTime, err := modTime(r.options.KeyFile)
+		if err != nil {
+			return err
+		}
+		if r.cert == nil || !certTime.Equal(r.certModTime) || !keyTime.Equal(r.keyModTime) {
+			cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+			if err != nil {
+				return fmt.Errorf("load client certificate %s error %v", r.options.CertFile, err)
+			}
+			if r.cert != nil {
+				klog.Infof("Reloaded mqtt client certificate %s", r.options.CertFile)
+			}
+			r.cert, r.certModTime, r.keyModTime = &cert, certTime, keyTime
+		}
+	}
+	return nil
+}
+
+func (r *tlsReloader) reloadOrWarn() {
+	if err := r.reload(); err != nil {
+		klog.Warningf("Reload mqtt tls files error %v, keep the files loaded last", err)
+	}
+}
+
+func (r *tlsReloader) getClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if r.options.GetClientCertificate != nil {
+		cert, err := r.options.GetClientCertificate(info)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && len(cert.Certificate) != 0 {
+			return cert, nil
+		}
+	}
+	r.reloadOrWarn()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cert == nil {
+		// no certificate is presented
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+// verifyConnection verifies the certificate of the broker against the CA bundle loaded last.
+func (r *tlsReloader) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("mqtt broker presents no certificate")
+	}
+	r.reloadOrWarn()
+	r.lock.Lock()
+	roots := r.roots
+	r.lock.Unlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       r.serverName,
+	})
+	return err
+}
+
+// newServerTLSConfig returns the TLS config of the connections to the MQTT5 server.
+func newServerTLSConfig(o *TLSOptions, server string) (*tls.Config, error) {
+	if err := o.ValidateServerURL(server); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	return NewTLSConfig(o, u.Hostname())
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2022 The OpenYurt Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package message
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writeTestCertificate(t *testing.T, file string, cert *x509.Certificate, modTime time.Time) {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestKey(t *testing.T, file string, key *ecdsa.PrivateKey, modTime time.Time) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if config, err := NewTLSConfig(&TLSOptions{}, "broker"); err != nil || config != nil {
+		t.Fatalf("expected no tls config without settings, got %v %v", config, err)
+	}
+
+	dir, err := ioutil.TempDir("", "mqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	then := time.Now().Add(-time.Minute)
+	ca, caKey := newTestCertificate(t, "ca", nil, nil)
+	server, _ := newTestCertificate(t, "broker", ca, caKey)
+	client, clientKey := newTestCertificate(t, "node", ca, caKey)
+	writeTestCertificate(t, caFile, ca, then)
+	writeTestCertificate(t, certFile, client, then)
+	writeTestKey(t, keyFile, clientKey, then)
+
+	config, err := NewTLSConfig(&TLSOptions{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "1.3",
+	}, "broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS13 || config.ServerName != "broker" {
+		t.Errorf("unexpected min version %d or server name %s", config.MinVersion, config.ServerName)
+	}
+	if err := config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{server}}); err != nil {
+		t.Errorf("expected the broker verified, got %v", err)
+	}
+	cert, err := config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || len(cert.Certificate) == 0 || string(cert.Certificate[0]) != string(client.Raw) {
+		t.Fatalf("expected the client certificate of file, got %v", err)
+	}
+
+	// the rotated files are loaded at the next handshake
+	other, otherKey := newTestCertificate(t, "other-ca", nil, nil)
+	rotated, rotatedKey := newTestCertificate(t, "node", other, otherKey)
+	writeTestCertificate(t, caFile, other, time.Now())
+	writeTestCertificate(t, certFile, rotated, time.Now())
+	writeTestKey(t, keyFile, rotatedKey, time.Now())
+	if err := config.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{server}}); err == nil {
+		t.Errorf("expected the broker rejected by the rotated ca")
+	}
+	cert, err = config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || string(cert.Certificate[0]) != string(rotated.Raw) {
+		t.Errorf("expected the rotated client certificate, got %v", err)
+	}
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	if err := (&TLSOptions{MinVersion: "1.4"}).Validate(); err == nil {
+		t.Errorf("expected unsupported tls version rejected")
+	}
+	if err := (&TLSOptions{CertFile: "tls.crt"}).Validate(); err == nil {
+		t.Errorf("expected client certificate without key rejected")
+	}
+	if err := (&TLSOptions{MinVersion: "1.2"}).ValidateServerURL("tcp://broker:1883"); err == nil {
+		t.Errorf("expected plain mqtt server rejected with tls")
+	}
+	if err := (&TLSOptions{}).ValidateServerURL("tcp://broker:1883"); err != nil {
+		t.Errorf("expected plain mqtt server accepted without tls, got %v", err)
+	}
+}
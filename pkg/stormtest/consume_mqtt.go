@@ -44,10 +44,22 @@ func NewConsumeMqtt(config *options.ConsumeMqttFlags) (*ConsumeMqtt, error) {
 		Topic:      config.Topic,
 	}
 
-	h, err := message.NewMqtt3Handler(config.MqttBroker, config.MqttBrokerPort, config.MqttInstance, config.MqttGroup,
+	auth := &message.AliyunAuth{
+		AccessKey:    config.AccessKey,
+		AccessSecret: config.AccessSecret,
+		Instance:     config.MqttInstance,
+	}
+	if len(auth.AccessKey) == 0 || len(auth.AccessSecret) == 0 {
+		envAuth, err := message.NewAliyunAuthFromEnv(config.MqttInstance)
+		if err != nil {
+			return nil, err
+		}
+		auth = envAuth
+	}
+	h, err := message.NewMqtt3Handler(config.MqttBroker, config.MqttBrokerPort, config.MqttGroup,
 		"consume-sub", map[string]outmqtt.MessageHandler{
 			t.Topic: t.testSub,
-		})
+		}, nil, nil, auth)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +55,12 @@ func NewCreateMqttMessage(config *options.CreateMqttMessageFlags) (*CreateMqttMe
 		Topic:      config.Topic,
 	}
 
-	h, err := message.NewMqtt3Handler(config.MqttBroker, config.MqttBrokerPort, config.MqttInstance, config.MqttGroup, hostnameOverride, map[string]outmqtt.MessageHandler{})
+	auth := &message.AliyunAuth{
+		AccessKey:    config.AccessKey,
+		AccessSecret: config.AccessSecret,
+		Instance:     config.MqttInstance,
+	}
+	h, err := message.NewMqtt3Handler(config.MqttBroker, config.MqttBrokerPort, config.MqttGroup, hostnameOverride, map[string]outmqtt.MessageHandler{}, nil, nil, auth)
 	if err != nil {
 		return nil, fmt.Errorf("NewMqtt3Handler error")
 	}
@@ -15,8 +15,40 @@ limitations under the License.
 */
 package util
 
+import "strconv"
+
 const SNAPSHOT_MAX_BUFFER_LEN = 1000 * 1000
 const SNAPSHOT_LABEL_IDENTIFIER = "identifier"
+const SNAPSHOT_LABEL_TIMESTAMP = "timestamp"
 const SNAPSHOT_LABEL_SUMMARY = "summary"
 const SNAPSHOT_LABEL_SUMMARY_VALUE = "summary-test"
 const SNAPSHOT_LABEL_MAX_NUM = "maxNum"
+const SNAPSHOT_LABEL_CODEC = "codec"
+const SNAPSHOT_LABEL_SHARD = "shard"
+const SNAPSHOT_LABEL_RESTORED_FROM = "restoredFrom"
+
+// CodecLevelValue is the flag value of a snapshot codec level. The level is nil unless the flag is set,
+// which means the default level of the codec, since 0 and the negative numbers are valid levels of some codecs.
+type CodecLevelValue struct {
+	Level **int
+}
+
+func (v CodecLevelValue) String() string {
+	if v.Level == nil || *v.Level == nil {
+		return ""
+	}
+	return strconv.Itoa(**v.Level)
+}
+
+func (v CodecLevelValue) Set(s string) error {
+	level, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.Level = &level
+	return nil
+}
+
+func (v CodecLevelValue) Type() string {
+	return "int"
+}
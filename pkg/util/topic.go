@@ -25,8 +25,20 @@ var TopicHeartBeat string
 var TopicCTLPrefix string
 var TopicDataPrefix string
 
+// TopicHeartBeatRoutePrefix is the prefix of the topics forwarding heartbeats to the controllers owning the nodes
+var TopicHeartBeatRoutePrefix string
+
+// TopicWill is the topic of the last wills of the nodes, which are published by the broker when the nodes are disconnected
+var TopicWill string
+
+// TopicCertificateRequest is the topic of the certificate requests of the nodes, the certificates are sent back by CTL
+var TopicCertificateRequest string
+
 func init() {
 	TopicHeartBeat = filepath.Join(TopicRoot, "HEARTBEAT")
 	TopicCTLPrefix = filepath.Join(TopicRoot, "CTL")
 	TopicDataPrefix = filepath.Join(TopicRoot, "DATA")
+	TopicHeartBeatRoutePrefix = filepath.Join(TopicRoot, "HEARTBEAT-ROUTE")
+	TopicWill = filepath.Join(TopicRoot, "WILL")
+	TopicCertificateRequest = filepath.Join(TopicRoot, "CSR")
 }